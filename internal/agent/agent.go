@@ -3,9 +3,17 @@
 // It consists of the following parts:
 //   - agent.go - agent struct and its lifecycle methods
 //   - config.go - agent configuration options
-//   - collect.go - agent metrics and collect methods
+//   - collect.go - agent metrics collectors and their registry
+//   - collect_disk.go - filesystem and disk I/O collector
+//   - collect_net.go - network interfaces and TCP connections collector
+//   - collect_host.go - load average, uptime and kernel activity collector
+//   - collect_process.go - per-process resource usage collector
+//   - collect_cgroup.go - cgroup v2 container resource usage collector
+//   - collect_exec.go - custom commands output collector
+//   - collect_statsd.go - embedded StatsD server
 //   - secure.go - agent metrics hash protection
 //   - send.go - agent metrics send methods
+//   - spool.go - agent on-disk buffer for batches failed to send
 package agent
 
 import (
@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Batch is a set of metrics sent to server in one request.
+//
+// Batch ID is sent along with metrics so server could skip
+// a batch it has already applied, e.g. when the agent didn't get a response
+// and resends the batch or the spool replays it.
+type Batch struct {
+	ID      string   `json:"id"`
+	Metrics []Metric `json:"metrics"`
+}
+
+// newBatch builds a batch with a new random ID.
+func newBatch(metrics []Metric) Batch {
+	return Batch{
+		ID:      newBatchID(),
+		Metrics: metrics,
+	}
+}
+
+// newBatchID generates a random batch ID.
+func newBatchID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
+// report sends collected metrics to server.
+// Batch which failed to send is kept unacknowledged and resent
+// with the same ID before a new one is built, so server skips it
+// if it was applied but the response was lost.
+// Pending counter deltas and summary observations are committed
+// only after their batch is acknowledged.
+// Rejected batches are dropped and their metrics are sent with the next batch.
+func (a *GenericAgent) report(ctx context.Context, send sendFunc) error {
+	if a.unacked != nil {
+		batch := *a.unacked
+
+		err := a.deliver(ctx, batch, send)
+		if err != nil && !isPermanent(err) {
+			return err
+		}
+
+		a.unacked = nil
+		if err != nil {
+			return err
+		}
+
+		commitMetrics(a.metrics, batch.Metrics)
+	}
+
+	batch := newBatch(prepareMetrics(a.metrics, a.host, a.labels, a.key))
+
+	if err := a.deliver(ctx, batch, send); err != nil {
+		if !isPermanent(err) {
+			a.unacked = &batch
+		}
+
+		return err
+	}
+
+	commitMetrics(a.metrics, batch.Metrics)
+
+	return nil
+}
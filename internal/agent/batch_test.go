@@ -0,0 +1,50 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReport(t *testing.T) {
+	agent := &GenericAgent{metrics: &sync.Map{}}
+
+	storeMetrics(agent.metrics, []Metric{newCounter("TestCounter", 2)})
+
+	failedIDs := []string{}
+	err := agent.report(context.Background(), func(ctx context.Context, batch Batch) error {
+		failedIDs = append(failedIDs, batch.ID)
+		return errors.New("response lost")
+	})
+	require.Error(t, err)
+
+	storeMetrics(agent.metrics, []Metric{newCounter("TestCounter", 3)})
+
+	// Unacknowledged batch is resent with its ID before a new one
+	sent := []Batch{}
+	err = agent.report(context.Background(), func(ctx context.Context, batch Batch) error {
+		sent = append(sent, batch)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Len(t, sent, 2)
+	require.Equal(t, failedIDs[0], sent[0].ID)
+	require.Equal(t, counter(2), *sent[0].Metrics[0].Delta)
+	require.NotEqual(t, sent[0].ID, sent[1].ID)
+	require.Equal(t, counter(3), *sent[1].Metrics[0].Delta)
+	require.Nil(t, agent.unacked)
+
+	stored, _ := agent.metrics.Load(seriesKey{id: "TestCounter"})
+	require.Equal(t, counter(0), *stored.(*counter))
+
+	// Rejected batch is not resent
+	storeMetrics(agent.metrics, []Metric{newCounter("TestCounter", 1)})
+	err = agent.report(context.Background(), func(ctx context.Context, batch Batch) error {
+		return permanentError{errors.New("bad request")}
+	})
+	require.Error(t, err)
+	require.Nil(t, agent.unacked)
+}
@@ -5,11 +5,18 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/mem"
+
+	"github.com/horseinthesky/metricsagent/internal/labels"
+	"github.com/horseinthesky/metricsagent/internal/sketch"
 )
 
 // Agent custom metric types
@@ -18,84 +25,507 @@ type (
 	counter = int64
 )
 
-// collectPSUtilMetrics runs updatePSUtilMetrics every config.PollInterval.
-// Also handles graceful shutdown.
-func (a *GenericAgent) collectPSUtilMetrics(ctx context.Context) {
+// seriesKey identifies a series in agent storage.
+// Metrics with the same ID and different labels are different series.
+type seriesKey struct {
+	id     string
+	labels string // canonical label set form
+}
+
+// key returns metric series key in agent storage.
+func (m Metric) key() seriesKey {
+	return seriesKey{id: m.ID, labels: labels.Format(m.Labels)}
+}
+
+// summary keeps a sketch of observations which are not sent yet.
+type summary struct {
+	sync.Mutex
+	sketch *sketch.Sketch
+}
+
+// intervalGauge keeps a gauge of a single collection interval
+// such as StatsD timer aggregates. It is sent until acknowledged
+// and then forgotten unless collected again meanwhile.
+type intervalGauge struct {
+	sync.Mutex
+	value   gauge
+	version uint64 // number of times the gauge is collected
+	dropped bool   // gauge is removed from agent storage
+}
+
+// storeIntervalGauge saves a collected interval gauge to agent storage.
+func storeIntervalGauge(storage *sync.Map, key seriesKey, value gauge) {
 	for {
-		select {
-		case <-ctx.Done():
-			log.Println("psutil data collection cancelled")
+		stored, _ := storage.LoadOrStore(key, &intervalGauge{})
+		pending, ok := stored.(*intervalGauge)
+		if !ok {
+			storage.Store(key, &intervalGauge{value: value, version: 1})
 			return
-		case <-a.PollTicker.C:
-			updatePSUtilMetrics(a.metrics)
+		}
+
+		pending.Lock()
+		dropped := pending.dropped
+		if !dropped {
+			pending.value = value
+			pending.version++
+		}
+		pending.Unlock()
+
+		// Gauge acknowledged meanwhile is stored anew
+		if !dropped {
+			return
+		}
+	}
+}
+
+// Collector is a source of agent metrics.
+// Every collector is polled with its own interval
+// and returns a fresh set of typed metrics on each call.
+type Collector interface {
+	Name() string
+	Interval() time.Duration
+	Collect(ctx context.Context) ([]Metric, error)
+}
+
+// Listener is a Collector which receives metrics in background.
+// Listen is run by the agent before the collector is polled.
+type Listener interface {
+	Collector
+	Listen(ctx context.Context) error
+}
+
+// Registry keeps collectors the agent polls.
+type Registry struct {
+	sync.RWMutex
+	collectors []Collector
+}
+
+// NewRegistry is a Registry constructor.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds a collector to the registry.
+// Collector names must be unique.
+func (r *Registry) Register(c Collector) error {
+	r.Lock()
+	defer r.Unlock()
 
-			log.Println("successfully collected psutil metrics")
+	for _, registered := range r.collectors {
+		if registered.Name() == c.Name() {
+			return fmt.Errorf("collector %s is already registered", c.Name())
 		}
 	}
+
+	r.collectors = append(r.collectors, c)
+
+	return nil
 }
 
-// collectRuntimeMetrics runs updateRuntimeMetrics every config.PollInterval.
+// Disable removes a collector from the registry.
+func (r *Registry) Disable(name string) {
+	r.Lock()
+	defer r.Unlock()
+
+	for i, registered := range r.collectors {
+		if registered.Name() == name {
+			r.collectors = append(r.collectors[:i], r.collectors[i+1:]...)
+			return
+		}
+	}
+}
+
+// Collectors returns registered collectors in registration order.
+func (r *Registry) Collectors() []Collector {
+	r.RLock()
+	defer r.RUnlock()
+
+	collectors := make([]Collector, len(r.collectors))
+	copy(collectors, r.collectors)
+
+	return collectors
+}
+
+// newCollectors builds agent collectors registry.
+// Collectors mentioned in config as disabled are not registered.
+func newCollectors(cfg Config) (*Registry, error) {
+	registry := NewRegistry()
+
+	collectors := []Collector{
+		NewRuntimeCollector(cfg.PollInterval),
+		NewPSUtilCollector(cfg.PollInterval),
+		NewDiskCollector(cfg.Disk, cfg.PollInterval),
+		NewNetCollector(cfg.Net, cfg.PollInterval),
+		NewHostCollector(cfg.PollInterval),
+	}
+
+	if len(cfg.Process.Rules) != 0 {
+		processCollector, err := NewProcessCollector(cfg.Process, cfg.PollInterval)
+		if err != nil {
+			return nil, err
+		}
+
+		collectors = append(collectors, processCollector)
+	}
+
+	if len(cfg.Cgroup.Paths) != 0 || cfg.Cgroup.Root != "" {
+		collectors = append(collectors, NewCgroupCollector(cfg.Cgroup, cfg.PollInterval))
+	}
+
+	for _, command := range cfg.Exec.Commands {
+		execCollector, err := NewExecCollector(command, cfg.PollInterval)
+		if err != nil {
+			return nil, err
+		}
+
+		collectors = append(collectors, execCollector)
+	}
+
+	if cfg.StatsD.UDPAddress != "" || cfg.StatsD.TCPAddress != "" {
+		collectors = append(collectors, NewStatsDCollector(cfg.StatsD, cfg.ReportInterval))
+	}
+
+	for _, c := range collectors {
+		if err := registry.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, name := range cfg.DisabledCollectors {
+		registry.Disable(name)
+	}
+
+	return registry, nil
+}
+
+// runCollector runs collector every its interval and stores collected metrics.
 // Also handles graceful shutdown.
-func (a *GenericAgent) collectRuntimeMetrics(ctx context.Context) {
+func (a *GenericAgent) runCollector(ctx context.Context, c Collector) {
+	ticker := time.NewTicker(c.Interval())
+	defer ticker.Stop()
+
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("runtime data collection cancelled")
+			log.Printf("%s data collection cancelled", c.Name())
 			return
-		case <-a.PollTicker.C:
-			updateRuntimeMetrics(a.metrics)
+		case <-ticker.C:
+			// Metrics collected before an error are stored anyway
+			metrics, err := c.Collect(ctx)
+			storeMetrics(a.metrics, metrics)
+
+			if err != nil {
+				log.Printf("failed to collect %s metrics: %s", c.Name(), err)
+				continue
+			}
+
+			log.Printf("successfully collected %s metrics", c.Name())
+		}
+	}
+}
+
+// storeMetrics saves collected metrics to agent storage.
+// Series are identified by metric ID and labels.
+// Gauges replace stored values, interval gauges are kept until sent.
+// Counter deltas are added to the pending delta which is not sent yet.
+// Summary observations are merged into the pending sketch the same way.
+func storeMetrics(storage *sync.Map, metrics []Metric) {
+	for _, metric := range metrics {
+		switch {
+		case metric.MType == "gauge" && metric.Value != nil && metric.interval:
+			storeIntervalGauge(storage, metric.key(), *metric.Value)
+		case metric.MType == "gauge" && metric.Value != nil:
+			storage.Store(metric.key(), *metric.Value)
+		case metric.MType == "counter" && metric.Delta != nil:
+			stored, _ := storage.LoadOrStore(metric.key(), new(counter))
+			pending, _ := stored.(*counter)
+			atomic.AddInt64(pending, *metric.Delta)
+		case metric.MType == "summary" && metric.Summary != nil:
+			stored, _ := storage.LoadOrStore(metric.key(), &summary{sketch: sketch.New(metric.Summary.RelativeAccuracy)})
+			pending, _ := stored.(*summary)
+			pending.Lock()
+			pending.sketch.Merge(metric.Summary)
+			pending.Unlock()
+		}
+	}
+}
+
+// commitMetrics subtracts sent counter deltas and summary observations
+// from pending ones and forgets sent interval gauges.
+// Only used after metrics are acknowledged by server
+// so failed sends keep pending deltas for the next report.
+// Increments collected while sending are kept as well.
+// Metrics must be built with prepareMetrics.
+func commitMetrics(storage *sync.Map, metrics []Metric) {
+	for _, metric := range metrics {
+		stored, ok := storage.Load(metric.series)
+		if !ok {
+			continue
+		}
 
-			a.PollCounter++
+		switch pending := stored.(type) {
+		case *counter:
+			if metric.Delta != nil {
+				atomic.AddInt64(pending, -*metric.Delta)
+			}
+		case *summary:
+			if metric.Summary != nil {
+				pending.Lock()
+				pending.sketch.Subtract(metric.Summary)
+				pending.Unlock()
+			}
+		case *intervalGauge:
+			pending.Lock()
+			if pending.version == metric.version {
+				pending.dropped = true
+				storage.Delete(metric.series)
+			}
+			pending.Unlock()
+		}
+	}
+}
+
+// Filter describes include/exclude lists of glob patterns.
+// Empty include list matches everything.
+type Filter struct {
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+}
 
-			log.Println("successfully collected runtime metrics")
+// Match checks if a name passes the filter.
+func (f Filter) Match(name string) bool {
+	if len(f.Include) != 0 && !matchAny(f.Include, name) {
+		return false
+	}
+
+	return !matchAny(f.Exclude, name)
+}
+
+// matchAny checks if a name matches any of glob patterns.
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, name); matched {
+			return true
 		}
 	}
+
+	return false
+}
+
+// counterDeltas keeps previous readings of cumulative system counters
+// to convert them to deltas the agent sends.
+type counterDeltas struct {
+	previous map[seriesKey]uint64
+	current  map[seriesKey]uint64
+}
+
+// newCounterDeltas is a counterDeltas constructor.
+func newCounterDeltas() *counterDeltas {
+	return &counterDeltas{
+		previous: map[seriesKey]uint64{},
+		current:  map[seriesKey]uint64{},
+	}
+}
+
+// delta returns a counter increment since the previous reading
+// of a series with given ID and labels.
+// First reading of a counter and counter resets produce no metric.
+func (d *counterDeltas) delta(id string, metricLabels map[string]string, value uint64) (Metric, bool) {
+	key := Metric{ID: id, Labels: metricLabels}.key()
+	d.current[key] = value
+
+	previous, ok := d.previous[key]
+	if !ok || value < previous {
+		return Metric{}, false
+	}
+
+	metric := newCounter(id, counter(value-previous))
+	metric.Labels = metricLabels
+
+	return metric, true
+}
+
+// rotate finishes a collection round.
+// Counters not seen during the round are forgotten.
+func (d *counterDeltas) rotate() {
+	d.previous = d.current
+	d.current = map[seriesKey]uint64{}
+}
+
+// newGauge is a helper to build gauge Metric.
+func newGauge(id string, value gauge) Metric {
+	return Metric{
+		ID:    id,
+		MType: "gauge",
+		Value: &value,
+	}
+}
+
+// newLabeledGauge is a helper to build gauge Metric of a labeled series.
+func newLabeledGauge(id string, metricLabels map[string]string, value gauge) Metric {
+	metric := newGauge(id, value)
+	metric.Labels = metricLabels
+
+	return metric
 }
 
-// updatePSUtilMetrics updates psutil metrics.
-func updatePSUtilMetrics(storage *sync.Map) {
-	memory, _ := mem.VirtualMemory()
-	storage.Store("TotalMemory", gauge(memory.Total))
-	storage.Store("FreeMemory", gauge(memory.Free))
+// newIntervalGauge is a helper to build gauge Metric
+// of a single collection interval which is sent once.
+func newIntervalGauge(id string, value gauge) Metric {
+	metric := newGauge(id, value)
+	metric.interval = true
+
+	return metric
+}
+
+// newCounter is a helper to build counter Metric.
+func newCounter(id string, delta counter) Metric {
+	return Metric{
+		ID:    id,
+		MType: "counter",
+		Delta: &delta,
+	}
+}
+
+// newSummary is a helper to build summary Metric.
+func newSummary(id string, observations *sketch.Sketch) Metric {
+	return Metric{
+		ID:      id,
+		MType:   "summary",
+		Summary: observations,
+	}
+}
+
+// PSUtilCollector collects host memory and CPU metrics.
+type PSUtilCollector struct {
+	interval time.Duration
+}
+
+// NewPSUtilCollector is a PSUtilCollector constructor.
+func NewPSUtilCollector(interval time.Duration) *PSUtilCollector {
+	return &PSUtilCollector{interval: interval}
+}
+
+func (c *PSUtilCollector) Name() string {
+	return "psutil"
+}
+
+func (c *PSUtilCollector) Interval() time.Duration {
+	return c.interval
+}
+
+// Collect gets psutil metrics.
+func (c *PSUtilCollector) Collect(ctx context.Context) ([]Metric, error) {
+	memory, err := mem.VirtualMemoryWithContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get memory stats: %w", err)
+	}
+
+	metrics := []Metric{
+		newGauge("TotalMemory", gauge(memory.Total)),
+		newGauge("FreeMemory", gauge(memory.Free)),
+	}
+
+	cpusUtilization, err := cpu.PercentWithContext(ctx, 0, true)
+	if err != nil {
+		return metrics, fmt.Errorf("failed to get cpu stats: %w", err)
+	}
 
-	cpusUtilization, _ := cpu.Percent(0, true)
 	for i, c := range cpusUtilization {
-		storage.Store(fmt.Sprintf("CPUutilization%d", i), gauge(c))
+		utilization := newGauge("CPUutilization", gauge(c))
+		utilization.Labels = map[string]string{"cpu": strconv.Itoa(i)}
+
+		metrics = append(metrics, utilization)
 	}
+
+	return metrics, nil
 }
 
-// updateRuntimeMetrics updates runtime metrics.
-func updateRuntimeMetrics(storage *sync.Map) {
-	data := &runtime.MemStats{}
+// RuntimeCollector collects go runtime metrics.
+type RuntimeCollector struct {
+	interval  time.Duration
+	lastNumGC uint32
+}
+
+// NewRuntimeCollector is a RuntimeCollector constructor.
+func NewRuntimeCollector(interval time.Duration) *RuntimeCollector {
+	return &RuntimeCollector{interval: interval}
+}
 
+func (c *RuntimeCollector) Name() string {
+	return "runtime"
+}
+
+func (c *RuntimeCollector) Interval() time.Duration {
+	return c.interval
+}
+
+// Collect gets runtime metrics.
+// Every call also increments PollCount counter
+// and reports GC pauses happened since the previous call as GCPauseNs summary.
+func (c *RuntimeCollector) Collect(ctx context.Context) ([]Metric, error) {
+	data := &runtime.MemStats{}
 	runtime.ReadMemStats(data)
 
-	storage.Store("Alloc", gauge(data.Alloc))
-	storage.Store("BuckHashSys", gauge(data.BuckHashSys))
-	storage.Store("Frees", gauge(data.Frees))
-	storage.Store("GCCPUFraction", gauge(data.GCCPUFraction))
-	storage.Store("GCSys", gauge(data.GCSys))
-	storage.Store("HeapAlloc", gauge(data.HeapAlloc))
-	storage.Store("HeapIdle", gauge(data.HeapIdle))
-	storage.Store("HeapInuse", gauge(data.HeapInuse))
-	storage.Store("HeapObjects", gauge(data.HeapObjects))
-	storage.Store("HeapReleased", gauge(data.HeapReleased))
-	storage.Store("HeapSys", gauge(data.HeapSys))
-	storage.Store("LastGC", gauge(data.LastGC))
-	storage.Store("Lookups", gauge(data.Lookups))
-	storage.Store("MCacheInuse", gauge(data.MCacheInuse))
-	storage.Store("MCacheSys", gauge(data.MCacheSys))
-	storage.Store("MSpanInuse", gauge(data.MSpanInuse))
-	storage.Store("MSpanSys", gauge(data.MSpanSys))
-	storage.Store("Mallocs", gauge(data.Mallocs))
-	storage.Store("NextGC", gauge(data.NextGC))
-	storage.Store("NumForcedGC", gauge(data.NumForcedGC))
-	storage.Store("NumGC", gauge(data.NumGC))
-	storage.Store("OtherSys", gauge(data.OtherSys))
-	storage.Store("PauseTotalNs", gauge(data.PauseTotalNs))
-	storage.Store("StackInuse", gauge(data.StackInuse))
-	storage.Store("StackSys", gauge(data.StackSys))
-	storage.Store("Sys", gauge(data.Sys))
-	storage.Store("TotalAlloc", gauge(data.TotalAlloc))
-	storage.Store("RandomValue", gauge(rand.Float64()))
+	metrics := []Metric{
+		newGauge("Alloc", gauge(data.Alloc)),
+		newGauge("BuckHashSys", gauge(data.BuckHashSys)),
+		newGauge("Frees", gauge(data.Frees)),
+		newGauge("GCCPUFraction", gauge(data.GCCPUFraction)),
+		newGauge("GCSys", gauge(data.GCSys)),
+		newGauge("HeapAlloc", gauge(data.HeapAlloc)),
+		newGauge("HeapIdle", gauge(data.HeapIdle)),
+		newGauge("HeapInuse", gauge(data.HeapInuse)),
+		newGauge("HeapObjects", gauge(data.HeapObjects)),
+		newGauge("HeapReleased", gauge(data.HeapReleased)),
+		newGauge("HeapSys", gauge(data.HeapSys)),
+		newGauge("LastGC", gauge(data.LastGC)),
+		newGauge("Lookups", gauge(data.Lookups)),
+		newGauge("MCacheInuse", gauge(data.MCacheInuse)),
+		newGauge("MCacheSys", gauge(data.MCacheSys)),
+		newGauge("MSpanInuse", gauge(data.MSpanInuse)),
+		newGauge("MSpanSys", gauge(data.MSpanSys)),
+		newGauge("Mallocs", gauge(data.Mallocs)),
+		newGauge("NextGC", gauge(data.NextGC)),
+		newGauge("NumForcedGC", gauge(data.NumForcedGC)),
+		newGauge("NumGC", gauge(data.NumGC)),
+		newGauge("OtherSys", gauge(data.OtherSys)),
+		newGauge("PauseTotalNs", gauge(data.PauseTotalNs)),
+		newGauge("StackInuse", gauge(data.StackInuse)),
+		newGauge("StackSys", gauge(data.StackSys)),
+		newGauge("Sys", gauge(data.Sys)),
+		newGauge("TotalAlloc", gauge(data.TotalAlloc)),
+		newGauge("RandomValue", gauge(rand.Float64())),
+		newCounter("PollCount", 1),
+	}
+
+	if pauses := c.gcPauses(data); pauses != nil {
+		metrics = append(metrics, newSummary("GCPauseNs", pauses))
+	}
+
+	return metrics, nil
+}
+
+// gcPauses returns a sketch of GC pauses happened since the previous call.
+// Runtime keeps only len(PauseNs) most recent pauses
+// so older ones are lost if GC runs too often between calls.
+func (c *RuntimeCollector) gcPauses(data *runtime.MemStats) *sketch.Sketch {
+	if data.NumGC == c.lastNumGC {
+		return nil
+	}
+
+	recent := data.NumGC - c.lastNumGC
+	if recent > uint32(len(data.PauseNs)) {
+		recent = uint32(len(data.PauseNs))
+	}
+
+	pauses := sketch.New(sketch.DefaultRelativeAccuracy)
+	for i := uint32(0); i < recent; i++ {
+		// The most recent pause is at PauseNs[(NumGC+255)%256]
+		pauses.Add(float64(data.PauseNs[(data.NumGC+255-i)%256]))
+	}
+
+	c.lastNumGC = data.NumGC
+
+	return pauses
 }
@@ -0,0 +1,271 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultCgroupMount is a cgroup v2 unified hierarchy mountpoint.
+const defaultCgroupMount = "/sys/fs/cgroup"
+
+// cgroupCPUStats maps cpu.stat keys to metric names.
+var cgroupCPUStats = map[string]string{
+	"usage_usec":     "CgroupCPUUsageUsec",
+	"user_usec":      "CgroupCPUUserUsec",
+	"system_usec":    "CgroupCPUSystemUsec",
+	"nr_periods":     "CgroupCPUPeriods",
+	"nr_throttled":   "CgroupCPUThrottled",
+	"throttled_usec": "CgroupCPUThrottledUsec",
+}
+
+// cgroupIOStats maps io.stat keys to metric names.
+var cgroupIOStats = map[string]string{
+	"rbytes": "CgroupIOReadBytes",
+	"wbytes": "CgroupIOWriteBytes",
+	"rios":   "CgroupIOReadOps",
+	"wios":   "CgroupIOWriteOps",
+}
+
+// CgroupConfig is a cgroup collector configuration.
+// Cgroup paths are relative to the cgroup mountpoint.
+// If root is set, all of its child cgroups are watched as well.
+type CgroupConfig struct {
+	Interval Duration `json:"interval"`
+	Mount    string   `json:"mount"`
+	Paths    []string `json:"paths"`
+	Root     string   `json:"root"`
+}
+
+// CgroupCollector collects cgroup v2 resource usage metrics.
+//
+// Metrics are reported per cgroup,
+// e.g. CgroupMemoryCurrent{cgroup="system.slice"}.
+// CPU and I/O stats are counters, memory and pids are gauges.
+type CgroupCollector struct {
+	interval time.Duration
+	mount    string
+	paths    []string
+	root     string
+	stats    *counterDeltas
+}
+
+// NewCgroupCollector is a CgroupCollector constructor.
+// Default interval is used if config has none.
+func NewCgroupCollector(cfg CgroupConfig, defaultInterval time.Duration) *CgroupCollector {
+	interval := cfg.Interval.Duration
+	if interval == 0 {
+		interval = defaultInterval
+	}
+
+	mount := cfg.Mount
+	if mount == "" {
+		mount = defaultCgroupMount
+	}
+
+	return &CgroupCollector{
+		interval: interval,
+		mount:    mount,
+		paths:    cfg.Paths,
+		root:     cfg.Root,
+		stats:    newCounterDeltas(),
+	}
+}
+
+func (c *CgroupCollector) Name() string {
+	return "cgroup"
+}
+
+func (c *CgroupCollector) Interval() time.Duration {
+	return c.interval
+}
+
+// Collect gets cgroups resource usage metrics.
+func (c *CgroupCollector) Collect(ctx context.Context) ([]Metric, error) {
+	cgroups, err := c.cgroups()
+	if err != nil {
+		return nil, err
+	}
+
+	metrics := []Metric{}
+
+	for _, cgroup := range cgroups {
+		if ctx.Err() != nil {
+			return metrics, ctx.Err()
+		}
+
+		metrics = append(metrics, c.collectCgroup(cgroup)...)
+	}
+	c.stats.rotate()
+
+	return metrics, nil
+}
+
+// cgroups returns a list of watched cgroups.
+func (c *CgroupCollector) cgroups() ([]string, error) {
+	cgroups := []string{}
+	seen := map[string]bool{}
+
+	for _, path := range c.paths {
+		path = strings.Trim(path, "/")
+		if !seen[path] {
+			seen[path] = true
+			cgroups = append(cgroups, path)
+		}
+	}
+
+	if c.root == "" {
+		return cgroups, nil
+	}
+
+	root := strings.Trim(c.root, "/")
+
+	entries, err := os.ReadDir(filepath.Join(c.mount, root))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cgroup %s: %w", root, err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		path := filepath.Join(root, entry.Name())
+		if !seen[path] {
+			seen[path] = true
+			cgroups = append(cgroups, path)
+		}
+	}
+
+	return cgroups, nil
+}
+
+// collectCgroup reads a single cgroup files.
+// Missing files are skipped since controllers may be disabled.
+func (c *CgroupCollector) collectCgroup(cgroup string) []Metric {
+	dir := filepath.Join(c.mount, cgroup)
+	cgroupLabels := map[string]string{"cgroup": cgroup}
+	metrics := []Metric{}
+
+	if cpuStat, err := readFlatKeyed(filepath.Join(dir, "cpu.stat")); err == nil {
+		for key, name := range cgroupCPUStats {
+			value, ok := cpuStat[key]
+			if !ok {
+				continue
+			}
+
+			if metric, ok := c.stats.delta(name, cgroupLabels, value); ok {
+				metrics = append(metrics, metric)
+			}
+		}
+	}
+
+	if ioStat, err := readIOStat(filepath.Join(dir, "io.stat")); err == nil {
+		for key, name := range cgroupIOStats {
+			if metric, ok := c.stats.delta(name, cgroupLabels, ioStat[key]); ok {
+				metrics = append(metrics, metric)
+			}
+		}
+	}
+
+	for file, name := range map[string]string{
+		"memory.current": "CgroupMemoryCurrent",
+		"memory.max":     "CgroupMemoryMax",
+		"pids.current":   "CgroupPidsCurrent",
+	} {
+		value, err := readSingleValue(filepath.Join(dir, file))
+		if err != nil {
+			continue
+		}
+
+		metrics = append(metrics, newLabeledGauge(name, cgroupLabels, gauge(value)))
+	}
+
+	return metrics
+}
+
+// readSingleValue reads a cgroup file with a single number.
+// Files set to "max" have no limit and are treated as missing.
+func readSingleValue(path string) (uint64, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	value := strings.TrimSpace(string(content))
+	if value == "max" {
+		return 0, fmt.Errorf("%s has no limit", path)
+	}
+
+	return strconv.ParseUint(value, 10, 64)
+}
+
+// readFlatKeyed reads a cgroup file with "key value" lines.
+func readFlatKeyed(path string) (map[string]uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat := map[string]uint64{}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		stat[fields[0]] = value
+	}
+
+	return stat, scanner.Err()
+}
+
+// readIOStat reads a cgroup io.stat file.
+// Values are summed across all devices.
+func readIOStat(path string) (map[string]uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat := map[string]uint64{}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		// First field is a device major:minor number
+		for _, field := range fields[1:] {
+			key, rawValue, found := strings.Cut(field, "=")
+			if !found {
+				continue
+			}
+
+			value, err := strconv.ParseUint(rawValue, 10, 64)
+			if err != nil {
+				continue
+			}
+
+			stat[key] += value
+		}
+	}
+
+	return stat, scanner.Err()
+}
@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadIOStat(t *testing.T) {
+	stat, err := readIOStat("testdata/cgroup/workloads/b/io.stat")
+	require.NoError(t, err)
+
+	require.Equal(t, uint64(4196), stat["rbytes"])
+	require.Equal(t, uint64(8392), stat["wbytes"])
+	require.Equal(t, uint64(4), stat["rios"])
+	require.Equal(t, uint64(6), stat["wios"])
+}
+
+func TestCgroupCollector(t *testing.T) {
+	collector := NewCgroupCollector(CgroupConfig{
+		Mount: "testdata/cgroup",
+		Paths: []string{"/workloads/a"},
+		Root:  "workloads",
+	}, time.Second)
+
+	metrics, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	storage := map[string]Metric{}
+	for _, metric := range metrics {
+		storage[metric.Labels["cgroup"]+"/"+metric.ID] = metric
+	}
+
+	require.Equal(t, gauge(1048576), *storage["workloads/a/CgroupMemoryCurrent"].Value)
+	require.Equal(t, gauge(5), *storage["workloads/b/CgroupPidsCurrent"].Value)
+	require.Equal(t, gauge(2097152), *storage["workloads/b/CgroupMemoryMax"].Value)
+	require.NotContains(t, storage, "workloads/a/CgroupMemoryMax")
+	require.NotContains(t, storage, "workloads/a/CgroupCPUUsageUsec")
+
+	cgroupCounter := func(id, cgroup string) Metric {
+		metric := newCounter(id, 0)
+		metric.Labels = map[string]string{"cgroup": cgroup}
+
+		return metric
+	}
+
+	metrics, err = collector.Collect(context.Background())
+	require.NoError(t, err)
+	require.Contains(t, metrics, cgroupCounter("CgroupCPUUsageUsec", "workloads/a"))
+	require.Contains(t, metrics, cgroupCounter("CgroupIOReadBytes", "workloads/b"))
+
+	collector = NewCgroupCollector(CgroupConfig{
+		Mount: "testdata/cgroup",
+		Root:  "not_exists",
+	}, time.Second)
+
+	_, err = collector.Collect(context.Background())
+	require.Error(t, err)
+}
@@ -0,0 +1,109 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/disk"
+)
+
+// DiskConfig is a disk collector configuration.
+type DiskConfig struct {
+	Interval    Duration `json:"interval"`
+	Mountpoints Filter   `json:"mountpoints"`
+	FSTypes     Filter   `json:"fstypes"`
+}
+
+// DiskCollector collects filesystem usage and disk I/O metrics.
+//
+// Filesystem metrics are reported per mountpoint as gauges,
+// e.g. DiskUsed{mountpoint="/var"}. Disk I/O metrics are reported
+// per device as counters, e.g. DiskReadBytes{device="sda"}.
+type DiskCollector struct {
+	interval    time.Duration
+	mountpoints Filter
+	fstypes     Filter
+	io          *counterDeltas
+}
+
+// NewDiskCollector is a DiskCollector constructor.
+// Default interval is used if config has none.
+func NewDiskCollector(cfg DiskConfig, defaultInterval time.Duration) *DiskCollector {
+	interval := cfg.Interval.Duration
+	if interval == 0 {
+		interval = defaultInterval
+	}
+
+	return &DiskCollector{
+		interval:    interval,
+		mountpoints: cfg.Mountpoints,
+		fstypes:     cfg.FSTypes,
+		io:          newCounterDeltas(),
+	}
+}
+
+func (c *DiskCollector) Name() string {
+	return "disk"
+}
+
+func (c *DiskCollector) Interval() time.Duration {
+	return c.interval
+}
+
+// Collect gets filesystem usage and disk I/O metrics.
+func (c *DiskCollector) Collect(ctx context.Context) ([]Metric, error) {
+	partitions, err := disk.PartitionsWithContext(ctx, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get partitions: %w", err)
+	}
+
+	metrics := []Metric{}
+
+	for _, partition := range partitions {
+		if !c.mountpoints.Match(partition.Mountpoint) || !c.fstypes.Match(partition.Fstype) {
+			continue
+		}
+
+		usage, err := disk.UsageWithContext(ctx, partition.Mountpoint)
+		if err != nil {
+			continue
+		}
+
+		mountpoint := map[string]string{"mountpoint": partition.Mountpoint}
+
+		metrics = append(
+			metrics,
+			newLabeledGauge("DiskTotal", mountpoint, gauge(usage.Total)),
+			newLabeledGauge("DiskUsed", mountpoint, gauge(usage.Used)),
+			newLabeledGauge("DiskFree", mountpoint, gauge(usage.Free)),
+			newLabeledGauge("DiskUsedPercent", mountpoint, gauge(usage.UsedPercent)),
+			newLabeledGauge("DiskInodesTotal", mountpoint, gauge(usage.InodesTotal)),
+			newLabeledGauge("DiskInodesUsed", mountpoint, gauge(usage.InodesUsed)),
+			newLabeledGauge("DiskInodesFree", mountpoint, gauge(usage.InodesFree)),
+		)
+	}
+
+	ioCounters, err := disk.IOCountersWithContext(ctx)
+	if err != nil {
+		return metrics, fmt.Errorf("failed to get disk io counters: %w", err)
+	}
+
+	for device, stat := range ioCounters {
+		deviceLabels := map[string]string{"device": device}
+
+		for id, value := range map[string]uint64{
+			"DiskReadBytes":  stat.ReadBytes,
+			"DiskWriteBytes": stat.WriteBytes,
+			"DiskReadOps":    stat.ReadCount,
+			"DiskWriteOps":   stat.WriteCount,
+		} {
+			if metric, ok := c.io.delta(id, deviceLabels, value); ok {
+				metrics = append(metrics, metric)
+			}
+		}
+	}
+	c.io.rotate()
+
+	return metrics, nil
+}
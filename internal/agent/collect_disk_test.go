@@ -0,0 +1,92 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   Filter
+		value    string
+		expected bool
+	}{
+		{
+			name:     "test empty filter",
+			filter:   Filter{},
+			value:    "/",
+			expected: true,
+		},
+		{
+			name:     "test included",
+			filter:   Filter{Include: []string{"/", "/var"}},
+			value:    "/var",
+			expected: true,
+		},
+		{
+			name:     "test not included",
+			filter:   Filter{Include: []string{"/", "/var"}},
+			value:    "/home",
+			expected: false,
+		},
+		{
+			name:     "test excluded glob",
+			filter:   Filter{Exclude: []string{"/snap/*"}},
+			value:    "/snap/core",
+			expected: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.expected, tt.filter.Match(tt.value))
+		})
+	}
+}
+
+func TestCounterDeltas(t *testing.T) {
+	deltas := newCounterDeltas()
+
+	device := map[string]string{"device": "sda"}
+
+	_, ok := deltas.delta("TestCounter", device, 10)
+	require.False(t, ok)
+	_, ok = deltas.delta("TestCounter", nil, 20)
+	require.False(t, ok)
+	deltas.rotate()
+
+	// Series with different labels are counted apart
+	metric, ok := deltas.delta("TestCounter", device, 15)
+	require.True(t, ok)
+	require.Equal(t, counter(5), *metric.Delta)
+	require.Equal(t, device, metric.Labels)
+	deltas.rotate()
+
+	_, ok = deltas.delta("TestCounter", device, 3)
+	require.False(t, ok)
+}
+
+func TestDiskCollector(t *testing.T) {
+	collector := NewDiskCollector(DiskConfig{
+		Mountpoints: Filter{Include: []string{"/"}},
+	}, time.Second)
+	require.Equal(t, time.Second, collector.Interval())
+
+	_, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	metrics, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	for _, metric := range metrics {
+		if metric.MType == "gauge" {
+			require.Equal(t, map[string]string{"mountpoint": "/"}, metric.Labels, metric.ID)
+		}
+
+		require.NotContains(t, metric.ID, ":")
+	}
+}
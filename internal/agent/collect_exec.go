@@ -0,0 +1,297 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/horseinthesky/metricsagent/internal/labels"
+)
+
+// execWaitDelay is how long command output is read after the command
+// exits. Children of the command may hold its output open
+// which would otherwise block collection past the timeout.
+const execWaitDelay = time.Second
+
+// ExecCommand describes a command the agent runs to get custom metrics.
+//
+// Command stdout is either "name type value" lines
+// or JSON with a Metric or a list of Metrics.
+// Counter values are treated as increments.
+type ExecCommand struct {
+	Name     string   `json:"name"`
+	Command  []string `json:"command"`
+	Interval Duration `json:"interval"`
+	Timeout  Duration `json:"timeout"`
+}
+
+// ExecConfig is an exec collectors configuration.
+type ExecConfig struct {
+	Commands []ExecCommand `json:"commands"`
+}
+
+// ExecCollector runs a command and parses its output to metrics.
+//
+// Besides parsed metrics, command exit code and a number of stderr lines
+// are reported, e.g. ExecExitCode:check_queue.
+type ExecCollector struct {
+	name     string
+	command  []string
+	interval time.Duration
+	timeout  time.Duration
+}
+
+// NewExecCollector is an ExecCollector constructor.
+// Default interval is used if command has none.
+// Timeout defaults to the interval.
+func NewExecCollector(cfg ExecCommand, defaultInterval time.Duration) (*ExecCollector, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("exec command has no name")
+	}
+
+	if len(cfg.Command) == 0 {
+		return nil, fmt.Errorf("exec command %s has nothing to run", cfg.Name)
+	}
+
+	interval := cfg.Interval.Duration
+	if interval == 0 {
+		interval = defaultInterval
+	}
+
+	timeout := cfg.Timeout.Duration
+	if timeout == 0 {
+		timeout = interval
+	}
+
+	return &ExecCollector{
+		name:     cfg.Name,
+		command:  cfg.Command,
+		interval: interval,
+		timeout:  timeout,
+	}, nil
+}
+
+func (c *ExecCollector) Name() string {
+	return "exec:" + c.name
+}
+
+func (c *ExecCollector) Interval() time.Duration {
+	return c.interval
+}
+
+// Collect runs the command and parses its output.
+func (c *ExecCollector) Collect(ctx context.Context) ([]Metric, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	stdout, stderr, runErr := runCommand(ctx, c.command)
+
+	exitCode := 0
+
+	if runErr != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(runErr, &exitErr) {
+			return []Metric{newGauge("ExecExitCode:"+c.name, -1)}, fmt.Errorf("failed to run %s: %w", c.name, runErr)
+		}
+
+		// Killed on timeout processes have -1 exit code
+		exitCode = exitErr.ExitCode()
+	}
+
+	metrics := []Metric{
+		newGauge("ExecExitCode:"+c.name, gauge(exitCode)),
+		newGauge("ExecStderrLines:"+c.name, gauge(countLines(stderr))),
+	}
+
+	parsed, err := parseExecOutput(stdout)
+	if err != nil {
+		return metrics, fmt.Errorf("failed to parse %s output: %w", c.name, err)
+	}
+
+	return append(metrics, parsed...), nil
+}
+
+// runCommand runs a command in its own process group
+// and returns its stdout and stderr.
+// On context cancel the whole group is killed.
+// Output is read for execWaitDelay more at most,
+// as processes which left the group may still hold it open.
+func runCommand(ctx context.Context, command []string) ([]byte, []byte, error) {
+	cmd := exec.Command(command[0], command[1:]...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	stdoutReader, stdoutWriter, err := os.Pipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer stdoutReader.Close()
+
+	stderrReader, stderrWriter, err := os.Pipe()
+	if err != nil {
+		stdoutWriter.Close()
+		return nil, nil, err
+	}
+	defer stderrReader.Close()
+
+	cmd.Stdout = stdoutWriter
+	cmd.Stderr = stderrWriter
+
+	err = cmd.Start()
+
+	// Only the command holds write ends from now on
+	stdoutWriter.Close()
+	stderrWriter.Close()
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	read := &sync.WaitGroup{}
+	read.Add(2)
+	go func() {
+		defer read.Done()
+		io.Copy(&stdout, stdoutReader)
+	}()
+	go func() {
+		defer read.Done()
+		io.Copy(&stderr, stderrReader)
+	}()
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	var runErr error
+
+	select {
+	case runErr = <-exited:
+	case <-ctx.Done():
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		runErr = <-exited
+	}
+
+	readDone := make(chan struct{})
+	go func() {
+		read.Wait()
+		close(readDone)
+	}()
+
+	select {
+	case <-readDone:
+	case <-time.After(execWaitDelay):
+		stdoutReader.Close()
+		stderrReader.Close()
+		<-readDone
+	}
+
+	return stdout.Bytes(), stderr.Bytes(), runErr
+}
+
+// parseExecOutput parses command output to metrics.
+func parseExecOutput(output []byte) ([]Metric, error) {
+	output = bytes.TrimSpace(output)
+
+	switch {
+	case len(output) == 0:
+		return nil, nil
+	case output[0] == '{':
+		metric := Metric{}
+		if err := json.Unmarshal(output, &metric); err != nil {
+			return nil, err
+		}
+
+		return validateExecMetrics([]Metric{metric})
+	case output[0] == '[':
+		metrics := []Metric{}
+		if err := json.Unmarshal(output, &metrics); err != nil {
+			return nil, err
+		}
+
+		return validateExecMetrics(metrics)
+	}
+
+	metrics := []Metric{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("invalid line: %s", line)
+		}
+
+		switch fields[1] {
+		case "gauge":
+			value, err := strconv.ParseFloat(fields[2], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid gauge value: %s", line)
+			}
+
+			metrics = append(metrics, newGauge(fields[0], value))
+		case "counter":
+			delta, err := strconv.ParseInt(fields[2], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid counter value: %s", line)
+			}
+
+			metrics = append(metrics, newCounter(fields[0], delta))
+		default:
+			return nil, fmt.Errorf("unsupported metric type: %s", line)
+		}
+	}
+
+	return metrics, scanner.Err()
+}
+
+// validateExecMetrics checks JSON metrics have an ID, a known type and a value.
+func validateExecMetrics(metrics []Metric) ([]Metric, error) {
+	for _, metric := range metrics {
+		if metric.ID == "" {
+			return nil, fmt.Errorf("metric has no id")
+		}
+
+		if err := labels.Validate(metric.Labels); err != nil {
+			return nil, fmt.Errorf("metric %s: %w", metric.ID, err)
+		}
+
+		switch {
+		case metric.MType == "gauge" && metric.Value != nil:
+		case metric.MType == "counter" && metric.Delta != nil:
+		default:
+			return nil, fmt.Errorf("metric %s has unsupported type or no value", metric.ID)
+		}
+	}
+
+	return metrics, nil
+}
+
+// countLines counts non-empty lines.
+func countLines(data []byte) int {
+	count := 0
+
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) != 0 {
+			count++
+		}
+	}
+
+	return count
+}
@@ -0,0 +1,127 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseExecOutput(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		expected []Metric
+		error    bool
+	}{
+		{
+			name:     "test empty output",
+			output:   "\n",
+			expected: nil,
+		},
+		{
+			name:     "test text output",
+			output:   "# queue\nQueueSize gauge 10.5\nJobsDone counter 3\n",
+			expected: []Metric{newGauge("QueueSize", 10.5), newCounter("JobsDone", 3)},
+		},
+		{
+			name:   "test invalid text output",
+			output: "QueueSize 10.5",
+			error:  true,
+		},
+		{
+			name:   "test unsupported type",
+			output: "QueueSize histogram 10.5",
+			error:  true,
+		},
+		{
+			name:     "test JSON output",
+			output:   `{"id": "QueueSize", "type": "gauge", "value": 10.5}`,
+			expected: []Metric{newGauge("QueueSize", 10.5)},
+		},
+		{
+			name:     "test JSON list output",
+			output:   `[{"id": "QueueSize", "type": "gauge", "value": 10.5}, {"id": "JobsDone", "type": "counter", "delta": 3}]`,
+			expected: []Metric{newGauge("QueueSize", 10.5), newCounter("JobsDone", 3)},
+		},
+		{
+			name:   "test JSON output with labels",
+			output: `{"id": "QueueSize", "labels": {"queue": "jobs"}, "type": "gauge", "value": 10.5}`,
+			expected: []Metric{{
+				ID:     "QueueSize",
+				Labels: map[string]string{"queue": "jobs"},
+				MType:  "gauge",
+				Value:  newGauge("QueueSize", 10.5).Value,
+			}},
+		},
+		{
+			name:   "test JSON output with invalid labels",
+			output: `{"id": "QueueSize", "labels": {"1queue": "jobs"}, "type": "gauge", "value": 10.5}`,
+			error:  true,
+		},
+		{
+			name:   "test JSON output with no value",
+			output: `{"id": "QueueSize", "type": "gauge"}`,
+			error:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			metrics, err := parseExecOutput([]byte(tt.output))
+			if tt.error {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, metrics)
+		})
+	}
+}
+
+func TestExecCollector(t *testing.T) {
+	_, err := NewExecCollector(ExecCommand{Name: "empty"}, time.Second)
+	require.Error(t, err)
+
+	collector, err := NewExecCollector(ExecCommand{
+		Name:    "check",
+		Command: []string{"sh", "-c", "echo 'QueueSize gauge 5'; echo oops >&2; exit 3"},
+	}, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, "exec:check", collector.Name())
+
+	metrics, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []Metric{
+		newGauge("ExecExitCode:check", 3),
+		newGauge("ExecStderrLines:check", 1),
+		newGauge("QueueSize", 5),
+	}, metrics)
+
+	collector, err = NewExecCollector(ExecCommand{
+		Name:    "slow",
+		Command: []string{"sleep", "5"},
+		Timeout: Duration{100 * time.Millisecond},
+	}, time.Second)
+	require.NoError(t, err)
+
+	metrics, err = collector.Collect(context.Background())
+	require.NoError(t, err)
+	require.Contains(t, metrics, newGauge("ExecExitCode:slow", -1))
+
+	// Children holding output open don't block collection
+	collector, err = NewExecCollector(ExecCommand{
+		Name:    "forking",
+		Command: []string{"sh", "-c", "sleep 5 & sleep 5"},
+		Timeout: Duration{100 * time.Millisecond},
+	}, time.Second)
+	require.NoError(t, err)
+
+	start := time.Now()
+	metrics, err = collector.Collect(context.Background())
+	require.NoError(t, err)
+	require.Contains(t, metrics, newGauge("ExecExitCode:forking", -1))
+	require.Less(t, time.Since(start), 3*time.Second)
+}
@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/host"
+	"github.com/shirou/gopsutil/v3/load"
+)
+
+// defaultProcStatPath is a kernel/system statistics file location.
+const defaultProcStatPath = "/proc/stat"
+
+// HostCollector collects load average, uptime and kernel activity metrics.
+type HostCollector struct {
+	interval     time.Duration
+	procStatPath string
+	stat         *counterDeltas
+}
+
+// NewHostCollector is a HostCollector constructor.
+func NewHostCollector(interval time.Duration) *HostCollector {
+	return &HostCollector{
+		interval:     interval,
+		procStatPath: defaultProcStatPath,
+		stat:         newCounterDeltas(),
+	}
+}
+
+func (c *HostCollector) Name() string {
+	return "host"
+}
+
+func (c *HostCollector) Interval() time.Duration {
+	return c.interval
+}
+
+// Collect gets host metrics.
+func (c *HostCollector) Collect(ctx context.Context) ([]Metric, error) {
+	avg, err := load.AvgWithContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get load average: %w", err)
+	}
+
+	metrics := []Metric{
+		newGauge("LoadAverage1", avg.Load1),
+		newGauge("LoadAverage5", avg.Load5),
+		newGauge("LoadAverage15", avg.Load15),
+	}
+
+	uptime, err := host.UptimeWithContext(ctx)
+	if err != nil {
+		return metrics, fmt.Errorf("failed to get uptime: %w", err)
+	}
+
+	bootTime, err := host.BootTimeWithContext(ctx)
+	if err != nil {
+		return metrics, fmt.Errorf("failed to get boot time: %w", err)
+	}
+
+	metrics = append(
+		metrics,
+		newGauge("Uptime", gauge(uptime)),
+		newGauge("BootTime", gauge(bootTime)),
+	)
+
+	stat, err := readProcStat(c.procStatPath)
+	if err != nil {
+		return metrics, fmt.Errorf("failed to read %s: %w", c.procStatPath, err)
+	}
+
+	metrics = append(
+		metrics,
+		newGauge("ProcsRunning", gauge(stat["procs_running"])),
+		newGauge("ProcsBlocked", gauge(stat["procs_blocked"])),
+	)
+
+	for id, value := range map[string]uint64{
+		"ContextSwitches":  stat["ctxt"],
+		"Interrupts":       stat["intr"],
+		"ProcessesCreated": stat["processes"],
+	} {
+		if metric, ok := c.stat.delta(id, nil, value); ok {
+			metrics = append(metrics, metric)
+		}
+	}
+	c.stat.rotate()
+
+	return metrics, nil
+}
+
+// readProcStat parses /proc/stat single value lines.
+// Only the first number of a line is kept, e.g. total interrupts count for intr.
+func readProcStat(path string) (map[string]uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	stat := map[string]uint64{}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "cpu") {
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		stat[fields[0]] = value
+	}
+
+	return stat, scanner.Err()
+}
@@ -0,0 +1,44 @@
+package agent
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadProcStat(t *testing.T) {
+	stat, err := readProcStat("testdata/proc_stat")
+	require.NoError(t, err)
+
+	require.Equal(t, uint64(255298), stat["intr"])
+	require.Equal(t, uint64(574514), stat["ctxt"])
+	require.Equal(t, uint64(3), stat["procs_running"])
+	require.Equal(t, uint64(1), stat["procs_blocked"])
+
+	_, err = readProcStat("testdata/not_exists")
+	require.Error(t, err)
+}
+
+func TestHostCollector(t *testing.T) {
+	collector := NewHostCollector(time.Second)
+	collector.procStatPath = "testdata/proc_stat"
+
+	metrics, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	storage := map[string]Metric{}
+	for _, metric := range metrics {
+		storage[metric.ID] = metric
+	}
+
+	require.Contains(t, storage, "LoadAverage1")
+	require.Contains(t, storage, "Uptime")
+	require.Equal(t, gauge(3), *storage["ProcsRunning"].Value)
+	require.NotContains(t, storage, "ContextSwitches")
+
+	metrics, err = collector.Collect(context.Background())
+	require.NoError(t, err)
+	require.Contains(t, metrics, newCounter("ContextSwitches", 0))
+}
@@ -0,0 +1,177 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// defaultProcNetPath is a network statistics directory location.
+const defaultProcNetPath = "/proc/net"
+
+// tcpStates is a list of TCP connection states reported by NetCollector
+// ordered by kernel state codes starting from 1.
+var tcpStates = []string{
+	"ESTABLISHED",
+	"SYN_SENT",
+	"SYN_RECV",
+	"FIN_WAIT1",
+	"FIN_WAIT2",
+	"TIME_WAIT",
+	"CLOSE",
+	"CLOSE_WAIT",
+	"LAST_ACK",
+	"LISTEN",
+	"CLOSING",
+}
+
+// NetConfig is a network collector configuration.
+type NetConfig struct {
+	Interval   Duration `json:"interval"`
+	Interfaces Filter   `json:"interfaces"`
+}
+
+// NetCollector collects network interface and TCP connection metrics.
+//
+// Interface metrics are reported per interface as counters,
+// e.g. NetRxBytes{interface="eth0"}. TCP connections are counted by state
+// as gauges, e.g. TCPConnections{state="ESTABLISHED"}. Connections are counted from
+// /proc/net/tcp and /proc/net/tcp6 rather than per process sockets.
+type NetCollector struct {
+	interval    time.Duration
+	interfaces  Filter
+	procNetPath string
+	io          *counterDeltas
+}
+
+// NewNetCollector is a NetCollector constructor.
+// Default interval is used if config has none.
+func NewNetCollector(cfg NetConfig, defaultInterval time.Duration) *NetCollector {
+	interval := cfg.Interval.Duration
+	if interval == 0 {
+		interval = defaultInterval
+	}
+
+	return &NetCollector{
+		interval:    interval,
+		interfaces:  cfg.Interfaces,
+		procNetPath: defaultProcNetPath,
+		io:          newCounterDeltas(),
+	}
+}
+
+func (c *NetCollector) Name() string {
+	return "net"
+}
+
+func (c *NetCollector) Interval() time.Duration {
+	return c.interval
+}
+
+// Collect gets network interface and TCP connection metrics.
+func (c *NetCollector) Collect(ctx context.Context) ([]Metric, error) {
+	ioCounters, err := net.IOCountersWithContext(ctx, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get network io counters: %w", err)
+	}
+
+	metrics := []Metric{}
+
+	for _, stat := range ioCounters {
+		if !c.interfaces.Match(stat.Name) {
+			continue
+		}
+
+		iface := map[string]string{"interface": stat.Name}
+
+		for id, value := range map[string]uint64{
+			"NetRxBytes":   stat.BytesRecv,
+			"NetTxBytes":   stat.BytesSent,
+			"NetRxPackets": stat.PacketsRecv,
+			"NetTxPackets": stat.PacketsSent,
+			"NetRxErrors":  stat.Errin,
+			"NetTxErrors":  stat.Errout,
+			"NetRxDrops":   stat.Dropin,
+			"NetTxDrops":   stat.Dropout,
+		} {
+			if metric, ok := c.io.delta(id, iface, value); ok {
+				metrics = append(metrics, metric)
+			}
+		}
+	}
+	c.io.rotate()
+
+	states, err := readTCPStates(c.procNetPath)
+	if err != nil {
+		return metrics, fmt.Errorf("failed to get tcp connections: %w", err)
+	}
+
+	metrics = append(metrics, tcpStateMetrics(states)...)
+
+	return metrics, nil
+}
+
+// readTCPStates counts IPv4 and IPv6 TCP connections by state
+// from proc net tcp tables. Missing tcp6 table means IPv6 is disabled.
+func readTCPStates(procNetPath string) (map[string]int, error) {
+	states := map[string]int{}
+
+	for _, table := range []string{"tcp", "tcp6"} {
+		file, err := os.Open(filepath.Join(procNetPath, table))
+		if err != nil {
+			if table == "tcp6" && errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(file)
+
+		// Header line has column names
+		scanner.Scan()
+
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 4 {
+				continue
+			}
+
+			code, err := strconv.ParseUint(fields[3], 16, 8)
+			if err != nil || code == 0 || int(code) > len(tcpStates) {
+				continue
+			}
+
+			states[tcpStates[code-1]]++
+		}
+
+		err = scanner.Err()
+		file.Close()
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return states, nil
+}
+
+// tcpStateMetrics returns TCP connection counts by state.
+// Every known state is reported even if there are no connections in it.
+func tcpStateMetrics(states map[string]int) []Metric {
+	metrics := make([]Metric, 0, len(tcpStates))
+	for _, state := range tcpStates {
+		metrics = append(metrics, newLabeledGauge("TCPConnections", map[string]string{"state": state}, gauge(states[state])))
+	}
+
+	return metrics
+}
@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadTCPStates(t *testing.T) {
+	dir := t.TempDir()
+
+	tcp := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 0100007F:0CEA 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 1 1 0 100 0 0 10 0
+   1: 0100007F:0CEA 0100007F:D2C4 01 00000000:00000000 00:00000000 00000000  1000        0 2 1 0 20 4 30 10 -1
+   2: 0100007F:D2C4 0100007F:0CEA 06 00000000:00000000 03:00000F7E 00000000     0        0 0 3 0
+`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tcp"), []byte(tcp), 0o644))
+
+	// IPv6 disabled
+	states, err := readTCPStates(dir)
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"LISTEN": 1, "ESTABLISHED": 1, "TIME_WAIT": 1}, states)
+
+	tcp6 := `  sl  local_address                         remote_address                        st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000000000000000000001000000:0CEA 00000000000000000000000001000000:D2C6 01 00000000:00000000 00:00000000 00000000  1000        0 3 1 0 20 4 30 10 -1
+`
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "tcp6"), []byte(tcp6), 0o644))
+
+	states, err = readTCPStates(dir)
+	require.NoError(t, err)
+	require.Equal(t, 2, states["ESTABLISHED"])
+
+	metrics := tcpStateMetrics(states)
+	require.Len(t, metrics, len(tcpStates))
+
+	storage := map[string]gauge{}
+	for _, metric := range metrics {
+		require.Equal(t, "TCPConnections", metric.ID)
+		storage[metric.Labels["state"]] = *metric.Value
+	}
+
+	require.Equal(t, gauge(2), storage["ESTABLISHED"])
+	require.Equal(t, gauge(1), storage["TIME_WAIT"])
+	require.Equal(t, gauge(0), storage["CLOSING"])
+
+	_, err = readTCPStates(filepath.Join(dir, "missing"))
+	require.Error(t, err)
+}
+
+func TestNetCollector(t *testing.T) {
+	collector := NewNetCollector(NetConfig{
+		Interfaces: Filter{Include: []string{"lo"}},
+	}, time.Second)
+
+	_, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	metrics, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	for _, metric := range metrics {
+		if metric.MType == "counter" {
+			require.Equal(t, map[string]string{"interface": "lo"}, metric.Labels, metric.ID)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/process"
+)
+
+// ProcessRule describes processes to watch.
+// Exactly one of Exe, Cmdline and Pidfile must be set.
+type ProcessRule struct {
+	Name    string `json:"name"`    // rule name, used as process label
+	Exe     string `json:"exe"`     // process or executable name
+	Cmdline string `json:"cmdline"` // regexp to match process command line
+	Pidfile string `json:"pidfile"` // path to a file with process PID
+}
+
+// ProcessConfig is a process collector configuration.
+type ProcessConfig struct {
+	Interval Duration      `json:"interval"`
+	Rules    []ProcessRule `json:"rules"`
+}
+
+// processMatcher is a compiled ProcessRule.
+type processMatcher struct {
+	ProcessRule
+	cmdline *regexp.Regexp
+}
+
+// ProcessCollector collects resource usage of processes matched by rules.
+//
+// All processes matched by a rule are reported together,
+// e.g. ProcessRSS{process="nginx"} is a sum of RSS of all nginx processes.
+type ProcessCollector struct {
+	interval  time.Duration
+	matchers  []processMatcher
+	processes map[int32]*process.Process
+	pids      map[string]map[int32]bool
+}
+
+// NewProcessCollector is a ProcessCollector constructor.
+// Default interval is used if config has none.
+func NewProcessCollector(cfg ProcessConfig, defaultInterval time.Duration) (*ProcessCollector, error) {
+	interval := cfg.Interval.Duration
+	if interval == 0 {
+		interval = defaultInterval
+	}
+
+	names := map[string]bool{}
+	matchers := []processMatcher{}
+
+	for _, rule := range cfg.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("process rule has no name")
+		}
+
+		if names[rule.Name] {
+			return nil, fmt.Errorf("duplicate process rule: %s", rule.Name)
+		}
+		names[rule.Name] = true
+
+		set := 0
+		for _, field := range []string{rule.Exe, rule.Cmdline, rule.Pidfile} {
+			if field != "" {
+				set++
+			}
+		}
+
+		if set != 1 {
+			return nil, fmt.Errorf("process rule %s must have exactly one of exe, cmdline or pidfile", rule.Name)
+		}
+
+		matcher := processMatcher{ProcessRule: rule}
+		if rule.Cmdline != "" {
+			re, err := regexp.Compile(rule.Cmdline)
+			if err != nil {
+				return nil, fmt.Errorf("process rule %s has invalid cmdline: %w", rule.Name, err)
+			}
+			matcher.cmdline = re
+		}
+
+		matchers = append(matchers, matcher)
+	}
+
+	return &ProcessCollector{
+		interval:  interval,
+		matchers:  matchers,
+		processes: map[int32]*process.Process{},
+		pids:      map[string]map[int32]bool{},
+	}, nil
+}
+
+func (c *ProcessCollector) Name() string {
+	return "process"
+}
+
+func (c *ProcessCollector) Interval() time.Duration {
+	return c.interval
+}
+
+// Collect gets resource usage of matched processes.
+func (c *ProcessCollector) Collect(ctx context.Context) ([]Metric, error) {
+	var running []*process.Process
+
+	for _, matcher := range c.matchers {
+		if matcher.Pidfile == "" {
+			var err error
+
+			running, err = process.ProcessesWithContext(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list processes: %w", err)
+			}
+
+			break
+		}
+	}
+
+	metrics := []Metric{}
+	processes := map[int32]*process.Process{}
+
+	for _, matcher := range c.matchers {
+		matched := c.match(ctx, matcher, running)
+
+		var cpuPercent, rss, fds, threads float64
+
+		pids := map[int32]bool{}
+		for _, p := range matched {
+			// Reuse process objects to calculate CPU usage
+			// since the previous collection
+			if cached, ok := c.processes[p.Pid]; ok {
+				p = cached
+			}
+			processes[p.Pid] = p
+			pids[p.Pid] = true
+
+			if percent, err := p.PercentWithContext(ctx, 0); err == nil {
+				cpuPercent += percent
+			}
+
+			if memory, err := p.MemoryInfoWithContext(ctx); err == nil {
+				rss += float64(memory.RSS)
+			}
+
+			if numFDs, err := p.NumFDsWithContext(ctx); err == nil {
+				fds += float64(numFDs)
+			}
+
+			if numThreads, err := p.NumThreadsWithContext(ctx); err == nil {
+				threads += float64(numThreads)
+			}
+		}
+
+		restarts := counter(0)
+		if previous, ok := c.pids[matcher.Name]; ok {
+			for pid := range pids {
+				if !previous[pid] {
+					restarts++
+				}
+			}
+		}
+		c.pids[matcher.Name] = pids
+
+		processLabels := map[string]string{"process": matcher.Name}
+
+		restartsMetric := newCounter("ProcessRestarts", restarts)
+		restartsMetric.Labels = processLabels
+
+		metrics = append(
+			metrics,
+			newLabeledGauge("ProcessCount", processLabels, gauge(len(pids))),
+			newLabeledGauge("ProcessCPUPercent", processLabels, cpuPercent),
+			newLabeledGauge("ProcessRSS", processLabels, rss),
+			newLabeledGauge("ProcessOpenFDs", processLabels, fds),
+			newLabeledGauge("ProcessThreads", processLabels, threads),
+			restartsMetric,
+		)
+	}
+
+	c.processes = processes
+
+	return metrics, nil
+}
+
+// match returns processes matched by a rule.
+func (c *ProcessCollector) match(ctx context.Context, matcher processMatcher, running []*process.Process) []*process.Process {
+	if matcher.Pidfile != "" {
+		pid, err := readPidfile(matcher.Pidfile)
+		if err != nil {
+			return nil
+		}
+
+		p, err := process.NewProcessWithContext(ctx, pid)
+		if err != nil {
+			return nil
+		}
+
+		return []*process.Process{p}
+	}
+
+	matched := []*process.Process{}
+
+	for _, p := range running {
+		switch {
+		case matcher.Exe != "":
+			name, _ := p.NameWithContext(ctx)
+			exe, _ := p.ExeWithContext(ctx)
+
+			if name != matcher.Exe && filepath.Base(exe) != matcher.Exe {
+				continue
+			}
+		case matcher.cmdline != nil:
+			cmdline, err := p.CmdlineWithContext(ctx)
+			if err != nil || !matcher.cmdline.MatchString(cmdline) {
+				continue
+			}
+		}
+
+		matched = append(matched, p)
+	}
+
+	return matched
+}
+
+// readPidfile reads a PID from a file.
+func readPidfile(path string) (int32, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	pid, err := strconv.ParseInt(strings.TrimSpace(string(content)), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	return int32(pid), nil
+}
@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewProcessCollector(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []ProcessRule
+	}{
+		{
+			name:  "test no name",
+			rules: []ProcessRule{{Exe: "nginx"}},
+		},
+		{
+			name:  "test duplicate name",
+			rules: []ProcessRule{{Name: "nginx", Exe: "nginx"}, {Name: "nginx", Exe: "nginx"}},
+		},
+		{
+			name:  "test no matcher",
+			rules: []ProcessRule{{Name: "nginx"}},
+		},
+		{
+			name:  "test several matchers",
+			rules: []ProcessRule{{Name: "nginx", Exe: "nginx", Pidfile: "/run/nginx.pid"}},
+		},
+		{
+			name:  "test invalid cmdline",
+			rules: []ProcessRule{{Name: "nginx", Cmdline: "nginx("}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewProcessCollector(ProcessConfig{Rules: tt.rules}, time.Second)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestProcessCollector(t *testing.T) {
+	pidfile := filepath.Join(t.TempDir(), "agent.pid")
+	err := os.WriteFile(pidfile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+	require.NoError(t, err)
+
+	collector, err := NewProcessCollector(ProcessConfig{
+		Rules: []ProcessRule{
+			{Name: "self", Pidfile: pidfile},
+			{Name: "test", Exe: filepath.Base(os.Args[0])},
+			{Name: "missing", Cmdline: "^not-existing-process$"},
+		},
+	}, time.Second)
+	require.NoError(t, err)
+
+	_, err = collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	metrics, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	storage := map[string]Metric{}
+	for _, metric := range metrics {
+		storage[metric.Labels["process"]+"/"+metric.ID] = metric
+	}
+
+	require.Equal(t, gauge(1), *storage["self/ProcessCount"].Value)
+	require.Greater(t, *storage["self/ProcessRSS"].Value, gauge(0))
+	require.Greater(t, *storage["self/ProcessThreads"].Value, gauge(0))
+	require.Equal(t, counter(0), *storage["self/ProcessRestarts"].Delta)
+	require.GreaterOrEqual(t, *storage["test/ProcessCount"].Value, gauge(1))
+	require.Equal(t, gauge(0), *storage["missing/ProcessCount"].Value)
+}
@@ -0,0 +1,416 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// statsdMaxPacketSize is a maximum StatsD UDP datagram size.
+const statsdMaxPacketSize = 65535
+
+// StatsDConfig is a StatsD listener configuration.
+type StatsDConfig struct {
+	UDPAddress string `json:"udp_address"`
+	TCPAddress string `json:"tcp_address"`
+}
+
+// statsdSample is a single parsed StatsD line.
+type statsdSample struct {
+	name  string
+	value float64
+	mtype string
+	rate  float64
+	// relative is set for gauge updates with an explicit sign, e.g. +5|g
+	relative bool
+	// member is a raw set value
+	member string
+}
+
+// statsdTimer aggregates timer samples.
+type statsdTimer struct {
+	values []float64
+	count  float64
+}
+
+// StatsDCollector is a StatsD server.
+//
+// Received metrics are aggregated during the collector interval.
+// Counters are reported as counters, gauges as gauges.
+// Sampled counter increments are rounded, remainders are carried over
+// to the next interval.
+// Timers are flattened to gauges, e.g. response_time.mean, response_time.p90,
+// sets are reported as gauges with a number of unique values.
+// Timer and set gauges are sent once per interval they are received in.
+type StatsDCollector struct {
+	sync.Mutex
+	interval   time.Duration
+	udpAddress string
+	tcpAddress string
+	counters   map[string]float64
+	remainders map[string]float64
+	gauges     map[string]float64
+	updated    map[string]bool
+	timers     map[string]*statsdTimer
+	sets       map[string]map[string]bool
+}
+
+// NewStatsDCollector is a StatsDCollector constructor.
+// Interval should be equal to report interval for metrics
+// to be aggregated per report.
+func NewStatsDCollector(cfg StatsDConfig, interval time.Duration) *StatsDCollector {
+	c := &StatsDCollector{
+		interval:   interval,
+		udpAddress: cfg.UDPAddress,
+		tcpAddress: cfg.TCPAddress,
+		remainders: map[string]float64{},
+		gauges:     map[string]float64{},
+	}
+	c.reset()
+
+	return c
+}
+
+func (c *StatsDCollector) Name() string {
+	return "statsd"
+}
+
+func (c *StatsDCollector) Interval() time.Duration {
+	return c.interval
+}
+
+// reset clears per interval aggregates.
+// Gauges keep their values to support relative updates,
+// counters keep their rounding remainders.
+func (c *StatsDCollector) reset() {
+	c.counters = map[string]float64{}
+	c.updated = map[string]bool{}
+	c.timers = map[string]*statsdTimer{}
+	c.sets = map[string]map[string]bool{}
+}
+
+// Listen receives StatsD metrics over UDP and TCP until context is done.
+// Both sockets are opened before serving either of them.
+func (c *StatsDCollector) Listen(ctx context.Context) error {
+	var (
+		conn     net.PacketConn
+		listener net.Listener
+		err      error
+	)
+
+	if c.udpAddress != "" {
+		conn, err = net.ListenPacket("udp", c.udpAddress)
+		if err != nil {
+			return fmt.Errorf("failed to listen statsd udp: %w", err)
+		}
+	}
+
+	if c.tcpAddress != "" {
+		listener, err = net.Listen("tcp", c.tcpAddress)
+		if err != nil {
+			if conn != nil {
+				conn.Close()
+			}
+
+			return fmt.Errorf("failed to listen statsd tcp: %w", err)
+		}
+	}
+
+	wg := sync.WaitGroup{}
+	errs := make(chan error, 2)
+
+	if conn != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- c.serveUDP(ctx, conn)
+		}()
+	}
+
+	if listener != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- c.serveTCP(ctx, listener)
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// serveUDP handles StatsD datagrams.
+func (c *StatsDCollector) serveUDP(ctx context.Context, conn net.PacketConn) error {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	log.Printf("statsd listening on udp %s", conn.LocalAddr())
+
+	buf := make([]byte, statsdMaxPacketSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			return fmt.Errorf("failed to read statsd packet: %w", err)
+		}
+
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			c.handleLine(line)
+		}
+	}
+}
+
+// serveTCP handles StatsD connections with newline separated metrics.
+func (c *StatsDCollector) serveTCP(ctx context.Context, listener net.Listener) error {
+	var (
+		mu       sync.Mutex
+		closed   bool
+		conns    = map[net.Conn]bool{}
+		handlers sync.WaitGroup
+	)
+
+	// Open connections are closed along with the listener
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		closed = true
+		for conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	log.Printf("statsd listening on tcp %s", listener.Addr())
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				handlers.Wait()
+				return nil
+			}
+
+			return fmt.Errorf("failed to accept statsd connection: %w", err)
+		}
+
+		mu.Lock()
+		if closed {
+			mu.Unlock()
+			conn.Close()
+			continue
+		}
+		conns[conn] = true
+		mu.Unlock()
+
+		handlers.Add(1)
+		go func() {
+			defer handlers.Done()
+			defer func() {
+				mu.Lock()
+				delete(conns, conn)
+				mu.Unlock()
+
+				conn.Close()
+			}()
+
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				c.handleLine(scanner.Text())
+			}
+		}()
+	}
+}
+
+// handleLine parses and aggregates a single StatsD line.
+func (c *StatsDCollector) handleLine(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+
+	sample, err := parseStatsDLine(line)
+	if err != nil {
+		log.Printf("failed to parse statsd line: %s", err)
+		return
+	}
+
+	c.add(sample)
+}
+
+// add aggregates a sample.
+func (c *StatsDCollector) add(sample statsdSample) {
+	c.Lock()
+	defer c.Unlock()
+
+	switch sample.mtype {
+	case "c":
+		c.counters[sample.name] += sample.value / sample.rate
+	case "g":
+		if sample.relative {
+			c.gauges[sample.name] += sample.value
+		} else {
+			c.gauges[sample.name] = sample.value
+		}
+		c.updated[sample.name] = true
+	case "ms", "h":
+		timer, ok := c.timers[sample.name]
+		if !ok {
+			timer = &statsdTimer{}
+			c.timers[sample.name] = timer
+		}
+		timer.values = append(timer.values, sample.value)
+		timer.count += 1 / sample.rate
+	case "s":
+		set, ok := c.sets[sample.name]
+		if !ok {
+			set = map[string]bool{}
+			c.sets[sample.name] = set
+		}
+		set[sample.member] = true
+	}
+}
+
+// Collect flushes metrics aggregated since the previous call.
+func (c *StatsDCollector) Collect(ctx context.Context) ([]Metric, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	metrics := []Metric{}
+
+	for name, value := range c.counters {
+		value += c.remainders[name]
+		delta := math.Round(value)
+
+		if remainder := value - delta; remainder != 0 {
+			c.remainders[name] = remainder
+		} else {
+			delete(c.remainders, name)
+		}
+
+		metrics = append(metrics, newCounter(name, counter(delta)))
+	}
+
+	for name := range c.updated {
+		metrics = append(metrics, newGauge(name, c.gauges[name]))
+	}
+
+	for name, timer := range c.timers {
+		metrics = append(metrics, flattenTimer(name, timer)...)
+	}
+
+	for name, set := range c.sets {
+		metrics = append(metrics, newIntervalGauge(name, gauge(len(set))))
+	}
+
+	c.reset()
+
+	return metrics, nil
+}
+
+// flattenTimer converts timer samples to derived gauges.
+func flattenTimer(name string, timer *statsdTimer) []Metric {
+	values := timer.values
+	sort.Float64s(values)
+
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+
+	return []Metric{
+		newIntervalGauge(name+".count", timer.count),
+		newIntervalGauge(name+".sum", sum),
+		newIntervalGauge(name+".mean", sum/float64(len(values))),
+		newIntervalGauge(name+".min", values[0]),
+		newIntervalGauge(name+".max", values[len(values)-1]),
+		newIntervalGauge(name+".p50", percentile(values, 50)),
+		newIntervalGauge(name+".p90", percentile(values, 90)),
+		newIntervalGauge(name+".p99", percentile(values, 99)),
+	}
+}
+
+// percentile returns nearest-rank percentile of sorted values.
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+
+	return sorted[rank-1]
+}
+
+// parseStatsDLine parses a "name:value|type[|@rate][|#tags]" line.
+func parseStatsDLine(line string) (statsdSample, error) {
+	name, rest, found := strings.Cut(line, ":")
+	if !found || name == "" {
+		return statsdSample{}, fmt.Errorf("no metric name: %s", line)
+	}
+
+	parts := strings.Split(rest, "|")
+	if len(parts) < 2 {
+		return statsdSample{}, fmt.Errorf("no metric type: %s", line)
+	}
+
+	sample := statsdSample{
+		name:  name,
+		mtype: parts[1],
+		rate:  1,
+	}
+
+	for _, part := range parts[2:] {
+		if !strings.HasPrefix(part, "@") {
+			continue
+		}
+
+		rate, err := strconv.ParseFloat(part[1:], 64)
+		if err != nil || rate <= 0 || rate > 1 {
+			return statsdSample{}, fmt.Errorf("invalid sample rate: %s", line)
+		}
+		sample.rate = rate
+	}
+
+	rawValue := parts[0]
+
+	switch sample.mtype {
+	case "s":
+		sample.member = rawValue
+		return sample, nil
+	case "g":
+		sample.relative = strings.HasPrefix(rawValue, "+") || strings.HasPrefix(rawValue, "-")
+	case "c", "ms", "h":
+	default:
+		return statsdSample{}, fmt.Errorf("unsupported metric type: %s", line)
+	}
+
+	value, err := strconv.ParseFloat(rawValue, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return statsdSample{}, fmt.Errorf("invalid metric value: %s", line)
+	}
+	sample.value = value
+
+	return sample, nil
+}
@@ -0,0 +1,266 @@
+package agent
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStatsDLine(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected statsdSample
+		error    bool
+	}{
+		{
+			name:     "test counter",
+			line:     "requests:1|c",
+			expected: statsdSample{name: "requests", value: 1, mtype: "c", rate: 1},
+		},
+		{
+			name:     "test sampled counter",
+			line:     "requests:2|c|@0.5|#env:prod",
+			expected: statsdSample{name: "requests", value: 2, mtype: "c", rate: 0.5},
+		},
+		{
+			name:     "test relative gauge",
+			line:     "queue:-3|g",
+			expected: statsdSample{name: "queue", value: -3, mtype: "g", rate: 1, relative: true},
+		},
+		{
+			name:     "test timer",
+			line:     "latency:320|ms",
+			expected: statsdSample{name: "latency", value: 320, mtype: "ms", rate: 1},
+		},
+		{
+			name:     "test set",
+			line:     "users:alice|s",
+			expected: statsdSample{name: "users", mtype: "s", rate: 1, member: "alice"},
+		},
+		{
+			name:  "test no type",
+			line:  "requests:1",
+			error: true,
+		},
+		{
+			name:  "test unsupported type",
+			line:  "requests:1|x",
+			error: true,
+		},
+		{
+			name:  "test invalid rate",
+			line:  "requests:1|c|@2",
+			error: true,
+		},
+		{
+			name:  "test invalid value",
+			line:  "requests:one|c",
+			error: true,
+		},
+		{
+			name:  "test infinite counter",
+			line:  "requests:Inf|c",
+			error: true,
+		},
+		{
+			name:  "test NaN timer",
+			line:  "latency:NaN|ms",
+			error: true,
+		},
+		{
+			name:  "test infinite gauge change",
+			line:  "temperature:-Inf|g",
+			error: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sample, err := parseStatsDLine(tt.line)
+			if tt.error {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, sample)
+		})
+	}
+}
+
+func TestStatsDCollector(t *testing.T) {
+	collector := NewStatsDCollector(StatsDConfig{}, time.Second)
+
+	for _, line := range []string{
+		"requests:1|c",
+		"requests:1|c|@0.5",
+		"queue:10|g",
+		"queue:+5|g",
+		"latency:10|ms",
+		"latency:30|ms",
+		"users:alice|s",
+		"users:bob|s",
+		"users:alice|s",
+	} {
+		collector.handleLine(line)
+	}
+
+	metrics, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	require.Contains(t, metrics, newCounter("requests", 3))
+	require.Contains(t, metrics, newGauge("queue", 15))
+	require.Contains(t, metrics, newIntervalGauge("latency.count", 2))
+	require.Contains(t, metrics, newIntervalGauge("latency.mean", 20))
+	require.Contains(t, metrics, newIntervalGauge("latency.max", 30))
+	require.Contains(t, metrics, newIntervalGauge("users", 2))
+
+	collector.handleLine("queue:-1|g")
+
+	metrics, err = collector.Collect(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, []Metric{newGauge("queue", 14)}, metrics)
+
+	// Rounding remainders of sampled counters are carried over
+	for i := 0; i < 3; i++ {
+		collector.handleLine("sampled:1|c|@0.4")
+
+		metrics, err = collector.Collect(context.Background())
+		require.NoError(t, err)
+		require.Contains(t, metrics, newCounter("sampled", []counter{3, 2, 3}[i]))
+	}
+}
+
+func TestStatsDCollectorIntervalGauges(t *testing.T) {
+	collector := NewStatsDCollector(StatsDConfig{}, time.Second)
+	storage := &sync.Map{}
+
+	collector.handleLine("latency:10|ms")
+	collector.handleLine("users:alice|s")
+
+	metrics, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+	storeMetrics(storage, metrics)
+
+	sent := prepareMetrics(storage, "", nil, "")
+	require.Len(t, sent, 9)
+
+	// Timer collected again while sending is kept
+	collector.handleLine("latency:20|ms")
+
+	metrics, err = collector.Collect(context.Background())
+	require.NoError(t, err)
+	storeMetrics(storage, metrics)
+
+	commitMetrics(storage, sent)
+
+	sent = prepareMetrics(storage, "", nil, "")
+	require.Len(t, sent, 8)
+
+	for _, metric := range sent {
+		require.Contains(t, metric.ID, "latency.")
+
+		if metric.ID == "latency.max" {
+			require.Equal(t, gauge(20), *metric.Value)
+		}
+	}
+
+	// Acknowledged gauges are not sent again
+	commitMetrics(storage, sent)
+	require.Empty(t, prepareMetrics(storage, "", nil, ""))
+}
+
+func TestStatsDCollectorListen(t *testing.T) {
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer busy.Close()
+
+	udpAddress := freeUDPAddress(t)
+	collector := NewStatsDCollector(StatsDConfig{UDPAddress: udpAddress, TCPAddress: busy.Addr().String()}, time.Second)
+	require.Error(t, collector.Listen(context.Background()))
+
+	// UDP socket is closed if TCP one fails
+	conn, err := net.ListenPacket("udp", udpAddress)
+	require.NoError(t, err)
+	conn.Close()
+}
+
+// freeUDPAddress returns a local UDP address nothing listens on.
+func freeUDPAddress(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+
+	return conn.LocalAddr().String()
+}
+
+func TestStatsDCollectorTCP(t *testing.T) {
+	collector := NewStatsDCollector(StatsDConfig{}, time.Second)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+	go func() {
+		done <- collector.serveTCP(ctx, listener)
+	}()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.Write([]byte("requests:1|c\n"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		collector.Lock()
+		defer collector.Unlock()
+
+		return collector.counters["requests"] == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// Open connection doesn't keep the listener running
+	cancel()
+	require.NoError(t, <-done)
+
+	_, err = client.Read(make([]byte, 1))
+	require.Error(t, err)
+}
+
+func TestStatsDCollectorUDP(t *testing.T) {
+	collector := NewStatsDCollector(StatsDConfig{}, time.Second)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+	go func() {
+		done <- collector.serveUDP(ctx, conn)
+	}()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	require.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.Write([]byte("requests:1|c\nrequests:2|c"))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		collector.Lock()
+		defer collector.Unlock()
+
+		return collector.counters["requests"] == 3
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	require.NoError(t, <-done)
+}
@@ -1,26 +1,174 @@
 package agent
 
 import (
+	"context"
+	"runtime"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/horseinthesky/metricsagent/internal/sketch"
 )
 
 var storage = &sync.Map{}
 
-func TestUpdateRuntimeMetrics(t *testing.T) {
-	updateRuntimeMetrics(storage)
+func TestRuntimeCollector(t *testing.T) {
+	metrics, err := NewRuntimeCollector(time.Second).Collect(context.Background())
+	require.NoError(t, err)
+
+	storeMetrics(storage, metrics)
 
-	AllocMetric, loaded := storage.Load("Alloc")
+	AllocMetric, loaded := storage.Load(seriesKey{id: "Alloc"})
 	require.True(t, loaded)
 	require.NotEqual(t, 0, AllocMetric)
+
+	PollCountMetric, loaded := storage.Load(seriesKey{id: "PollCount"})
+	require.True(t, loaded)
+	require.Greater(t, *PollCountMetric.(*counter), counter(0))
 }
 
-func TestUpdatePSUtilMetrics(t *testing.T) {
-	updatePSUtilMetrics(storage)
+func TestPSUtilCollector(t *testing.T) {
+	metrics, err := NewPSUtilCollector(time.Second).Collect(context.Background())
+	require.NoError(t, err)
+
+	storeMetrics(storage, metrics)
 
-	TotalMemoryMetric, loaded := storage.Load("TotalMemory")
+	TotalMemoryMetric, loaded := storage.Load(seriesKey{id: "TotalMemory"})
 	require.True(t, loaded)
 	require.Greater(t, TotalMemoryMetric, gauge(0))
 }
+
+func TestStoreMetrics(t *testing.T) {
+	storage := &sync.Map{}
+
+	storeMetrics(storage, []Metric{newCounter("TestCounter", 2), newGauge("TestGauge", 1)})
+	storeMetrics(storage, []Metric{newCounter("TestCounter", 3), newGauge("TestGauge", 5)})
+
+	testCounter, _ := storage.Load(seriesKey{id: "TestCounter"})
+	require.Equal(t, counter(5), *testCounter.(*counter))
+
+	testGauge, _ := storage.Load(seriesKey{id: "TestGauge"})
+	require.Equal(t, gauge(5), testGauge)
+}
+
+func TestCommitMetrics(t *testing.T) {
+	storage := &sync.Map{}
+
+	storeMetrics(storage, []Metric{newCounter("TestCounter", 2)})
+	sent := prepareMetrics(storage, "", nil, "")
+
+	// Collected while sending
+	storeMetrics(storage, []Metric{newCounter("TestCounter", 3)})
+
+	commitMetrics(storage, sent)
+
+	metrics := prepareMetrics(storage, "", nil, "")
+	require.Len(t, metrics, 1)
+	require.Equal(t, counter(3), *metrics[0].Delta)
+
+	// Failed send keeps pending delta
+	storeMetrics(storage, []Metric{newCounter("TestCounter", 1)})
+
+	metrics = prepareMetrics(storage, "", nil, "")
+	require.Len(t, metrics, 1)
+	require.Equal(t, counter(4), *metrics[0].Delta)
+}
+
+func TestStoreMetricsLabels(t *testing.T) {
+	storage := &sync.Map{}
+
+	cpu0 := newGauge("CPUutilization", 10)
+	cpu0.Labels = map[string]string{"cpu": "0"}
+
+	requests := newCounter("Requests", 2)
+	requests.Labels = map[string]string{"env": "dev", "cpu": "1"}
+
+	storeMetrics(storage, []Metric{cpu0, requests, newCounter("Requests", 3)})
+
+	metrics := prepareMetrics(storage, "testhost", map[string]string{"env": "test"}, "")
+	require.Len(t, metrics, 3)
+
+	labeled := map[seriesKey]map[string]string{}
+	for _, metric := range metrics {
+		labeled[metric.series] = metric.Labels
+	}
+
+	// Metric labels override agent ones
+	require.Equal(t, map[string]string{"env": "test", "cpu": "0"}, labeled[cpu0.key()])
+	require.Equal(t, map[string]string{"env": "dev", "cpu": "1"}, labeled[requests.key()])
+	require.Equal(t, map[string]string{"env": "test"}, labeled[seriesKey{id: "Requests"}])
+
+	// Series are committed separately
+	commitMetrics(storage, metrics)
+
+	pending, _ := storage.Load(seriesKey{id: "Requests"})
+	require.Equal(t, counter(0), *pending.(*counter))
+}
+
+func TestCommitSummary(t *testing.T) {
+	storage := &sync.Map{}
+
+	observations := sketch.New(sketch.DefaultRelativeAccuracy)
+	observations.Add(100)
+
+	storeMetrics(storage, []Metric{newSummary("TestSummary", observations)})
+	sent := prepareMetrics(storage, "", nil, "")
+	require.Len(t, sent, 1)
+	require.Equal(t, uint64(1), sent[0].Summary.Count)
+
+	// Collected while sending
+	storeMetrics(storage, []Metric{newSummary("TestSummary", observations)})
+
+	commitMetrics(storage, sent)
+
+	metrics := prepareMetrics(storage, "", nil, "")
+	require.Len(t, metrics, 1)
+	require.Equal(t, uint64(1), metrics[0].Summary.Count)
+
+	// Summary with no pending observations is not sent
+	commitMetrics(storage, metrics)
+	require.Empty(t, prepareMetrics(storage, "", nil, ""))
+}
+
+func TestRuntimeCollectorGCPauses(t *testing.T) {
+	collector := NewRuntimeCollector(time.Second)
+
+	runtime.GC()
+	runtime.GC()
+
+	metrics, err := collector.Collect(context.Background())
+	require.NoError(t, err)
+
+	pauses := metrics[len(metrics)-1]
+	require.Equal(t, "GCPauseNs", pauses.ID)
+	require.GreaterOrEqual(t, pauses.Summary.Count, uint64(2))
+
+	// Pauses are reported once
+	runtime.GC()
+
+	metrics, err = collector.Collect(context.Background())
+	require.NoError(t, err)
+	require.Less(t, metrics[len(metrics)-1].Summary.Count, pauses.Summary.Count+1)
+}
+
+func TestRegistry(t *testing.T) {
+	registry, err := newCollectors(Config{
+		PollInterval:       time.Second,
+		DisabledCollectors: []string{"psutil"},
+	})
+	require.NoError(t, err)
+
+	collectors := registry.Collectors()
+	for _, c := range collectors {
+		require.NotEqual(t, "psutil", c.Name())
+	}
+
+	err = registry.Register(NewRuntimeCollector(time.Second))
+	require.Error(t, err)
+
+	err = registry.Register(NewPSUtilCollector(time.Second))
+	require.NoError(t, err)
+	require.Len(t, registry.Collectors(), len(collectors)+1)
+}
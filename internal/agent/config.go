@@ -5,9 +5,13 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env/v6"
+
+	"github.com/horseinthesky/metricsagent/internal/labels"
 )
 
 // Agent default config options.
@@ -16,6 +20,7 @@ const (
 	defaultReportInterval = 10 * time.Second
 	defaultPollInterval   = 2 * time.Second
 	defaultPprofAddress   = "localhost:9000"
+	defaultSpoolMaxSize   = 100 * 1024 * 1024
 )
 
 // Duration is a custom type to help unmarshal time.Duration
@@ -46,24 +51,97 @@ func (d *Duration) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// Labels is a set of labels added to every metric agent reports.
+// Parsed from comma separated name=value pairs, e.g. env=prod,dc=eu.
+type Labels map[string]string
+
+// UnmarshalText parses labels from env variable.
+func (l *Labels) UnmarshalText(text []byte) error {
+	return l.Set(string(text))
+}
+
+// Set parses labels from flag value.
+func (l *Labels) Set(value string) error {
+	parsed := Labels{}
+
+	for _, pair := range strings.Split(value, ",") {
+		if pair == "" {
+			continue
+		}
+
+		name, labelValue, ok := strings.Cut(pair, "=")
+		if !ok {
+			return fmt.Errorf("invalid label %q: must be name=value", pair)
+		}
+
+		parsed[strings.TrimSpace(name)] = strings.TrimSpace(labelValue)
+	}
+
+	*l = parsed
+
+	return nil
+}
+
+// String returns labels in flag value form.
+func (l *Labels) String() string {
+	if l == nil {
+		return ""
+	}
+
+	names := make([]string, 0, len(*l))
+	for name := range *l {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	pairs := make([]string, 0, len(names))
+	for _, name := range names {
+		pairs = append(pairs, name+"="+(*l)[name])
+	}
+
+	return strings.Join(pairs, ",")
+}
+
 // ConfigFile is a container to store config file data
 type ConfigFile struct {
-	Address        string   `json:"address"`
-	ReportInterval Duration `json:"report_interval"`
-	PollInterval   Duration `json:"poll_interval"`
-	CryptoKey      string   `json:"crypto_key"`
+	Address            string            `json:"address"`
+	Host               string            `json:"host"`
+	Labels             map[string]string `json:"labels"`
+	ReportInterval     Duration          `json:"report_interval"`
+	PollInterval       Duration          `json:"poll_interval"`
+	CryptoKey          string            `json:"crypto_key"`
+	DisabledCollectors []string          `json:"disabled_collectors"`
+	Disk               DiskConfig        `json:"disk"`
+	Net                NetConfig         `json:"net"`
+	Process            ProcessConfig     `json:"process"`
+	Cgroup             CgroupConfig      `json:"cgroup"`
+	Exec               ExecConfig        `json:"exec"`
+	StatsD             StatsDConfig      `json:"statsd"`
+	SpoolDir           string            `json:"spool_dir"`
+	SpoolMaxSize       int64             `json:"spool_max_size"`
 }
 
 // Agent Config description.
 type Config struct {
-	ConfigPath     string        `env:"CONFIG"`
-	Address        string        `env:"ADDRESS"`
-	PollInterval   time.Duration `env:"POLL_INTERVAL"`
-	ReportInterval time.Duration `env:"REPORT_INTERVAL"`
-	Pprof          string        `env:"PPROF"`
-	Key            string        `env:"KEY"`
-	CryptoKey      string        `env:"CRYPTO_KEY"`
-	GRPC           bool
+	ConfigPath         string        `env:"CONFIG"`
+	Address            string        `env:"ADDRESS"`
+	Host               string        `env:"HOST"`
+	Labels             Labels        `env:"LABELS"`
+	PollInterval       time.Duration `env:"POLL_INTERVAL"`
+	ReportInterval     time.Duration `env:"REPORT_INTERVAL"`
+	Pprof              string        `env:"PPROF"`
+	Key                string        `env:"KEY"`
+	CryptoKey          string        `env:"CRYPTO_KEY"`
+	DisabledCollectors []string      `env:"DISABLED_COLLECTORS" envSeparator:","`
+	SpoolDir           string        `env:"SPOOL_DIR"`
+	SpoolMaxSize       int64         `env:"SPOOL_MAX_SIZE"`
+	Disk               DiskConfig
+	Net                NetConfig
+	Process            ProcessConfig
+	Cgroup             CgroupConfig
+	Exec               ExecConfig
+	StatsD             StatsDConfig
+	GRPC               bool
 }
 
 // ParseConfig parses the configuration options.
@@ -74,11 +152,15 @@ func ParseConfig() (Config, error) {
 
 	flag.StringVar(&cfg.ConfigPath, "c", "", "Config file path")
 	flag.StringVar(&cfg.Address, "a", defaultAddress, "Address for sending data to")
+	flag.StringVar(&cfg.Host, "host", "", "Agent instance ID to report metrics as (hostname by default)")
+	flag.Var(&cfg.Labels, "labels", "Labels to add to every metric (name=value,...)")
 	flag.DurationVar(&cfg.ReportInterval, "r", defaultReportInterval, "Metric report to server interval")
 	flag.DurationVar(&cfg.PollInterval, "p", defaultPollInterval, "Metric poll interval")
 	flag.StringVar(&cfg.Pprof, "P", defaultPprofAddress, "Pprof address")
 	flag.StringVar(&cfg.Key, "k", "", "Hash key")
 	flag.StringVar(&cfg.CryptoKey, "crypto-key", "", "Crypto public key path")
+	flag.StringVar(&cfg.SpoolDir, "spool-dir", "", "Failed batches spool directory")
+	flag.Int64Var(&cfg.SpoolMaxSize, "spool-max-size", defaultSpoolMaxSize, "Failed batches spool size cap (bytes)")
 	flag.BoolVar(&cfg.GRPC, "g", false, "Replace HTTP with gRPC")
 	flag.Parse()
 
@@ -91,6 +173,10 @@ func ParseConfig() (Config, error) {
 		return Config{}, fmt.Errorf("failed to load config file: %w", err)
 	}
 
+	if err := labels.Validate(cfg.Labels); err != nil {
+		return Config{}, err
+	}
+
 	return cfg, nil
 }
 
@@ -114,6 +200,14 @@ func loadConfigFile(cfg *Config) error {
 		cfg.Address = cfgFromFile.Address
 	}
 
+	if cfg.Host == "" && cfgFromFile.Host != "" {
+		cfg.Host = cfgFromFile.Host
+	}
+
+	if len(cfg.Labels) == 0 && len(cfgFromFile.Labels) != 0 {
+		cfg.Labels = cfgFromFile.Labels
+	}
+
 	if cfg.ReportInterval == defaultReportInterval && cfgFromFile.ReportInterval.Duration != 0 {
 		cfg.ReportInterval = cfgFromFile.ReportInterval.Duration
 	}
@@ -126,5 +220,24 @@ func loadConfigFile(cfg *Config) error {
 		cfg.CryptoKey = cfgFromFile.CryptoKey
 	}
 
+	if cfg.SpoolDir == "" && cfgFromFile.SpoolDir != "" {
+		cfg.SpoolDir = cfgFromFile.SpoolDir
+	}
+
+	if cfg.SpoolMaxSize == defaultSpoolMaxSize && cfgFromFile.SpoolMaxSize != 0 {
+		cfg.SpoolMaxSize = cfgFromFile.SpoolMaxSize
+	}
+
+	if len(cfg.DisabledCollectors) == 0 && len(cfgFromFile.DisabledCollectors) != 0 {
+		cfg.DisabledCollectors = cfgFromFile.DisabledCollectors
+	}
+
+	cfg.Disk = cfgFromFile.Disk
+	cfg.Net = cfgFromFile.Net
+	cfg.Process = cfgFromFile.Process
+	cfg.Cgroup = cfgFromFile.Cgroup
+	cfg.Exec = cfgFromFile.Exec
+	cfg.StatsD = cfgFromFile.StatsD
+
 	return nil
 }
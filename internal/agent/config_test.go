@@ -21,4 +21,16 @@ func TestParseConfig(t *testing.T) {
 	assert.Equal(t, "localhost:8081", config.Address)
 	assert.Equal(t, 3 * time.Second, config.PollInterval)
 	assert.Equal(t, 50 * time.Second, config.ReportInterval)
+	assert.Equal(t, []ProcessRule{{Name: "nginx", Exe: "nginx"}}, config.Process.Rules)
+	assert.Equal(t, Labels{"env": "test"}, config.Labels)
+}
+
+func TestLabels(t *testing.T) {
+	var labels Labels
+
+	assert.NoError(t, labels.Set("env=prod, dc=eu"))
+	assert.Equal(t, Labels{"env": "prod", "dc": "eu"}, labels)
+	assert.Equal(t, "dc=eu,env=prod", labels.String())
+
+	assert.Error(t, labels.Set("env"))
 }
@@ -2,31 +2,86 @@ package agent
 
 import (
 	"sync"
+	"sync/atomic"
 
+	"github.com/horseinthesky/metricsagent/internal/labels"
 	"github.com/horseinthesky/metricsagent/internal/pb"
+	"github.com/horseinthesky/metricsagent/internal/sketch"
 )
 
 // Metrics is an object to marshal metrics to.
 type Metric struct {
-	ID    string `json:"id"`              // metric name
-	MType string `json:"type"`            // metric type, gauge/counter
-	Delta *int64 `json:"delta,omitempty"` // metric value if it has a type of counter
-	Value *gauge `json:"value,omitempty"` // metric value if it has a type of gauge
-	Hash  string `json:"hash,omitempty"`  // hash value
+	ID       string            `json:"id"`                // metric name
+	Host     string            `json:"host,omitempty"`    // host metric is reported by
+	Labels   map[string]string `json:"labels,omitempty"`  // metric labels
+	MType    string            `json:"type"`              // metric type, gauge/counter/summary
+	Delta    *int64            `json:"delta,omitempty"`   // metric value if it has a type of counter
+	Value    *gauge            `json:"value,omitempty"`   // metric value if it has a type of gauge
+	Summary  *sketch.Sketch    `json:"summary,omitempty"` // metric value if it has a type of summary
+	Hash     string            `json:"hash,omitempty"`    // hash value
+	series   seriesKey         // agent storage series metric is prepared from
+	interval bool              // gauge of a single collection interval which is sent once
+	version  uint64            // interval gauge version metric is prepared from
 }
 
-// prepareMetrics converts metrics data to Metric objects.
-func prepareMetrics(storage *sync.Map, pollCounter int64, hashKey string) []Metric {
+// prepareMetrics converts metrics data to Metric objects reported by host
+// with agent labels. Metric own labels override agent ones.
+// Counters carry their pending deltas, i.e. increments since the last
+// acknowledged report. Summaries carry pending observations,
+// summaries with none are skipped.
+func prepareMetrics(storage *sync.Map, host string, agentLabels map[string]string, hashKey string) []Metric {
 	metrics := []Metric{}
 
-	storage.Range(func(metricName, value interface{}) bool {
-		m, _ := metricName.(string)
-		v, _ := value.(gauge)
+	storage.Range(func(key, value interface{}) bool {
+		series, _ := key.(seriesKey)
+
+		var metric Metric
+
+		switch v := value.(type) {
+		case gauge:
+			metric = newGauge(series.id, v)
+		case *intervalGauge:
+			v.Lock()
+			value, version := v.value, v.version
+			v.Unlock()
+
+			// Gauge is being stored
+			if version == 0 {
+				return true
+			}
+
+			metric = newIntervalGauge(series.id, value)
+			metric.version = version
+		case *counter:
+			metric = newCounter(series.id, atomic.LoadInt64(v))
+		case *summary:
+			v.Lock()
+			observations := v.sketch.Clone()
+			v.Unlock()
+
+			if observations.Count == 0 {
+				return true
+			}
+
+			metric = newSummary(series.id, observations)
+		default:
+			return true
+		}
+
+		metric.Host = host
+		metric.Labels = agentLabels
+		metric.series = series
+
+		if series.labels != "" {
+			metric.Labels = map[string]string{}
+			for name, value := range agentLabels {
+				metric.Labels[name] = value
+			}
 
-		metric := Metric{
-			ID:    m,
-			MType: "gauge",
-			Value: &v,
+			seriesLabels, _ := labels.Parse(series.labels)
+			for name, value := range seriesLabels {
+				metric.Labels[name] = value
+			}
 		}
 
 		if hashKey != "" {
@@ -38,26 +93,16 @@ func prepareMetrics(storage *sync.Map, pollCounter int64, hashKey string) []Metr
 		return true
 	})
 
-	metric := Metric{
-		ID:    "PollCount",
-		MType: "counter",
-		Delta: &pollCounter,
-	}
-
-	if hashKey != "" {
-		metric = addHash(metric, hashKey)
-	}
-
-	metrics = append(metrics, metric)
-
 	return metrics
 }
 
 func MetricToPB(metric Metric) *pb.Metric {
 	pbMetric := &pb.Metric{
-		Id:    metric.ID,
-		Mtype: metric.MType,
-		Hash:  metric.Hash,
+		Id:     metric.ID,
+		Host:   metric.Host,
+		Labels: metric.Labels,
+		Mtype:  metric.MType,
+		Hash:   metric.Hash,
 	}
 
 	if metric.Delta != nil {
@@ -68,5 +113,15 @@ func MetricToPB(metric Metric) *pb.Metric {
 		pbMetric.Value = *metric.Value
 	}
 
+	if metric.Summary != nil {
+		pbMetric.Summary = &pb.Sketch{
+			RelativeAccuracy: metric.Summary.RelativeAccuracy,
+			Bins:             metric.Summary.Bins,
+			Zeros:            metric.Summary.Zeros,
+			Count:            metric.Summary.Count,
+			Sum:              metric.Summary.Sum,
+		}
+	}
+
 	return pbMetric
 }
@@ -1,8 +1,10 @@
 package agent
 
 import (
+	"context"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 )
@@ -11,12 +13,20 @@ func TestPrepareMetrics(t *testing.T) {
 	storage := &sync.Map{}
 	testKey := "testkey"
 
-	metrics := prepareMetrics(storage, 1, testKey)
-	require.Equal(t, len(metrics), 1)
+	metrics := prepareMetrics(storage, "", nil, testKey)
+	require.Equal(t, len(metrics), 0)
 
-	updateRuntimeMetrics(storage)
-	metrics = prepareMetrics(storage, 2, testKey)
-	require.Greater(t, len(metrics), 1)
+	collected, err := NewRuntimeCollector(time.Second).Collect(context.Background())
+	require.NoError(t, err)
+
+	storeMetrics(storage, collected)
+	metrics = prepareMetrics(storage, "testhost", map[string]string{"env": "test"}, testKey)
+	require.Equal(t, len(collected), len(metrics))
+
+	for _, metric := range metrics {
+		require.Equal(t, "testhost", metric.Host)
+		require.Equal(t, map[string]string{"env": "test"}, metric.Labels)
+	}
 }
 
 func TestMetricToPB(t *testing.T) {
@@ -9,7 +9,10 @@ import (
 
 	"github.com/horseinthesky/metricsagent/internal/pb"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 // GPRCAgent description.
@@ -73,22 +76,40 @@ func (a *GRPCAgent) sendMetrics(ctx context.Context) {
 			log.Println("sending metrics cancelled")
 			return
 		case <-a.ReportTicker.C:
-			metrics := prepareMetrics(a.metrics, a.PollCounter, a.key)
-
-			pbMetics := []*pb.Metric{}
-			for _, m := range metrics {
-				pbMetics = append(pbMetics, MetricToPB(m))
-			}
-
-			_, err := client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{
-				Metrics: pbMetics,
+			err := a.report(ctx, func(ctx context.Context, batch Batch) error {
+				return updateMetrics(ctx, client, batch)
 			})
 			if err != nil {
 				log.Printf("failed to send metrics: %s", err)
-				continue
 			}
+		}
+	}
+}
 
-			log.Println("successfully updated metrics")
+// updateMetrics sends metrics with UpdateMetrics call.
+// Batch ID is sent with x-batch-id metadata.
+// Errors retrying the same batch won't fix are permanent.
+func updateMetrics(ctx context.Context, client pb.MetricsAgentClient, batch Batch) error {
+	pbMetics := []*pb.Metric{}
+	for _, m := range batch.Metrics {
+		pbMetics = append(pbMetics, MetricToPB(m))
+	}
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-batch-id", batch.ID)
+
+	_, err := client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{
+		Metrics: pbMetics,
+	})
+	if err != nil {
+		switch status.Code(err) {
+		case codes.InvalidArgument, codes.Unimplemented, codes.PermissionDenied, codes.Unauthenticated:
+			return permanentError{err}
 		}
+
+		return err
 	}
+
+	log.Println("successfully updated metrics")
+
+	return nil
 }
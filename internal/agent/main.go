@@ -3,7 +3,10 @@ package agent
 import (
 	"context"
 	"crypto/rsa"
+	"fmt"
+	"log"
 	"net/http"
+	"os"
 	"sync"
 	"time"
 
@@ -12,13 +15,16 @@ import (
 
 // Agent description.
 type GenericAgent struct {
-	PollTicker   *time.Ticker
 	ReportTicker *time.Ticker
-	PollCounter  int64
+	Collectors   *Registry
 	pprofServer  *http.Server
 	key          string
+	host         string
+	labels       map[string]string
 	CryptoKey    *rsa.PublicKey
 	metrics      *sync.Map
+	spool        *Spool
+	unacked      *Batch
 	upstream     string
 	workGroup    sync.WaitGroup
 }
@@ -36,32 +42,66 @@ func NewGenericAgent(cfg Config) (*GenericAgent, error) {
 		}
 	}
 
+	collectors, err := newCollectors(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	host := cfg.Host
+	if host == "" {
+		host, err = os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hostname: %w", err)
+		}
+	}
+
+	var spool *Spool
+	if cfg.SpoolDir != "" {
+		spool, err = NewSpool(cfg.SpoolDir, cfg.SpoolMaxSize)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &GenericAgent{
-		PollTicker:   time.NewTicker(cfg.PollInterval),
 		ReportTicker: time.NewTicker(cfg.ReportInterval),
+		Collectors:   collectors,
 		pprofServer:  &http.Server{Addr: cfg.Pprof},
 		key:          cfg.Key,
+		host:         host,
+		labels:       cfg.Labels,
 		CryptoKey:    pubKey,
 		metrics:      &sync.Map{},
+		spool:        spool,
 	}, nil
 }
 
 // Run is an Agent starting point.
 // Runs an agent.
 func (a *GenericAgent) Work(ctx context.Context) {
-	a.workGroup.Add(3)
+	a.workGroup.Add(1)
 	go func() {
 		defer a.workGroup.Done()
 		a.pprofServer.ListenAndServe()
 	}()
-	go func() {
-		defer a.workGroup.Done()
-		a.collectRuntimeMetrics(ctx)
-	}()
-	go func() {
-		defer a.workGroup.Done()
-		a.collectPSUtilMetrics(ctx)
-	}()
+
+	for _, c := range a.Collectors.Collectors() {
+		if l, ok := c.(Listener); ok {
+			a.workGroup.Add(1)
+			go func(l Listener) {
+				defer a.workGroup.Done()
+				if err := l.Listen(ctx); err != nil {
+					log.Printf("%s listener failed: %s", l.Name(), err)
+				}
+			}(l)
+		}
+
+		a.workGroup.Add(1)
+		go func(c Collector) {
+			defer a.workGroup.Done()
+			a.runCollector(ctx, c)
+		}(c)
+	}
 
 	<-ctx.Done()
 	a.pprofServer.Shutdown(ctx)
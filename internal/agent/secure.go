@@ -5,6 +5,8 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+
+	"github.com/horseinthesky/metricsagent/internal/labels"
 )
 
 // addHash adds hash to metric.
@@ -19,6 +21,16 @@ func addHash(metric Metric, hashKey string) Metric {
 		data = fmt.Sprintf("%s:%s:%f", metric.ID, metric.MType, *metric.Value)
 	case "counter":
 		data = fmt.Sprintf("%s:%s:%d", metric.ID, metric.MType, *metric.Delta)
+	case "summary":
+		data = fmt.Sprintf("%s:%s:%s", metric.ID, metric.MType, metric.Summary)
+	}
+
+	if metric.Host != "" {
+		data += ":" + metric.Host
+	}
+
+	if len(metric.Labels) != 0 {
+		data += "{" + labels.Format(metric.Labels) + "}"
 	}
 
 	h.Write([]byte(data))
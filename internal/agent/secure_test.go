@@ -4,6 +4,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/horseinthesky/metricsagent/internal/sketch"
 )
 
 func TestAddhash(t *testing.T) {
@@ -28,4 +30,39 @@ func TestAddhash(t *testing.T) {
 
 	gaugeMetric = addHash(gaugeMetric, "testkey")
 	require.Equal(t, "7300c53d565107966dd4486f13c76cdeda0e31d7f49a62494e5921f8a0faf417", gaugeMetric.Hash)
+
+	hostMetric := Metric{
+		ID:    "TestCounter",
+		Host:  "testhost",
+		MType: "counter",
+		Delta: &testCounter,
+	}
+
+	hostMetric = addHash(hostMetric, "testkey")
+	require.Equal(t, "bad5528d02f62b6baf778294d1ee1e3c5a5a56d03908e713197ece1c451541df", hostMetric.Hash)
+
+	labeledMetric := Metric{
+		ID:     "TestCounter",
+		Host:   "testhost",
+		Labels: map[string]string{"env": "prod"},
+		MType:  "counter",
+		Delta:  &testCounter,
+	}
+
+	labeledMetric = addHash(labeledMetric, "testkey")
+	require.Equal(t, "f6ac62916485106a33ca1b5300f06fd518c00ae2a964ef7b0a05800da474d1ab", labeledMetric.Hash)
+
+	summaryMetric := Metric{
+		ID:    "TestSummary",
+		MType: "summary",
+		Summary: &sketch.Sketch{
+			RelativeAccuracy: 0.01,
+			Bins:             map[int32]uint64{0: 1, 111: 1},
+			Count:            2,
+			Sum:              4,
+		},
+	}
+
+	summaryMetric = addHash(summaryMetric, "testkey")
+	require.Equal(t, "d8daac0b28520061c5d71a6ffad8de0ffe2fecdb813aff89dd6dac362ab89f00", summaryMetric.Hash)
 }
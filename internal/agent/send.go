@@ -31,24 +31,39 @@ func (a *Agent) sendMetricsJSONBulk(ctx context.Context) {
 			log.Println("sending metrics cancelled")
 			return
 		case <-a.ReportTicker.C:
-			metrics := prepareMetrics(a.metrics, a.PollCounter, a.key)
-
-			code, body, err := a.sendPostJSONBulk(ctx, metrics)
-			if err != nil {
+			if err := a.report(ctx, a.sendJSONBulk); err != nil {
 				log.Println(err)
-				continue
 			}
-
-			log.Printf("Code: %v: %s", code, body)
 		}
 	}
 }
 
+// sendJSONBulk sends metrics with sendPostJSONBulk checking response code.
+// Client errors are permanent since retrying the same batch won't help.
+func (a *Agent) sendJSONBulk(ctx context.Context, batch Batch) error {
+	code, body, err := a.sendPostJSONBulk(ctx, batch)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Code: %v: %s", code, body)
+
+	switch {
+	case code >= http.StatusInternalServerError:
+		return fmt.Errorf("server failed to store metrics: %v: %s", code, body)
+	case code >= http.StatusBadRequest:
+		return permanentError{fmt.Errorf("server rejected metrics: %v: %s", code, body)}
+	}
+
+	return nil
+}
+
 // sendPostJSONBulk serves as a HTTP helper for sendMetricsJSONBulk.
-func (a *Agent) sendPostJSONBulk(ctx context.Context, metrics []Metric) (int, string, error) {
+// Batch ID is sent with X-Batch-ID header.
+func (a *Agent) sendPostJSONBulk(ctx context.Context, batch Batch) (int, string, error) {
 	endpoint := fmt.Sprintf("%s/updates/", a.upstream)
 
-	payloadBytes, err := json.Marshal(metrics)
+	payloadBytes, err := json.Marshal(batch.Metrics)
 	if err != nil {
 		return 0, "", fmt.Errorf("failed to marshal metrics: %w", err)
 	}
@@ -66,6 +81,7 @@ func (a *Agent) sendPostJSONBulk(ctx context.Context, metrics []Metric) (int, st
 	}
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Add("X-Real-IP", getLocalAddress())
+	request.Header.Set("X-Batch-ID", batch.ID)
 
 	response, err := a.client.Do(request)
 	if err != nil {
@@ -31,14 +31,18 @@ func TestSendMetricsJSONBulk(t *testing.T) {
 
 	require.NoError(t, err)
 
-	agent.client = newTestClient(func(*http.Request) *http.Response {
+	batch := newBatch([]Metric{{}})
+
+	agent.client = newTestClient(func(req *http.Request) *http.Response {
+		require.Equal(t, batch.ID, req.Header.Get("X-Batch-ID"))
+
 		return &http.Response{
 			StatusCode: http.StatusOK,
 			Body:       io.NopCloser(strings.NewReader(`{"test": "passed"}`)),
 		}
 	})
 
-	code, body, err := agent.sendPostJSONBulk(context.Background(), []Metric{{}})
+	code, body, err := agent.sendPostJSONBulk(context.Background(), batch)
 	require.NoError(t, err)
 	require.Equal(t, http.StatusOK, code)
 	require.Equal(t, `{"test": "passed"}`, body)
@@ -0,0 +1,356 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Spool options.
+const (
+	spoolSegmentSize        = 1024 * 1024
+	spoolSegmentSuffix      = ".spool"
+	spoolMinBackoff         = 1 * time.Second
+	spoolMaxBackoff         = 5 * time.Minute
+	spoolBackoffCoefficient = 2
+)
+
+// sendFunc delivers a batch of metrics to server.
+type sendFunc func(ctx context.Context, batch Batch) error
+
+// permanentError is a send error retrying won't fix,
+// e.g. server rejected a batch as invalid.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// isPermanent checks if a send error should not be retried.
+func isPermanent(err error) bool {
+	var permanent permanentError
+
+	return errors.As(err, &permanent)
+}
+
+// Spool is a bounded on-disk buffer of batches the agent failed to send.
+//
+// Batches are stored as JSON lines in segment files.
+// Oldest segments are dropped when total size exceeds the cap.
+type Spool struct {
+	sync.Mutex
+	dir     string
+	maxSize int64
+	nextSeq uint64
+	backoff time.Duration
+	retryAt time.Time
+}
+
+// NewSpool is a Spool constructor.
+// Creates spool directory if needed.
+func NewSpool(dir string, maxSize int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create spool dir: %w", err)
+	}
+
+	if maxSize <= 0 {
+		maxSize = defaultSpoolMaxSize
+	}
+
+	s := &Spool{
+		dir:     dir,
+		maxSize: maxSize,
+	}
+
+	segments, err := s.segments()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(segments) != 0 {
+		last := strings.TrimSuffix(segments[len(segments)-1], spoolSegmentSuffix)
+		seq, _ := strconv.ParseUint(last, 10, 64)
+		s.nextSeq = seq + 1
+	}
+
+	return s, nil
+}
+
+// segments returns segment file names from oldest to newest.
+func (s *Spool) segments() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool dir: %w", err)
+	}
+
+	segments := []string{}
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), spoolSegmentSuffix) {
+			segments = append(segments, entry.Name())
+		}
+	}
+	sort.Strings(segments)
+
+	return segments, nil
+}
+
+// newSegment returns a new segment file name.
+func (s *Spool) newSegment() string {
+	name := fmt.Sprintf("%020d%s", s.nextSeq, spoolSegmentSuffix)
+	s.nextSeq++
+
+	return name
+}
+
+// Push appends a batch to the newest segment.
+// Batch keeps its ID so server could recognize a replayed batch.
+func (s *Spool) Push(batch Batch) error {
+	s.Lock()
+	defer s.Unlock()
+
+	line, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("failed to marshal batch: %w", err)
+	}
+	line = append(line, '\n')
+
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	var segment string
+	if len(segments) != 0 {
+		segment = segments[len(segments)-1]
+
+		info, err := os.Stat(filepath.Join(s.dir, segment))
+		if err != nil || info.Size()+int64(len(line)) > spoolSegmentSize {
+			segment = ""
+		}
+	}
+
+	if segment == "" {
+		segment = s.newSegment()
+		segments = append(segments, segment)
+	}
+
+	file, err := os.OpenFile(filepath.Join(s.dir, segment), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open spool segment: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(line); err != nil {
+		return fmt.Errorf("failed to write spool segment: %w", err)
+	}
+
+	return s.enforceCap(segments)
+}
+
+// enforceCap drops oldest segments until spool fits its size cap.
+// The newest segment is always kept.
+func (s *Spool) enforceCap(segments []string) error {
+	sizes := make([]int64, len(segments))
+
+	var total int64
+	for i, segment := range segments {
+		info, err := os.Stat(filepath.Join(s.dir, segment))
+		if err != nil {
+			continue
+		}
+
+		sizes[i] = info.Size()
+		total += info.Size()
+	}
+
+	for i := 0; total > s.maxSize && i < len(segments)-1; i++ {
+		if err := os.Remove(filepath.Join(s.dir, segments[i])); err != nil {
+			return fmt.Errorf("failed to drop spool segment: %w", err)
+		}
+
+		total -= sizes[i]
+		log.Printf("spool is full, dropped segment %s", segments[i])
+	}
+
+	return nil
+}
+
+// Replay sends spooled batches from oldest to newest.
+// Stops on the first failed batch keeping it and the rest in the spool.
+// Batches rejected with a permanent error are dropped.
+func (s *Spool) Replay(ctx context.Context, send sendFunc) error {
+	s.Lock()
+	defer s.Unlock()
+
+	segments, err := s.segments()
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range segments {
+		if err := s.replaySegment(ctx, segment, send); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// replaySegment sends batches of a single segment.
+// Unsent batches are written back to the segment.
+func (s *Spool) replaySegment(ctx context.Context, segment string, send sendFunc) error {
+	path := filepath.Join(s.dir, segment)
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read spool segment: %w", err)
+	}
+
+	lines := [][]byte{}
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		if len(line) != 0 {
+			lines = append(lines, line)
+		}
+	}
+
+	for i, line := range lines {
+		batch := Batch{}
+		if err := json.Unmarshal(line, &batch); err != nil {
+			log.Printf("dropped corrupted spooled batch: %s", err)
+			continue
+		}
+
+		err := send(ctx, batch)
+		if err == nil {
+			continue
+		}
+
+		if isPermanent(err) {
+			log.Printf("dropped rejected spooled batch: %s", err)
+			continue
+		}
+
+		if rewriteErr := s.rewriteSegment(path, lines[i:]); rewriteErr != nil {
+			return rewriteErr
+		}
+
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// rewriteSegment atomically replaces segment content with remaining batches.
+func (s *Spool) rewriteSegment(path string, lines [][]byte) error {
+	tmp := path + ".tmp"
+
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to rewrite spool segment: %w", err)
+	}
+
+	writer := bufio.NewWriter(file)
+	for _, line := range lines {
+		writer.Write(line)
+		writer.WriteByte('\n')
+	}
+
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to rewrite spool segment: %w", err)
+	}
+	file.Close()
+
+	return os.Rename(tmp, path)
+}
+
+// Ready checks if backoff since the last failure is over.
+func (s *Spool) Ready() bool {
+	s.Lock()
+	defer s.Unlock()
+
+	return time.Now().After(s.retryAt)
+}
+
+// Fail doubles retry backoff after a failed delivery.
+func (s *Spool) Fail() {
+	s.Lock()
+	defer s.Unlock()
+
+	switch {
+	case s.backoff == 0:
+		s.backoff = spoolMinBackoff
+	case s.backoff < spoolMaxBackoff:
+		s.backoff *= spoolBackoffCoefficient
+		if s.backoff > spoolMaxBackoff {
+			s.backoff = spoolMaxBackoff
+		}
+	}
+
+	s.retryAt = time.Now().Add(s.backoff)
+}
+
+// Succeed resets retry backoff after a successful delivery.
+func (s *Spool) Succeed() {
+	s.Lock()
+	defer s.Unlock()
+
+	s.backoff = 0
+	s.retryAt = time.Time{}
+}
+
+// deliver sends a batch to server.
+// If spool is in use, spooled batches are replayed first to keep order
+// and the batch is spooled if server is unreachable.
+// A spooled batch counts as delivered since the spool replays it later.
+func (a *GenericAgent) deliver(ctx context.Context, batch Batch, send sendFunc) error {
+	if a.spool == nil {
+		return send(ctx, batch)
+	}
+
+	if !a.spool.Ready() {
+		return a.spool.Push(batch)
+	}
+
+	err := a.spool.Replay(ctx, send)
+	if err == nil {
+		err = send(ctx, batch)
+	}
+
+	if err == nil {
+		a.spool.Succeed()
+		return nil
+	}
+
+	if isPermanent(err) {
+		a.spool.Succeed()
+		return err
+	}
+
+	a.spool.Fail()
+
+	if spoolErr := a.spool.Push(batch); spoolErr != nil {
+		return fmt.Errorf("%s; failed to spool batch: %w", err, spoolErr)
+	}
+
+	log.Printf("failed to send metrics, batch spooled: %s", err)
+
+	return nil
+}
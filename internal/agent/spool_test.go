@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpoolReplay(t *testing.T) {
+	spool, err := NewSpool(t.TempDir(), 0)
+	require.NoError(t, err)
+
+	for i := 1; i <= 3; i++ {
+		err = spool.Push(newBatch([]Metric{newCounter("TestCounter", counter(i))}))
+		require.NoError(t, err)
+	}
+
+	sent := []counter{}
+	failAfter := 1
+
+	err = spool.Replay(context.Background(), func(ctx context.Context, batch Batch) error {
+		if len(sent) == failAfter {
+			return errors.New("server is down")
+		}
+
+		sent = append(sent, *batch.Metrics[0].Delta)
+		return nil
+	})
+	require.Error(t, err)
+	require.Equal(t, []counter{1}, sent)
+
+	failAfter = -1
+
+	err = spool.Replay(context.Background(), func(ctx context.Context, batch Batch) error {
+		sent = append(sent, *batch.Metrics[0].Delta)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []counter{1, 2, 3}, sent)
+
+	segments, err := spool.segments()
+	require.NoError(t, err)
+	require.Empty(t, segments)
+}
+
+func TestSpoolDropsPermanentlyRejected(t *testing.T) {
+	spool, err := NewSpool(t.TempDir(), 0)
+	require.NoError(t, err)
+
+	require.NoError(t, spool.Push(newBatch([]Metric{newCounter("TestCounter", 1)})))
+
+	err = spool.Replay(context.Background(), func(ctx context.Context, batch Batch) error {
+		return permanentError{errors.New("invalid hash")}
+	})
+	require.NoError(t, err)
+
+	segments, err := spool.segments()
+	require.NoError(t, err)
+	require.Empty(t, segments)
+}
+
+func TestSpoolCap(t *testing.T) {
+	dir := t.TempDir()
+
+	spool, err := NewSpool(dir, 1)
+	require.NoError(t, err)
+
+	// Fill a segment so the next push starts a new one
+	large := make([]Metric, 0, spoolSegmentSize/40)
+	for i := 0; i < cap(large); i++ {
+		large = append(large, newGauge("TestGauge", gauge(i)))
+	}
+
+	require.NoError(t, spool.Push(newBatch(large)))
+	require.NoError(t, spool.Push(newBatch([]Metric{newCounter("TestCounter", 1)})))
+
+	segments, err := spool.segments()
+	require.NoError(t, err)
+	require.Len(t, segments, 1)
+
+	// Sequence survives restart
+	spool, err = NewSpool(dir, 1)
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("%020d%s", spool.nextSeq-1, spoolSegmentSuffix), segments[0])
+}
+
+func TestSpoolBackoff(t *testing.T) {
+	spool, err := NewSpool(t.TempDir(), 0)
+	require.NoError(t, err)
+	require.True(t, spool.Ready())
+
+	spool.Fail()
+	require.False(t, spool.Ready())
+	require.Equal(t, spoolMinBackoff, spool.backoff)
+
+	spool.Fail()
+	require.Equal(t, 2*spoolMinBackoff, spool.backoff)
+
+	spool.Succeed()
+	require.True(t, spool.Ready())
+}
+
+func TestDeliver(t *testing.T) {
+	dir := t.TempDir()
+
+	spool, err := NewSpool(dir, 0)
+	require.NoError(t, err)
+
+	agent := &GenericAgent{spool: spool}
+
+	failed := []string{}
+	down := func(ctx context.Context, batch Batch) error {
+		failed = append(failed, batch.ID)
+		return errors.New("server is down")
+	}
+
+	err = agent.deliver(context.Background(), newBatch([]Metric{newCounter("TestCounter", 1)}), down)
+	require.NoError(t, err)
+
+	// Backoff is in progress, batch is spooled without sending
+	err = agent.deliver(context.Background(), newBatch([]Metric{newCounter("TestCounter", 2)}), down)
+	require.NoError(t, err)
+
+	spool.Succeed()
+
+	sent := []counter{}
+	sentIDs := []string{}
+	err = agent.deliver(context.Background(), newBatch([]Metric{newCounter("TestCounter", 3)}), func(ctx context.Context, batch Batch) error {
+		sent = append(sent, *batch.Metrics[0].Delta)
+		sentIDs = append(sentIDs, batch.ID)
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []counter{1, 2, 3}, sent)
+
+	// Replayed batch keeps its ID
+	require.Len(t, failed, 1)
+	require.Equal(t, failed[0], sentIDs[0])
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
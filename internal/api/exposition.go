@@ -0,0 +1,300 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/horseinthesky/metricsagent/internal/server/storage"
+)
+
+// Exposition formats content types.
+const (
+	textContentType        = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// hostLabel is a label agent host is exposed with.
+const hostLabel = "host"
+
+// labelValueEscaper escapes label values
+// the way both exposition formats expect.
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// acceptsOpenMetrics checks if a scraper asked for OpenMetrics format.
+func acceptsOpenMetrics(r *http.Request) bool {
+	for _, accepted := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(accepted)
+		if err != nil || mediaType != "application/openmetrics-text" {
+			continue
+		}
+
+		if q, ok := params["q"]; ok {
+			if weight, err := strconv.ParseFloat(q, 64); err != nil || weight == 0 {
+				continue
+			}
+		}
+
+		return true
+	}
+
+	return false
+}
+
+// handleExposition provides stored metrics in Prometheus
+// text exposition format 0.0.4 or OpenMetrics
+// if a scraper accepts it.
+// Agent host is exposed as a host label.
+func (s *Server) handleExposition() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allMetrics, err := s.DB.GetAll(r.Context())
+		if err != nil {
+			log.Printf("failed to get stored metrics: %s", err)
+			http.Error(w, "failed to get stored metrics", http.StatusInternalServerError)
+			return
+		}
+
+		metrics := make([]storage.Metric, 0, len(allMetrics))
+		for _, metric := range allMetrics {
+			metrics = append(metrics, metric)
+		}
+
+		openMetrics := acceptsOpenMetrics(r)
+
+		if openMetrics {
+			w.Header().Set("Content-Type", openMetricsContentType)
+		} else {
+			w.Header().Set("Content-Type", textContentType)
+		}
+
+		writeExposition(w, metrics, openMetrics)
+	})
+}
+
+// writeExposition renders metrics grouped into families by name.
+// Metrics clashing with a family of another type are skipped
+// since a family has a single type. So are metrics whose ID is
+// sanitized to a family name of another ID, e.g. a.b of a_b,
+// as their series would be duplicates. ID equal to the family name
+// is preferred, the first one in order otherwise.
+func writeExposition(w io.Writer, metrics []storage.Metric, openMetrics bool) {
+	sort.Slice(metrics, func(i, j int) bool {
+		ki, kj := metrics[i].Key(), metrics[j].Key()
+
+		ni, nj := familyName(metrics[i], openMetrics), familyName(metrics[j], openMetrics)
+		if ni != nj {
+			return ni < nj
+		}
+
+		if ki.ID != kj.ID {
+			if ei, ej := ki.ID == ni, kj.ID == nj; ei != ej {
+				return ei
+			}
+
+			return ki.ID < kj.ID
+		}
+
+		if ki.Host != kj.Host {
+			return ki.Host < kj.Host
+		}
+
+		return ki.Labels < kj.Labels
+	})
+
+	var family, familyType, familyID string
+	for _, metric := range metrics {
+		if storage.UnsupportedType(metric.MType) {
+			continue
+		}
+
+		name := familyName(metric, openMetrics)
+		if name != family {
+			family, familyType, familyID = name, metric.MType, metric.ID
+			fmt.Fprintf(w, "# TYPE %s %s\n", family, familyType)
+		}
+
+		if metric.MType != familyType || metric.ID != familyID {
+			continue
+		}
+
+		writeMetric(w, family, metric, openMetrics)
+	}
+
+	if openMetrics {
+		fmt.Fprintln(w, "# EOF")
+	}
+}
+
+// writeMetric renders metric samples.
+func writeMetric(w io.Writer, family string, metric storage.Metric, openMetrics bool) {
+	labels := exposedLabels(metric)
+
+	switch metric.MType {
+	case storage.Gauge.String():
+		if metric.Value != nil {
+			writeSample(w, family, labels, formatFloat(*metric.Value))
+		}
+	case storage.Counter.String():
+		if metric.Delta == nil {
+			return
+		}
+
+		name := family
+		if openMetrics {
+			name += "_total"
+		}
+		writeSample(w, name, labels, strconv.FormatInt(*metric.Delta, 10))
+	case storage.Histogram.String():
+		if metric.Histogram == nil {
+			return
+		}
+
+		var cumulative uint64
+		for i, count := range metric.Histogram.Counts {
+			bound := math.Inf(1)
+			if i < len(metric.Histogram.Bounds) {
+				bound = metric.Histogram.Bounds[i]
+			}
+
+			cumulative += count
+			writeSample(w, family+"_bucket", append(labels, formatLabel("le", formatFloat(bound))), strconv.FormatUint(cumulative, 10))
+		}
+
+		writeSample(w, family+"_sum", labels, formatFloat(metric.Histogram.Sum))
+		writeSample(w, family+"_count", labels, strconv.FormatUint(metric.Histogram.Count, 10))
+	case storage.Summary.String():
+		if metric.Summary == nil {
+			return
+		}
+
+		for _, q := range storage.ReportedQuantiles {
+			writeSample(w, family, append(labels, formatLabel("quantile", formatFloat(q))), formatFloat(metric.Summary.Quantile(q)))
+		}
+
+		writeSample(w, family+"_sum", labels, formatFloat(metric.Summary.Sum))
+		writeSample(w, family+"_count", labels, strconv.FormatUint(metric.Summary.Count, 10))
+	}
+}
+
+// writeSample renders a single sample line.
+func writeSample(w io.Writer, name string, labels []string, value string) {
+	if len(labels) == 0 {
+		fmt.Fprintf(w, "%s %s\n", name, value)
+		return
+	}
+
+	fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(labels, ","), value)
+}
+
+// familyName returns metric family name.
+// OpenMetrics counter family names have no _total suffix,
+// it is a part of the counter sample name instead.
+func familyName(metric storage.Metric, openMetrics bool) string {
+	name := sanitizeName(metric.ID, true)
+
+	if openMetrics && metric.MType == storage.Counter.String() {
+		name = strings.TrimSuffix(name, "_total")
+	}
+
+	return name
+}
+
+// reservedLabel checks if exposed label name is reserved
+// for histogram buckets, summary quantiles or internal use.
+func reservedLabel(name string) bool {
+	return name == "le" || name == "quantile" || strings.HasPrefix(name, "__")
+}
+
+// exposedLabels returns metric labels followed by host label
+// in "name=value" form sorted by name.
+// Labels are exposed with sanitized names. Labels with reserved names
+// or names clashing with another label once sanitized are dropped,
+// labels which need no sanitizing take precedence.
+// Metric own host label takes precedence over agent host.
+func exposedLabels(metric storage.Metric) []string {
+	names := make([]string, 0, len(metric.Labels))
+	for name := range metric.Labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	exposed := map[string]string{}
+	for _, sanitized := range []bool{false, true} {
+		for _, name := range names {
+			exposedName := sanitizeName(name, false)
+			if (exposedName != name) != sanitized || reservedLabel(exposedName) {
+				continue
+			}
+
+			if _, ok := exposed[exposedName]; !ok {
+				exposed[exposedName] = metric.Labels[name]
+			}
+		}
+	}
+
+	exposedNames := make([]string, 0, len(exposed))
+	for name := range exposed {
+		exposedNames = append(exposedNames, name)
+	}
+	sort.Strings(exposedNames)
+
+	labels := make([]string, 0, len(exposedNames)+1)
+	for _, name := range exposedNames {
+		labels = append(labels, formatLabel(name, exposed[name]))
+	}
+
+	if _, ok := exposed[hostLabel]; metric.Host != "" && !ok {
+		labels = append(labels, formatLabel(hostLabel, metric.Host))
+	}
+
+	return labels
+}
+
+// formatLabel returns label in "name=value" form.
+func formatLabel(name, value string) string {
+	return name + `="` + labelValueEscaper.Replace(value) + `"`
+}
+
+// sanitizeName replaces characters metric and label names may not contain
+// with underscores. Colons are only allowed in metric names.
+func sanitizeName(name string, colons bool) string {
+	var b strings.Builder
+
+	for i, r := range name {
+		switch {
+		case r == '_' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z':
+		case r >= '0' && r <= '9' && i != 0:
+		case r == ':' && colons:
+		default:
+			r = '_'
+		}
+
+		b.WriteRune(r)
+	}
+
+	if b.Len() == 0 {
+		return "_"
+	}
+
+	return b.String()
+}
+
+// formatFloat returns exposition float form.
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/horseinthesky/metricsagent/internal/server"
+	"github.com/horseinthesky/metricsagent/internal/server/storage"
+)
+
+func TestExpositionHandler(t *testing.T) {
+	expositionServer, err := NewServer(server.Config{})
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(expositionServer)
+	defer ts.Close()
+
+	updates := []string{
+		"/update/gauge/Alloc/1.5",
+		"/update/gauge/Alloc/2?host=node1&label.cpu=0",
+		`/update/gauge/Alloc/3?host=node1&label.path=%22c:%5C%22`,
+		"/update/counter/requests_total/5",
+		"/update/histogram/latency/0.2",
+		"/update/summary/GCPauseNs/0",
+		"/update/gauge/go.mem-used/7",
+	}
+	for _, path := range updates {
+		code, _ := testRequest(t, ts, http.MethodPost, path, "")
+		require.Equal(t, http.StatusOK, code, path)
+	}
+
+	scrape := func(accept string) (string, string) {
+		req, err := http.NewRequest(http.MethodGet, ts.URL+"/metrics", nil)
+		require.NoError(t, err)
+		req.Header.Set("Accept", accept)
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+
+		return resp.Header.Get("Content-Type"), string(body)
+	}
+
+	contentType, body := scrape("text/plain")
+	require.Equal(t, textContentType, contentType)
+	require.Equal(t, `# TYPE Alloc gauge
+Alloc 1.5
+Alloc{cpu="0",host="node1"} 2
+Alloc{path="\"c:\\\"",host="node1"} 3
+# TYPE GCPauseNs summary
+GCPauseNs{quantile="0.5"} 0
+GCPauseNs{quantile="0.9"} 0
+GCPauseNs{quantile="0.99"} 0
+GCPauseNs_sum 0
+GCPauseNs_count 1
+# TYPE go_mem_used gauge
+go_mem_used 7
+# TYPE latency histogram
+latency_bucket{le="0.005"} 0
+latency_bucket{le="0.01"} 0
+latency_bucket{le="0.025"} 0
+latency_bucket{le="0.05"} 0
+latency_bucket{le="0.1"} 0
+latency_bucket{le="0.25"} 1
+latency_bucket{le="0.5"} 1
+latency_bucket{le="1"} 1
+latency_bucket{le="2.5"} 1
+latency_bucket{le="5"} 1
+latency_bucket{le="10"} 1
+latency_bucket{le="+Inf"} 1
+latency_sum 0.2
+latency_count 1
+# TYPE requests_total counter
+requests_total 5
+`, body)
+
+	// OpenMetrics counters are suffixed with _total and exposition ends with EOF
+	contentType, body = scrape("application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5")
+	require.Equal(t, openMetricsContentType, contentType)
+	require.Contains(t, body, "# TYPE requests counter\nrequests_total 5\n")
+	require.Contains(t, body, "# EOF\n")
+
+	contentType, _ = scrape("application/openmetrics-text;q=0,text/plain")
+	require.Equal(t, textContentType, contentType)
+}
+
+func TestExpositionNameClash(t *testing.T) {
+	value := func(v float64) *float64 { return &v }
+
+	metrics := []storage.Metric{
+		{ID: "a.b", MType: "gauge", Value: value(1)},
+		{ID: "a_b", MType: "gauge", Value: value(2)},
+		{ID: "a-b", MType: "gauge", Value: value(3), Labels: map[string]string{"env": "prod"}},
+		{ID: "c.d", MType: "gauge", Value: value(4)},
+		{ID: "c-d", MType: "gauge", Value: value(5)},
+	}
+
+	// Series of IDs sanitized to a taken family name are skipped
+	var buf bytes.Buffer
+	writeExposition(&buf, metrics, false)
+	require.Equal(t, `# TYPE a_b gauge
+a_b 2
+# TYPE c_d gauge
+c_d 5
+`, buf.String())
+}
+
+func TestExposedLabels(t *testing.T) {
+	metric := storage.Metric{
+		ID:   "latency",
+		Host: "node1",
+		Labels: map[string]string{
+			"a.b":      "sanitized",
+			"a_b":      "exact",
+			"a-b":      "clashing",
+			"le":       "1",
+			"quantile": "0.5",
+			"__name__": "other",
+			"env":      "prod",
+		},
+	}
+
+	// Reserved and clashing labels are dropped
+	require.Equal(t, []string{`a_b="exact"`, `env="prod"`, `host="node1"`}, exposedLabels(metric))
+
+	delete(metric.Labels, "a_b")
+	metric.Labels["host"] = "node2"
+	require.Equal(t, []string{`a_b="clashing"`, `env="prod"`, `host="node2"`}, exposedLabels(metric))
+}
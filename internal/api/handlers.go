@@ -1,28 +1,95 @@
 package api
 
 import (
+	"context"
 	"crypto/hmac"
 	_ "embed"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"math"
+	"net"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"text/template"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/horseinthesky/metricsagent/internal/labels"
+	"github.com/horseinthesky/metricsagent/internal/query"
 	"github.com/horseinthesky/metricsagent/internal/server"
 	"github.com/horseinthesky/metricsagent/internal/server/storage"
+	"github.com/horseinthesky/metricsagent/internal/sketch"
 )
 
+// batchIDHeader carries an ID agents assign to every metrics batch.
+const batchIDHeader = "X-Batch-ID"
+
+// requestSource returns client address to scope batch IDs by.
+// RealIP middleware has already set it from X-Real-IP if provided.
+func requestSource(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// labelParamPrefix prefixes query params carrying metric labels.
+const labelParamPrefix = "label."
+
+// querySeries returns metric host and labels from URL query params.
+// Labels are label.<name> params, host and given handler params
+// are the only other params allowed so a mistyped param
+// doesn't silently select another series.
+func querySeries(r *http.Request, params ...string) (string, map[string]string, error) {
+	query := r.URL.Query()
+
+	allowed := map[string]bool{"host": true}
+	for _, param := range params {
+		allowed[param] = true
+	}
+
+	var metricLabels map[string]string
+	for name := range query {
+		if allowed[name] {
+			continue
+		}
+
+		if !strings.HasPrefix(name, labelParamPrefix) {
+			return "", nil, fmt.Errorf("unknown query param %q, labels are %s<name> params", name, labelParamPrefix)
+		}
+
+		if metricLabels == nil {
+			metricLabels = map[string]string{}
+		}
+		metricLabels[strings.TrimPrefix(name, labelParamPrefix)] = query.Get(name)
+	}
+
+	return query.Get("host"), metricLabels, nil
+}
+
 //go:embed templates/dashboard.html
 var dashboardTemplate string
 
+// dashboardRow is a single metric dashboard entry.
+type dashboardRow struct {
+	Host   string
+	Name   string
+	Labels string
+	Value  string
+}
+
 // handleDashboard handles metrics dashboard rendering.
+// Metrics are grouped by host.
 func (s *Server) handleDashboard() http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		floatedMetrics := map[string]float64{}
+		rows := []dashboardRow{}
 
 		allMetrics, err := s.DB.GetAll(r.Context())
 		if err != nil {
@@ -30,15 +97,41 @@ func (s *Server) handleDashboard() http.HandlerFunc {
 			return
 		}
 
-		for name, metric := range allMetrics {
+		for key, metric := range allMetrics {
+			row := dashboardRow{Host: key.Host, Name: key.ID, Labels: key.Labels}
+
 			switch metric.MType {
 			case storage.Counter.String():
-				floatedMetrics[name] = float64(*metric.Delta)
+				row.Value = fmt.Sprint(*metric.Delta)
 			case storage.Gauge.String():
-				floatedMetrics[name] = *metric.Value
+				row.Value = fmt.Sprint(*metric.Value)
+			case storage.Histogram.String():
+				row.Value = fmt.Sprintf("count=%d sum=%v", metric.Histogram.Count, metric.Histogram.Sum)
+			case storage.Summary.String():
+				row.Value = fmt.Sprintf(
+					"count=%d p50=%v p90=%v p99=%v",
+					metric.Summary.Count,
+					metric.Summary.Quantile(0.5),
+					metric.Summary.Quantile(0.9),
+					metric.Summary.Quantile(0.99),
+				)
 			}
+
+			rows = append(rows, row)
 		}
 
+		sort.Slice(rows, func(i, j int) bool {
+			if rows[i].Host != rows[j].Host {
+				return rows[i].Host < rows[j].Host
+			}
+
+			if rows[i].Name != rows[j].Name {
+				return rows[i].Name < rows[j].Name
+			}
+
+			return rows[i].Labels < rows[j].Labels
+		})
+
 		w.Header().Set("Content-Type", "text/html")
 
 		tmpl, err := template.New("").Parse(dashboardTemplate)
@@ -47,7 +140,7 @@ func (s *Server) handleDashboard() http.HandlerFunc {
 			return
 		}
 
-		err = tmpl.Execute(w, floatedMetrics)
+		err = tmpl.Execute(w, rows)
 		if err != nil {
 			log.Printf("failed to render a template: %s", err)
 		}
@@ -55,12 +148,24 @@ func (s *Server) handleDashboard() http.HandlerFunc {
 }
 
 // handleSaveTextMetric provides single metric receiver.
-// Metric type, name and value are obtained from URL params.
+// Metric type, name and value are obtained from URL params,
+// host and labels from optional query params.
+// Histogram and summary value is a single observation.
 func (s *Server) handleSaveTextMetric() http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		metricType := chi.URLParam(r, "metricType")
 		metricName := chi.URLParam(r, "metricName")
 		valueString := chi.URLParam(r, "value")
+		host, metricLabels, err := querySeries(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if err := labels.Validate(metricLabels); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
 		var metric storage.Metric
 
@@ -73,9 +178,11 @@ func (s *Server) handleSaveTextMetric() http.HandlerFunc {
 				return
 			}
 			metric = storage.Metric{
-				ID:    metricName,
-				MType: metricType,
-				Delta: &value,
+				ID:     metricName,
+				Host:   host,
+				Labels: metricLabels,
+				MType:  metricType,
+				Delta:  &value,
 			}
 		case storage.Gauge.String():
 			value, err := strconv.ParseFloat(string(valueString), 64)
@@ -85,13 +192,55 @@ func (s *Server) handleSaveTextMetric() http.HandlerFunc {
 				return
 			}
 			metric = storage.Metric{
-				ID:    metricName,
-				MType: metricType,
-				Value: &value,
+				ID:     metricName,
+				Host:   host,
+				Labels: metricLabels,
+				MType:  metricType,
+				Value:  &value,
+			}
+		case storage.Histogram.String():
+			value, err := strconv.ParseFloat(valueString, 64)
+			if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+				return
+			}
+
+			histogram := storage.NewHistogram(s.Config.HistogramBuckets)
+			histogram.Observe(value)
+
+			metric = storage.Metric{
+				ID:        metricName,
+				Host:      host,
+				Labels:    metricLabels,
+				MType:     metricType,
+				Histogram: histogram,
+			}
+		case storage.Summary.String():
+			value, err := strconv.ParseFloat(valueString, 64)
+			if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+				return
+			}
+
+			summary := sketch.New(sketch.DefaultRelativeAccuracy)
+			if err := summary.Add(value); err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+				return
+			}
+
+			metric = storage.Metric{
+				ID:      metricName,
+				Host:    host,
+				Labels:  metricLabels,
+				MType:   metricType,
+				Summary: summary,
 			}
 		}
 
-		err := s.DB.Set(metric)
+		err = s.DB.Set(metric)
 		if err != nil {
 			http.Error(w, "failed to save metric", http.StatusInternalServerError)
 			return
@@ -102,12 +251,19 @@ func (s *Server) handleSaveTextMetric() http.HandlerFunc {
 }
 
 // handleLoadTextMetric provides single metric loader.
-// Metric name is obtained from URL param.
+// Metric name is obtained from URL param,
+// host and labels from optional query params.
+// Without host the only series of the metric name is loaded.
 func (s *Server) handleLoadTextMetric() http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		metricName := chi.URLParam(r, "metricName")
+		host, metricLabels, err := querySeries(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		metric, err := s.DB.Get(r.Context(), metricName)
+		metric, err := s.loadTextSeries(r.Context(), storage.Metric{ID: metricName, Host: host, Labels: metricLabels})
 		if err != nil {
 			w.WriteHeader(http.StatusNotFound)
 			w.Write([]byte(http.StatusText(http.StatusNotFound)))
@@ -121,14 +277,95 @@ func (s *Server) handleLoadTextMetric() http.HandlerFunc {
 			value = fmt.Sprint(*metric.Delta)
 		case storage.Gauge.String():
 			value = fmt.Sprint(*metric.Value)
+		case storage.Histogram.String():
+			value = formatHistogram(metric.Histogram)
+		case storage.Summary.String():
+			value = formatSummary(metric.Summary)
 		}
 
 		w.Write([]byte(value))
 	})
 }
 
+// loadTextSeries returns a stored series of text API request.
+// Agents report metrics with their host, so if series without host
+// is not stored the only series of the ID having requested labels is.
+func (s *Server) loadTextSeries(ctx context.Context, series storage.Metric) (storage.Metric, error) {
+	metric, err := s.DB.Get(ctx, series.Key())
+	if err == nil || series.Host != "" {
+		return metric, err
+	}
+
+	page, listErr := s.DB.List(ctx, storage.ListOptions{Prefix: series.ID})
+	if listErr != nil {
+		return metric, listErr
+	}
+
+	matches := []storage.Metric{}
+	for _, candidate := range page.Metrics {
+		if candidate.ID == series.ID && hasLabels(candidate.Labels, series.Labels) {
+			matches = append(matches, candidate)
+		}
+	}
+
+	if len(matches) != 1 {
+		return metric, err
+	}
+
+	return matches[0], nil
+}
+
+// hasLabels checks if label set contains all of the given labels.
+func hasLabels(set, subset map[string]string) bool {
+	for name, value := range subset {
+		if set[name] != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// formatHistogram returns histogram text form:
+// cumulative bucket counts followed by sum and count.
+func formatHistogram(histogram *storage.HistogramData) string {
+	var (
+		b          strings.Builder
+		cumulative uint64
+	)
+
+	for i, count := range histogram.Counts {
+		bound := "+Inf"
+		if i < len(histogram.Bounds) {
+			bound = fmt.Sprint(histogram.Bounds[i])
+		}
+
+		cumulative += count
+		fmt.Fprintf(&b, "le=%q %d\n", bound, cumulative)
+	}
+
+	fmt.Fprintf(&b, "sum %v\ncount %d\n", histogram.Sum, histogram.Count)
+
+	return b.String()
+}
+
+// formatSummary returns summary text form:
+// reported quantiles followed by sum and count.
+func formatSummary(summary *sketch.Sketch) string {
+	var b strings.Builder
+
+	for _, q := range storage.ReportedQuantiles {
+		fmt.Fprintf(&b, "quantile=\"%v\" %v\n", q, summary.Quantile(q))
+	}
+
+	fmt.Fprintf(&b, "sum %v\ncount %d\n", summary.Sum, summary.Count)
+
+	return b.String()
+}
+
 // handleSaveJSONMetrics provides multiple metrics receiver.
 // Metrics type, name and value are obtained from JSON payload.
+// Batch with X-Batch-ID header is applied only once per source.
 func (s *Server) handleSaveJSONMetrics() http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Add("Content-Type", "application/json")
@@ -147,6 +384,11 @@ func (s *Server) handleSaveJSONMetrics() http.HandlerFunc {
 				return
 			}
 
+			if err := metric.Validate(); err != nil {
+				http.Error(w, `{"error": "invalid metric"}`, http.StatusBadRequest)
+				return
+			}
+
 			if s.Config.Key != "" {
 				localHash := server.GenerateHash(metric, s.Config.Key)
 				remoteHash, err := hex.DecodeString(metric.Hash)
@@ -162,7 +404,12 @@ func (s *Server) handleSaveJSONMetrics() http.HandlerFunc {
 			}
 		}
 
-		err = s.SaveMetricsBulk(metrics)
+		err = s.SaveMetricsBatch(requestSource(r), r.Header.Get(batchIDHeader), metrics)
+		if errors.Is(err, storage.ErrDuplicateBatch) {
+			log.Printf("batch %s is already applied, skipped", r.Header.Get(batchIDHeader))
+			w.Write([]byte(`{"result": "batch already applied"}`))
+			return
+		}
 		if err != nil {
 			log.Printf("failed to store metric: %s", err)
 			http.Error(w, `{"error": "failed to store metric"}`, http.StatusBadRequest)
@@ -192,6 +439,11 @@ func (s *Server) handleSaveJSONMetric() http.HandlerFunc {
 			return
 		}
 
+		if err := metric.Validate(); err != nil {
+			http.Error(w, `{"error": "invalid metric"}`, http.StatusBadRequest)
+			return
+		}
+
 		if s.Config.Key != "" {
 			localHash := server.GenerateHash(metric, s.Config.Key)
 			remoteHash, err := hex.DecodeString(metric.Hash)
@@ -237,7 +489,7 @@ func (s *Server) handleLoadJSONMetric() http.HandlerFunc {
 			return
 		}
 
-		metric, err := s.DB.Get(r.Context(), metricRequest.ID)
+		metric, err := s.DB.Get(r.Context(), metricRequest.Key())
 		if err != nil {
 			w.WriteHeader(http.StatusNotFound)
 			w.Write([]byte(`{"result": "unknown metric id"}`))
@@ -248,6 +500,8 @@ func (s *Server) handleLoadJSONMetric() http.HandlerFunc {
 			metric.Hash = hex.EncodeToString(server.GenerateHash(metric, s.Config.Key))
 		}
 
+		metric.SetQuantiles()
+
 		res, err := json.Marshal(metric)
 		if err != nil {
 			http.Error(w, `{"error": "faied to marshal metric"}`, http.StatusInternalServerError)
@@ -258,6 +512,208 @@ func (s *Server) handleLoadJSONMetric() http.HandlerFunc {
 	})
 }
 
+// maxRangePoints limits a number of points range query returns.
+const maxRangePoints = 11000
+
+// defaultRangeWindow is a range query window if start is not provided.
+const defaultRangeWindow = time.Hour
+
+// rangeResponse is a series history range query result.
+type rangeResponse struct {
+	ID         string            `json:"id"`
+	Host       string            `json:"host,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	Resolution string            `json:"resolution"`
+	Points     []storage.Point   `json:"points"`
+}
+
+// resolutionName returns a history resolution name
+// range query results report.
+func resolutionName(resolution time.Duration) string {
+	switch resolution {
+	case storage.MinuteResolution:
+		return "1m"
+	case storage.HourResolution:
+		return "1h"
+	default:
+		return "raw"
+	}
+}
+
+// isAggregation checks if range query aggregation is supported.
+func isAggregation(aggregation string) bool {
+	for _, supported := range storage.Aggregations {
+		if aggregation == supported {
+			return true
+		}
+	}
+
+	return false
+}
+
+// parseRangeTime parses range query timestamp
+// given as RFC 3339 time or unix seconds.
+// Empty timestamp is a default one.
+func parseRangeTime(value string, defaultTime time.Time) (time.Time, error) {
+	if value == "" {
+		return defaultTime, nil
+	}
+
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// handleLoadRange provides series history loader.
+// Metric name is obtained from URL param,
+// host and labels from optional query params.
+// Samples between from and to query params are returned,
+// one point per step if step query param is set.
+// Range defaults to the last hour.
+// History older than raw samples retention is served from rollups
+// with periods not rolled up yet filled from finer history,
+// agg query param selects rollup aggregation and defaults to the latest value.
+func (s *Server) handleLoadRange() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+
+		metricName := chi.URLParam(r, "metricName")
+		host, metricLabels, err := querySeries(r, "from", "to", "step", "agg")
+		if err != nil {
+			res, _ := json.Marshal(map[string]string{"error": err.Error()})
+			http.Error(w, string(res), http.StatusBadRequest)
+			return
+		}
+
+		query := r.URL.Query()
+
+		to, err := parseRangeTime(query.Get("to"), time.Now())
+		if err != nil {
+			http.Error(w, `{"error": "bad end time"}`, http.StatusBadRequest)
+			return
+		}
+
+		from, err := parseRangeTime(query.Get("from"), to.Add(-defaultRangeWindow))
+		if err != nil {
+			http.Error(w, `{"error": "bad start time"}`, http.StatusBadRequest)
+			return
+		}
+
+		if from.After(to) {
+			http.Error(w, `{"error": "start time is after end time"}`, http.StatusBadRequest)
+			return
+		}
+
+		var step time.Duration
+		if query.Get("step") != "" {
+			step, err = time.ParseDuration(query.Get("step"))
+			if err != nil || step <= 0 {
+				http.Error(w, `{"error": "bad step"}`, http.StatusBadRequest)
+				return
+			}
+
+			if to.Sub(from)/step > maxRangePoints {
+				http.Error(w, `{"error": "too many points, increase step"}`, http.StatusBadRequest)
+				return
+			}
+		}
+
+		aggregation := query.Get("agg")
+		if aggregation == "" {
+			aggregation = storage.Aggregations[0]
+		}
+
+		if !isAggregation(aggregation) {
+			http.Error(w, `{"error": "unsupported aggregation"}`, http.StatusBadRequest)
+			return
+		}
+
+		key := storage.Metric{ID: metricName, Host: host, Labels: metricLabels}.Key()
+
+		if _, err = s.DB.Get(r.Context(), key); err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"result": "unknown metric id"}`))
+			return
+		}
+
+		resolution := storage.PickResolution(s.Config.Retention(), time.Now(), from.Add(-step))
+
+		// Sample of the first step may precede its timestamp
+		rollups, err := storage.History(r.Context(), s.DB, key, resolution, from.Add(-step), to)
+		if err != nil {
+			log.Printf("failed to load range: %s", err)
+			http.Error(w, `{"error": "failed to load range"}`, http.StatusInternalServerError)
+			return
+		}
+
+		var points []storage.Point
+		if step != 0 {
+			points = storage.Downsample(rollups, from, to, step, aggregation)
+		} else {
+			points = storage.Points(rollups, aggregation)
+		}
+
+		res, err := json.Marshal(rangeResponse{
+			ID:         metricName,
+			Host:       host,
+			Labels:     metricLabels,
+			Resolution: resolutionName(resolution),
+			Points:     points,
+		})
+		if err != nil {
+			http.Error(w, `{"error": "faied to marshal range"}`, http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
+// queryResponse is a query evaluation result.
+type queryResponse struct {
+	Query  string         `json:"query"`
+	Result []query.Sample `json:"result"`
+}
+
+// handleQuery provides stored metrics query evaluation.
+// Query is obtained from query param and evaluated
+// at optional time query param, now by default.
+func (s *Server) handleQuery() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+
+		expr, err := query.Parse(r.URL.Query().Get("query"))
+		if err != nil {
+			res, _ := json.Marshal(map[string]string{"error": err.Error()})
+			http.Error(w, string(res), http.StatusBadRequest)
+			return
+		}
+
+		now, err := parseRangeTime(r.URL.Query().Get("time"), time.Now())
+		if err != nil {
+			http.Error(w, `{"error": "bad query time"}`, http.StatusBadRequest)
+			return
+		}
+
+		samples, err := query.Eval(r.Context(), s.DB, s.Config.Retention(), expr, now)
+		if err != nil {
+			log.Printf("failed to evaluate query: %s", err)
+			http.Error(w, `{"error": "failed to evaluate query"}`, http.StatusInternalServerError)
+			return
+		}
+
+		res, err := json.Marshal(queryResponse{Query: expr.String(), Result: samples})
+		if err != nil {
+			http.Error(w, `{"error": "faied to marshal query result"}`, http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
+
 // handlePingDB provides Server's DB healthcheck.
 func (s *Server) handlePingDB() http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
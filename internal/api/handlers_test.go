@@ -1,10 +1,17 @@
 package api
 
 import (
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/horseinthesky/metricsagent/internal/query"
+	"github.com/horseinthesky/metricsagent/internal/server/storage"
 	"github.com/stretchr/testify/require"
 )
 
@@ -245,6 +252,288 @@ func TestJSONHandlers(t *testing.T) {
 	}
 }
 
+func TestJSONHandlersBatch(t *testing.T) {
+	ts := httptest.NewServer(testServer)
+	defer ts.Close()
+
+	payload := `[{"id": "testBatchCounter", "type": "counter", "delta": 5}]`
+
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/updates/", strings.NewReader(payload))
+		require.NoError(t, err)
+		req.Header.Set("X-Batch-ID", "testBatch")
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		resp.Body.Close()
+
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	// Retried batch is applied once
+	code, body := testRequest(t, ts, http.MethodGet, "/value/counter/testBatchCounter", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "5", body)
+}
+
+func TestJSONHandlersHost(t *testing.T) {
+	ts := httptest.NewServer(testServer)
+	defer ts.Close()
+
+	for _, host := range []string{"host1", "host2"} {
+		payload := `[{"id": "testHostGauge", "host": "` + host + `", "type": "gauge", "value": 1}]`
+
+		code, _ := testRequest(t, ts, http.MethodPost, "/updates/", payload)
+		require.Equal(t, http.StatusOK, code)
+	}
+
+	code, body := testRequest(t, ts, http.MethodPost, "/value/", `{"id": "testHostGauge", "host": "host2", "type": "gauge"}`)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, `{"id":"testHostGauge","host":"host2","type":"gauge","value":1}`, body)
+
+	code, body = testRequest(t, ts, http.MethodGet, "/value/gauge/testHostGauge?host=host1", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "1", body)
+
+	code, _ = testRequest(t, ts, http.MethodGet, "/value/gauge/testHostGauge", "")
+	require.Equal(t, http.StatusNotFound, code)
+
+	// The only series of the ID is found without host
+	payload := `[{"id": "testHostGauge", "host": "host1", "type": "gauge", "value": 2, "labels": {"cpu": "0"}}]`
+	code, _ = testRequest(t, ts, http.MethodPost, "/updates/", payload)
+	require.Equal(t, http.StatusOK, code)
+
+	code, body = testRequest(t, ts, http.MethodGet, "/value/gauge/testHostGauge?label.cpu=0", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "2", body)
+
+	payload = `[{"id": "testAgentGauge", "host": "host1", "type": "gauge", "value": 3}]`
+	code, _ = testRequest(t, ts, http.MethodPost, "/updates/", payload)
+	require.Equal(t, http.StatusOK, code)
+
+	code, body = testRequest(t, ts, http.MethodGet, "/value/gauge/testAgentGauge", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "3", body)
+}
+
+func TestHandlersLabels(t *testing.T) {
+	ts := httptest.NewServer(testServer)
+	defer ts.Close()
+
+	code, _ := testRequest(t, ts, http.MethodPost, "/update/gauge/testLabeledGauge/1?label.cpu=0", "")
+	require.Equal(t, http.StatusOK, code)
+
+	payload := `[{"id": "testLabeledGauge", "labels": {"cpu": "1"}, "type": "gauge", "value": 2}]`
+	code, _ = testRequest(t, ts, http.MethodPost, "/updates/", payload)
+	require.Equal(t, http.StatusOK, code)
+
+	code, body := testRequest(t, ts, http.MethodGet, "/value/gauge/testLabeledGauge?label.cpu=0", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "1", body)
+
+	code, body = testRequest(t, ts, http.MethodPost, "/value/", `{"id": "testLabeledGauge", "labels": {"cpu": "1"}, "type": "gauge"}`)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, `{"id":"testLabeledGauge","labels":{"cpu":"1"},"type":"gauge","value":2}`, body)
+
+	payload = `[{"id": "testLabeledGauge", "labels": {"1cpu": "1"}, "type": "gauge", "value": 2}]`
+	code, _ = testRequest(t, ts, http.MethodPost, "/updates/", payload)
+	require.Equal(t, http.StatusBadRequest, code)
+
+	// Unknown query params are not taken as labels
+	code, _ = testRequest(t, ts, http.MethodGet, "/value/gauge/testLabeledGauge?cpu=0", "")
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = testRequest(t, ts, http.MethodPost, "/update/gauge/testLabeledGauge/1?cpu=0", "")
+	require.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestHandlersHistogram(t *testing.T) {
+	ts := httptest.NewServer(testServer)
+	defer ts.Close()
+
+	for _, value := range []string{"0.3", "3"} {
+		code, _ := testRequest(t, ts, http.MethodPost, "/update/histogram/testTextHistogram/"+value, "")
+		require.Equal(t, http.StatusOK, code)
+	}
+
+	for _, value := range []string{"invalid", "NaN", "+Inf"} {
+		code, _ := testRequest(t, ts, http.MethodPost, "/update/histogram/testTextHistogram/"+value, "")
+		require.Equal(t, http.StatusBadRequest, code)
+	}
+
+	code, body := testRequest(t, ts, http.MethodGet, "/value/histogram/testTextHistogram", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, "le=\"0.25\" 0\nle=\"0.5\" 1\n")
+	require.Contains(t, body, "le=\"+Inf\" 2\nsum 3.3\ncount 2\n")
+
+	payload := `[{"id": "testJSONHistogram", "type": "histogram", "histogram": {"bounds": [1], "counts": [1, 0], "sum": 0.5, "count": 1}}]`
+	for i := 0; i < 2; i++ {
+		code, _ = testRequest(t, ts, http.MethodPost, "/updates/", payload)
+		require.Equal(t, http.StatusOK, code)
+	}
+
+	code, body = testRequest(t, ts, http.MethodPost, "/value/", `{"id": "testJSONHistogram", "type": "histogram"}`)
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, `{"id":"testJSONHistogram","type":"histogram","histogram":{"bounds":[1],"counts":[2,0],"sum":1,"count":2}}`, body)
+
+	// Bucket counts must add up to count
+	payload = `{"id": "testJSONHistogram", "type": "histogram", "histogram": {"bounds": [1], "counts": [1, 0], "sum": 0.5, "count": 2}}`
+	code, _ = testRequest(t, ts, http.MethodPost, "/update/", payload)
+	require.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestHandlersSummary(t *testing.T) {
+	ts := httptest.NewServer(testServer)
+	defer ts.Close()
+
+	for _, value := range []string{"0", "100"} {
+		code, _ := testRequest(t, ts, http.MethodPost, "/update/summary/testTextSummary/"+value, "")
+		require.Equal(t, http.StatusOK, code)
+	}
+
+	for _, value := range []string{"NaN", "-Inf"} {
+		code, _ := testRequest(t, ts, http.MethodPost, "/update/summary/testTextSummary/"+value, "")
+		require.Equal(t, http.StatusBadRequest, code)
+	}
+
+	code, body := testRequest(t, ts, http.MethodGet, "/value/summary/testTextSummary", "")
+	require.Equal(t, http.StatusOK, code)
+	require.Contains(t, body, "quantile=\"0.5\" 0\n")
+	require.Contains(t, body, "sum 100\ncount 2\n")
+
+	payload := `[{"id": "testJSONSummary", "type": "summary", "summary": {"relative_accuracy": 0.01, "bins": {"0": 1}, "count": 1, "sum": 1}}]`
+	for i := 0; i < 2; i++ {
+		code, _ = testRequest(t, ts, http.MethodPost, "/updates/", payload)
+		require.Equal(t, http.StatusOK, code)
+	}
+
+	code, body = testRequest(t, ts, http.MethodPost, "/value/", `{"id": "testJSONSummary", "type": "summary"}`)
+	require.Equal(t, http.StatusOK, code)
+
+	loaded := storage.Metric{}
+	require.NoError(t, json.Unmarshal([]byte(body), &loaded))
+	require.Equal(t, uint64(2), loaded.Summary.Count)
+	require.Len(t, loaded.Quantiles, 3)
+	require.InEpsilon(t, 1, loaded.Quantiles["0.99"], 0.01)
+
+	// Bin counts must add up to count
+	payload = `{"id": "testJSONSummary", "type": "summary", "summary": {"relative_accuracy": 0.01, "bins": {"0": 1}, "count": 2}}`
+	code, _ = testRequest(t, ts, http.MethodPost, "/update/", payload)
+	require.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestRangeHandler(t *testing.T) {
+	ts := httptest.NewServer(testServer)
+	defer ts.Close()
+
+	from := time.Now().Add(-time.Second).Unix()
+
+	for _, value := range []string{"1", "2"} {
+		code, _ := testRequest(t, ts, http.MethodPost, "/update/gauge/testRangeGauge/"+value+"?label.cpu=0", "")
+		require.Equal(t, http.StatusOK, code)
+	}
+
+	code, body := testRequest(t, ts, http.MethodGet, fmt.Sprintf("/range/testRangeGauge?label.cpu=0&from=%d", from), "")
+	require.Equal(t, http.StatusOK, code)
+
+	history := struct {
+		ID         string            `json:"id"`
+		Labels     map[string]string `json:"labels"`
+		Resolution string            `json:"resolution"`
+		Points     []storage.Point   `json:"points"`
+	}{}
+	require.NoError(t, json.Unmarshal([]byte(body), &history))
+	require.Equal(t, "testRangeGauge", history.ID)
+	require.Equal(t, "raw", history.Resolution)
+	require.Equal(t, map[string]string{"cpu": "0"}, history.Labels)
+	require.Len(t, history.Points, 2)
+	require.Equal(t, float64(2), history.Points[1].Value)
+
+	// Latest sample per step
+	code, body = testRequest(t, ts, http.MethodGet, fmt.Sprintf("/range/testRangeGauge?label.cpu=0&from=%d&to=%d&step=1h", from, from+3600), "")
+	require.Equal(t, http.StatusOK, code)
+	require.NoError(t, json.Unmarshal([]byte(body), &history))
+	require.Len(t, history.Points, 1)
+	require.Equal(t, float64(2), history.Points[0].Value)
+
+	// Minimal sample per step
+	code, body = testRequest(t, ts, http.MethodGet, fmt.Sprintf("/range/testRangeGauge?label.cpu=0&from=%d&to=%d&step=1h&agg=min", from, from+3600), "")
+	require.Equal(t, http.StatusOK, code)
+	require.NoError(t, json.Unmarshal([]byte(body), &history))
+	require.Len(t, history.Points, 1)
+	require.Equal(t, float64(1), history.Points[0].Value)
+
+	// History beyond raw samples retention is served from rollups
+	code, body = testRequest(t, ts, http.MethodGet, fmt.Sprintf("/range/testRangeGauge?label.cpu=0&from=%d", from-24*3600), "")
+	require.Equal(t, http.StatusOK, code)
+	require.NoError(t, json.Unmarshal([]byte(body), &history))
+	require.Equal(t, "1m", history.Resolution)
+
+	badRequests := []string{
+		"/range/testRangeGauge?label.cpu=0&from=yesterday",
+		"/range/testRangeGauge?label.cpu=0&step=-1s",
+		"/range/testRangeGauge?label.cpu=0&step=1ms",
+		"/range/testRangeGauge?label.cpu=0&from=2000&to=1000",
+		"/range/testRangeGauge?label.cpu=0&agg=median",
+	}
+	for _, path := range badRequests {
+		code, _ = testRequest(t, ts, http.MethodGet, path, "")
+		require.Equal(t, http.StatusBadRequest, code, path)
+	}
+
+	code, _ = testRequest(t, ts, http.MethodGet, "/range/testRangeGauge", "")
+	require.Equal(t, http.StatusNotFound, code)
+}
+
+func TestQueryHandler(t *testing.T) {
+	ts := httptest.NewServer(testServer)
+	defer ts.Close()
+
+	for _, path := range []string{
+		"/update/gauge/testQueryGauge/1?label.cpu=0",
+		"/update/gauge/testQueryGauge/3?label.cpu=1",
+		"/update/counter/testQueryCounter/5",
+	} {
+		code, _ := testRequest(t, ts, http.MethodPost, path, "")
+		require.Equal(t, http.StatusOK, code)
+	}
+
+	result := struct {
+		Query  string         `json:"query"`
+		Result []query.Sample `json:"result"`
+	}{}
+
+	code, body := testRequest(t, ts, http.MethodGet, "/api/v1/query?query="+url.QueryEscape("max(testQuery?auge)"), "")
+	require.Equal(t, http.StatusOK, code)
+	require.NoError(t, json.Unmarshal([]byte(body), &result))
+	require.Equal(t, "max(testQuery?auge)", result.Query)
+	require.Equal(t, []query.Sample{{Value: 3}}, result.Result)
+
+	code, body = testRequest(t, ts, http.MethodGet, "/api/v1/query?query="+url.QueryEscape(`topk(1, /testQuery.*/{cpu!="1"})`), "")
+	require.Equal(t, http.StatusOK, code)
+	require.NoError(t, json.Unmarshal([]byte(body), &result))
+	require.Len(t, result.Result, 1)
+	require.Equal(t, "testQueryCounter", result.Result[0].ID)
+	require.Equal(t, float64(5), result.Result[0].Value)
+
+	code, body = testRequest(t, ts, http.MethodGet, "/api/v1/query?query="+url.QueryEscape("rate(testQueryCounter[1m])"), "")
+	require.Equal(t, http.StatusOK, code)
+	require.NoError(t, json.Unmarshal([]byte(body), &result))
+	require.Len(t, result.Result, 1)
+	require.InDelta(t, float64(5)/60, result.Result[0].Value, 1e-9)
+
+	badRequests := []string{
+		"/api/v1/query",
+		"/api/v1/query?query=" + url.QueryEscape("sum(testQueryGauge"),
+		"/api/v1/query?query=" + url.QueryEscape("rate(testQueryCounter[-1m])"),
+		"/api/v1/query?query=testQueryGauge&time=yesterday",
+	}
+	for _, path := range badRequests {
+		code, _ = testRequest(t, ts, http.MethodGet, path, "")
+		require.Equal(t, http.StatusBadRequest, code, path)
+	}
+}
+
 func TestJSONHandlersHashed(t *testing.T) {
 	saveTests := []struct {
 		name     string
@@ -0,0 +1,185 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/horseinthesky/metricsagent/internal/influx"
+	"github.com/horseinthesky/metricsagent/internal/labels"
+	"github.com/horseinthesky/metricsagent/internal/server"
+	"github.com/horseinthesky/metricsagent/internal/server/storage"
+)
+
+// maxInfluxLineSize limits a single line protocol line length.
+const maxInfluxLineSize = 1024 * 1024
+
+// influxLineError is a rejected line protocol line.
+type influxLineError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+// influxWriteResponse is a line protocol partial write result.
+type influxWriteResponse struct {
+	Error string            `json:"error"`
+	Lines []influxLineError `json:"lines"`
+}
+
+// handleInfluxWrite provides InfluxDB line protocol receiver.
+// Every field of a point is a metric with measurement_field ID,
+// host tag is a metric host, other tags are metric labels.
+// Floats and booleans are stored as gauges, integers as counters
+// unless mapped to gauges with InfluxTypes config option.
+// Counter integers are taken as cumulative totals, as Telegraf
+// reports them, and stored as their increments.
+// String fields and timestamps are not kept.
+// Valid lines are stored even if some are rejected,
+// rejected lines are reported with 400.
+func (s *Server) handleInfluxWrite() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		points := []influx.Point{}
+		lineErrors := []influxLineError{}
+
+		scanner := bufio.NewScanner(r.Body)
+		scanner.Buffer(nil, maxInfluxLineSize)
+
+		for n := 1; scanner.Scan(); n++ {
+			line := scanner.Text()
+			if influx.Skip(line) {
+				continue
+			}
+
+			point, err := influx.Parse(line)
+			if err != nil {
+				lineErrors = append(lineErrors, influxLineError{Line: n, Error: err.Error()})
+				continue
+			}
+
+			points = append(points, point)
+		}
+
+		if err := scanner.Err(); err != nil {
+			http.Error(w, `{"error": "failed to read body"}`, http.StatusBadRequest)
+			return
+		}
+
+		totals := s.BeginTotals(r.Context())
+		defer totals.Rollback()
+
+		metrics := []storage.Metric{}
+		for _, point := range points {
+			pointMetrics, err := s.influxMetrics(totals, point)
+			if err != nil {
+				log.Printf("failed to convert line protocol metrics: %s", err)
+				http.Error(w, `{"error": "failed to convert metrics"}`, http.StatusInternalServerError)
+				return
+			}
+
+			metrics = append(metrics, pointMetrics...)
+		}
+
+		if len(metrics) != 0 {
+			if err := s.SaveMetricsBulk(metrics); err != nil {
+				log.Printf("failed to save line protocol metrics: %s", err)
+				http.Error(w, `{"error": "failed to save metrics"}`, http.StatusInternalServerError)
+				return
+			}
+		}
+		totals.Commit()
+
+		if len(lineErrors) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		res, err := json.Marshal(influxWriteResponse{
+			Error: fmt.Sprintf("partial write: %d lines rejected", len(lineErrors)),
+			Lines: lineErrors,
+		})
+		if err != nil {
+			http.Error(w, `{"error": "failed to marshal response"}`, http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write(res)
+	})
+}
+
+// influxMetrics returns metrics line protocol point fields are stored as.
+func (s *Server) influxMetrics(totals *server.TotalsUpdate, point influx.Point) ([]storage.Metric, error) {
+	series := storage.Metric{}
+	for key, value := range point.Tags {
+		if key == hostLabel {
+			series.Host = value
+			continue
+		}
+
+		if series.Labels == nil {
+			series.Labels = map[string]string{}
+		}
+		series.Labels[labels.Sanitize(key)] = value
+	}
+
+	metrics := []storage.Metric{}
+	for _, field := range point.Fields {
+		metric := series
+		metric.ID = point.Measurement + "_" + field.Key
+
+		switch value := field.Value.(type) {
+		case float64:
+			metric.MType = storage.Gauge.String()
+			metric.Value = &value
+		case bool:
+			gauge := 0.0
+			if value {
+				gauge = 1
+			}
+
+			metric.MType = storage.Gauge.String()
+			metric.Value = &gauge
+		case int64:
+			if err := s.influxInteger(totals, &metric, float64(value)); err != nil {
+				return nil, err
+			}
+		case uint64:
+			if err := s.influxInteger(totals, &metric, float64(value)); err != nil {
+				return nil, err
+			}
+		default:
+			continue
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, nil
+}
+
+// influxInteger sets line protocol integer field value of a metric.
+// Integers are stored as gauges if mapped to them
+// and as increments of cumulative totals otherwise.
+func (s *Server) influxInteger(totals *server.TotalsUpdate, metric *storage.Metric, value float64) error {
+	if s.Config.InfluxTypes[metric.ID] == storage.Gauge.String() {
+		metric.MType = storage.Gauge.String()
+		metric.Value = &value
+
+		return nil
+	}
+
+	delta, err := totals.Delta(metric.Key(), value)
+	if err != nil {
+		return err
+	}
+
+	metric.MType = storage.Counter.String()
+	metric.Delta = &delta
+
+	return nil
+}
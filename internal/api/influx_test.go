@@ -0,0 +1,97 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/horseinthesky/metricsagent/internal/server"
+	"github.com/horseinthesky/metricsagent/internal/server/storage"
+)
+
+func TestInfluxWriteHandler(t *testing.T) {
+	influxServer, err := NewServer(server.Config{
+		InfluxTypes: map[string]string{"mem_total": "gauge"},
+	})
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(influxServer)
+	defer ts.Close()
+
+	payload := `# telegraf
+cpu,host=node1,cpu=cpu0 usage_idle=98.5,up=true 1465839830100400200
+net,host=node1,interface=eth0 bytes_recv=100i,name="eth0"
+`
+	code, _ := testRequest(t, ts, http.MethodPost, "/write", payload)
+	require.Equal(t, http.StatusNoContent, code)
+
+	// Integers are stored as increments unless mapped to gauges
+	code, body := testRequest(t, ts, http.MethodPost, "/write", "net,host=node1,interface=eth0 bytes_recv=150i\ncpu usage_idle=\nmem used\nmem,host=node1 total=2048i")
+	require.Equal(t, http.StatusBadRequest, code)
+
+	res := influxWriteResponse{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	require.Len(t, res.Lines, 2)
+	require.Equal(t, 2, res.Lines[0].Line)
+	require.Equal(t, 3, res.Lines[1].Line)
+
+	ctx := context.Background()
+
+	gauge, err := influxServer.DB.Get(ctx, storage.Metric{ID: "cpu_usage_idle", Host: "node1", Labels: map[string]string{"cpu": "cpu0"}}.Key())
+	require.NoError(t, err)
+	require.Equal(t, 98.5, *gauge.Value)
+
+	up, err := influxServer.DB.Get(ctx, storage.Metric{ID: "cpu_up", Host: "node1", Labels: map[string]string{"cpu": "cpu0"}}.Key())
+	require.NoError(t, err)
+	require.Equal(t, 1.0, *up.Value)
+
+	counter, err := influxServer.DB.Get(ctx, storage.Metric{ID: "net_bytes_recv", Host: "node1", Labels: map[string]string{"interface": "eth0"}}.Key())
+	require.NoError(t, err)
+	require.Equal(t, int64(150), *counter.Delta)
+
+	total, err := influxServer.DB.Get(ctx, storage.Metric{ID: "mem_total", Host: "node1"}.Key())
+	require.NoError(t, err)
+	require.Equal(t, "gauge", total.MType)
+	require.Equal(t, 2048.0, *total.Value)
+
+	_, err = influxServer.DB.Get(ctx, storage.Metric{ID: "net_name", Host: "node1", Labels: map[string]string{"interface": "eth0"}}.Key())
+	require.Error(t, err, "string fields must not be stored")
+
+	// Write failed to read is retried with the same increments
+	payload = "net,host=node1,interface=eth0 bytes_recv=170i\n" + strings.Repeat("x", maxInfluxLineSize+1)
+	code, _ = testRequest(t, ts, http.MethodPost, "/write", payload)
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = testRequest(t, ts, http.MethodPost, "/write", "net,host=node1,interface=eth0 bytes_recv=170i")
+	require.Equal(t, http.StatusNoContent, code)
+
+	counter, err = influxServer.DB.Get(ctx, counter.Key())
+	require.NoError(t, err)
+	require.Equal(t, int64(170), *counter.Delta)
+
+	// Compressed payload
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	_, err = gz.Write([]byte("mem,host=node2 used_percent=42"))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/write", &compressed)
+	require.NoError(t, err)
+	req.Header.Set("Content-Encoding", "gzip")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusNoContent, resp.StatusCode)
+
+	_, err = influxServer.DB.Get(ctx, storage.Key{ID: "mem_used_percent", Host: "node2"})
+	require.NoError(t, err)
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+
+	"github.com/horseinthesky/metricsagent/internal/server"
+	"github.com/horseinthesky/metricsagent/internal/server/storage"
+)
+
+// Metrics listing page size limits.
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// listResponse is a metrics listing page.
+type listResponse struct {
+	Total   int              `json:"total"`
+	Metrics []storage.Metric `json:"metrics"`
+	Next    string           `json:"next,omitempty"`
+}
+
+// encodeCursor returns an opaque cursor of series listing continues after.
+func encodeCursor(key storage.Key) string {
+	encoded, _ := json.Marshal(key)
+
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// decodeCursor returns series cursor points to.
+func decodeCursor(cursor string) (*storage.Key, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &storage.Key{}
+	if err := json.Unmarshal(decoded, key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// parseListOptions returns listing options from URL query params.
+func parseListOptions(r *http.Request) (storage.ListOptions, error) {
+	query := r.URL.Query()
+
+	opts := storage.ListOptions{
+		Type:   query.Get("type"),
+		Prefix: query.Get("prefix"),
+		Match:  query.Get("match"),
+		Limit:  defaultListLimit,
+	}
+
+	if opts.Type != "" && storage.UnsupportedType(opts.Type) {
+		return opts, fmt.Errorf("unsupported metric type")
+	}
+
+	if _, err := regexp.Compile(opts.Match); err != nil {
+		return opts, fmt.Errorf("bad match regex")
+	}
+
+	switch query.Get("order") {
+	case "", "asc":
+	case "desc":
+		opts.Desc = true
+	default:
+		return opts, fmt.Errorf("bad order")
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 || n > maxListLimit {
+			return opts, fmt.Errorf("bad limit")
+		}
+		opts.Limit = n
+	}
+
+	if offset := query.Get("offset"); offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("bad offset")
+		}
+		opts.Offset = n
+	}
+
+	if cursor := query.Get("cursor"); cursor != "" {
+		if opts.Offset != 0 {
+			return opts, fmt.Errorf("cursor and offset are exclusive")
+		}
+
+		key, err := decodeCursor(cursor)
+		if err != nil {
+			return opts, fmt.Errorf("bad cursor")
+		}
+		opts.After = key
+	}
+
+	return opts, nil
+}
+
+// handleListMetrics provides stored metrics listing.
+// Metrics are filtered by type, ID prefix and ID regex match query params
+// and listed in series order by ID, host and labels, reversed with order=desc.
+// Pages are selected with limit and either offset or cursor query params,
+// cursor of the next page is returned if there is one.
+func (s *Server) handleListMetrics() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+
+		opts, err := parseListOptions(r)
+		if err != nil {
+			res, _ := json.Marshal(map[string]string{"error": err.Error()})
+			http.Error(w, string(res), http.StatusBadRequest)
+			return
+		}
+
+		page, err := s.DB.List(r.Context(), opts)
+		if err != nil {
+			log.Printf("failed to list metrics: %s", err)
+			http.Error(w, `{"error": "failed to list metrics"}`, http.StatusInternalServerError)
+			return
+		}
+
+		for i := range page.Metrics {
+			if s.Config.Key != "" {
+				page.Metrics[i].Hash = hex.EncodeToString(server.GenerateHash(page.Metrics[i], s.Config.Key))
+			}
+
+			page.Metrics[i].SetQuantiles()
+		}
+
+		response := listResponse{Total: page.Total, Metrics: page.Metrics}
+		if page.Next != nil {
+			response.Next = encodeCursor(*page.Next)
+		}
+
+		res, err := json.Marshal(response)
+		if err != nil {
+			http.Error(w, `{"error": "faied to marshal metrics"}`, http.StatusInternalServerError)
+			return
+		}
+
+		w.Write(res)
+	})
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/horseinthesky/metricsagent/internal/server"
+	"github.com/horseinthesky/metricsagent/internal/server/storage"
+)
+
+func TestListHandler(t *testing.T) {
+	listServer, err := NewServer(server.Config{})
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(listServer)
+	defer ts.Close()
+
+	for _, path := range []string{
+		"/update/gauge/cpu.user/1",
+		"/update/gauge/cpu.system/2",
+		"/update/gauge/cpu.idle/3",
+		"/update/counter/cpu.count/4",
+		"/update/gauge/mem.used/5",
+	} {
+		code, _ := testRequest(t, ts, http.MethodPost, path, "")
+		require.Equal(t, http.StatusOK, code)
+	}
+
+	ids := func(res listResponse) []string {
+		listed := []string{}
+		for _, metric := range res.Metrics {
+			listed = append(listed, metric.ID)
+		}
+
+		return listed
+	}
+
+	res := listResponse{}
+
+	code, body := testRequest(t, ts, http.MethodGet, "/api/v1/metrics?type=gauge&prefix=cpu.&limit=2", "")
+	require.Equal(t, http.StatusOK, code)
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	require.Equal(t, 3, res.Total)
+	require.Equal(t, []string{"cpu.idle", "cpu.system"}, ids(res))
+	require.NotEmpty(t, res.Next)
+
+	code, body = testRequest(t, ts, http.MethodGet, "/api/v1/metrics?type=gauge&prefix=cpu.&limit=2&cursor="+res.Next, "")
+	require.Equal(t, http.StatusOK, code)
+	res = listResponse{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	require.Equal(t, []string{"cpu.user"}, ids(res))
+	require.Empty(t, res.Next)
+
+	code, body = testRequest(t, ts, http.MethodGet, "/api/v1/metrics?order=desc&offset=1&match="+url.QueryEscape("^cpu\\."), "")
+	require.Equal(t, http.StatusOK, code)
+	res = listResponse{}
+	require.NoError(t, json.Unmarshal([]byte(body), &res))
+	require.Equal(t, 4, res.Total)
+	require.Equal(t, []string{"cpu.system", "cpu.idle", "cpu.count"}, ids(res))
+	require.Equal(t, storage.Counter.String(), res.Metrics[2].MType)
+
+	badRequests := []string{
+		"/api/v1/metrics?type=unknown",
+		"/api/v1/metrics?match=" + url.QueryEscape("("),
+		"/api/v1/metrics?order=random",
+		"/api/v1/metrics?limit=0",
+		"/api/v1/metrics?limit=100000",
+		"/api/v1/metrics?offset=-1",
+		"/api/v1/metrics?cursor=bad",
+		"/api/v1/metrics?offset=1&cursor=" + encodeCursor(storage.Key{ID: "cpu.idle"}),
+	}
+	for _, path := range badRequests {
+		code, _ = testRequest(t, ts, http.MethodGet, path, "")
+		require.Equal(t, http.StatusBadRequest, code, path)
+	}
+}
@@ -35,7 +35,6 @@ func (s *Server) trustedSubnet(next http.Handler) http.Handler {
 			return
 		}
 
-		_, trustedNet, _ := net.ParseCIDR(s.Config.TrustedSubnet)
 		requestIP := r.Header.Get("X-Real-IP")
 		if requestIP == "" {
 			log.Println("source IP not found; must be set to X-Real-IP header")
@@ -44,7 +43,7 @@ func (s *Server) trustedSubnet(next http.Handler) http.Handler {
 			return
 		}
 
-		if !trustedNet.Contains(net.ParseIP(requestIP)) {
+		if !s.Trusts(net.ParseIP(requestIP)) {
 			log.Printf("request from %s is forbidden", requestIP)
 			w.WriteHeader(http.StatusForbidden)
 			w.Write([]byte(http.StatusText(http.StatusForbidden)))
@@ -55,6 +54,33 @@ func (s *Server) trustedSubnet(next http.Handler) http.Handler {
 	})
 }
 
+// trustedPeer checks if a request came from a peer of trusted subnet.
+// Used with receivers and scrape endpoint of third party clients
+// which don't set X-Real-IP.
+// Drops a request and returns 403 if not true.
+func (s *Server) trustedPeer(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.Config.TrustedSubnet == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		peerIP, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			peerIP = r.RemoteAddr
+		}
+
+		if !s.Trusts(net.ParseIP(peerIP)) {
+			log.Printf("request from %s is forbidden", peerIP)
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(http.StatusText(http.StatusForbidden)))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // handleDecrypt provides RSA decryption.
 func (s *Server) handleDecrypt(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -106,6 +132,31 @@ func handleGzip(next http.Handler) http.Handler {
 	})
 }
 
+// handleGunzip provides gzip compressed request body decompression.
+// Only used with receivers of third party clients which compress requests.
+func handleGunzip(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			log.Println("failed to decompress body")
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(http.StatusText(http.StatusBadRequest)))
+			return
+		}
+		defer gz.Close()
+
+		r.Body = gz
+		r.Header.Del("Content-Encoding")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // logRequest logs some HTTP request data.
 // Stores:
 //   - method
@@ -145,7 +196,7 @@ func dropUnsupportedTextType(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		metricType := chi.URLParam(r, "metricType")
 
-		if metricType != storage.Gauge.String() && metricType != storage.Counter.String() {
+		if storage.UnsupportedType(metricType) {
 			log.Printf("metric has unsupported type: %s", metricType)
 			w.WriteHeader(http.StatusNotImplemented)
 			w.Write([]byte(http.StatusText(http.StatusNotImplemented)))
@@ -1,11 +1,16 @@
 package api
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/horseinthesky/metricsagent/internal/server"
 )
 
 func TestTrustedSubnet(t *testing.T) {
@@ -49,3 +54,43 @@ func TestTrustedSubnet(t *testing.T) {
 
 	require.Equal(t, http.StatusForbidden, resp.StatusCode)
 }
+
+func TestTrustedPeer(t *testing.T) {
+	ts := httptest.NewServer(testTrustedServer)
+	defer ts.Close()
+
+	// X-Real-IP is not trusted by receivers
+	req, err := http.NewRequest(http.MethodPost, ts.URL+"/write", strings.NewReader("mem used_percent=42"))
+	require.NoError(t, err)
+
+	req.Header.Add("X-Real-IP", "10.10.10.10")
+
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	resp.Body.Close()
+
+	require.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	cryptoKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	peerServer, err := NewServer(server.Config{TrustedSubnet: "127.0.0.0/8"})
+	require.NoError(t, err)
+	peerServer.CryptoKey = cryptoKey
+
+	ts = httptest.NewServer(peerServer)
+	defer ts.Close()
+
+	// Receivers are checked against peer address and not decrypted
+	code, _ := testRequest(t, ts, http.MethodPost, "/write", "mem used_percent=42")
+	require.Equal(t, http.StatusNoContent, code)
+
+	// So are scrapes
+	code, _ = testRequest(t, ts, http.MethodGet, "/metrics", "")
+	require.Equal(t, http.StatusOK, code)
+
+	// Agent routes still require X-Real-IP
+	code, _ = testRequest(t, ts, http.MethodPost, "/update/counter/peerCounter/100", "")
+	require.Equal(t, http.StatusForbidden, code)
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/horseinthesky/metricsagent/internal/server"
+)
+
+// OTLP/HTTP payload content types.
+const (
+	otlpProtobufContentType = "application/x-protobuf"
+	otlpJSONContentType     = "application/json"
+)
+
+// handleOTLPMetrics provides OTLP/HTTP metrics receiver.
+// Export request is obtained from protobuf or JSON payload.
+// Response is encoded the same way.
+func (s *Server) handleOTLPMetrics() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if contentType != otlpProtobufContentType && contentType != otlpJSONContentType {
+			http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		payload, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		exportRequest := &colmetricspb.ExportMetricsServiceRequest{}
+		if contentType == otlpJSONContentType {
+			err = protojson.Unmarshal(payload, exportRequest)
+		} else {
+			err = proto.Unmarshal(payload, exportRequest)
+		}
+		if err != nil {
+			http.Error(w, "failed to unmarshal export request", http.StatusBadRequest)
+			return
+		}
+
+		totals := s.BeginTotals(r.Context())
+		defer totals.Rollback()
+
+		metrics, err := s.OTLPMetrics(totals, exportRequest)
+		if errors.Is(err, server.ErrTotalsLookup) {
+			log.Printf("failed to convert OTLP metrics: %s", err)
+			http.Error(w, "failed to convert metrics", http.StatusInternalServerError)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if len(metrics) != 0 {
+			if err := s.SaveMetricsBulk(metrics); err != nil {
+				log.Printf("failed to save OTLP metrics: %s", err)
+				http.Error(w, "failed to save metrics", http.StatusInternalServerError)
+				return
+			}
+		}
+		totals.Commit()
+
+		var res []byte
+		if contentType == otlpJSONContentType {
+			res, err = protojson.Marshal(&colmetricspb.ExportMetricsServiceResponse{})
+		} else {
+			res, err = proto.Marshal(&colmetricspb.ExportMetricsServiceResponse{})
+		}
+		if err != nil {
+			http.Error(w, "failed to marshal export response", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Write(res)
+	})
+}
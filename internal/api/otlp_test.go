@@ -0,0 +1,57 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/horseinthesky/metricsagent/internal/server"
+	"github.com/horseinthesky/metricsagent/internal/server/storage"
+)
+
+func TestOTLPMetricsHandler(t *testing.T) {
+	otlpServer, err := NewServer(server.Config{})
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(otlpServer)
+	defer ts.Close()
+
+	payload := `{"resourceMetrics": [{
+		"resource": {"attributes": [{"key": "host.name", "value": {"stringValue": "node1"}}]},
+		"scopeMetrics": [{"metrics": [{
+			"name": "http.server.requests",
+			"sum": {
+				"aggregationTemporality": 2,
+				"isMonotonic": true,
+				"dataPoints": [{
+					"attributes": [{"key": "http.route", "value": {"stringValue": "/ping"}}],
+					"asInt": "3"
+				}]
+			}
+		}]}]
+	}]}`
+
+	resp, err := http.Post(ts.URL+"/v1/metrics", "application/json", strings.NewReader(payload))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	require.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+
+	key := storage.Metric{ID: "http.server.requests", Host: "node1", Labels: map[string]string{"http_route": "/ping"}}.Key()
+	counter, err := otlpServer.DB.Get(context.Background(), key)
+	require.NoError(t, err)
+	require.Equal(t, int64(3), *counter.Delta)
+
+	code, _ := testRequest(t, ts, http.MethodPost, "/v1/metrics", payload)
+	require.Equal(t, http.StatusUnsupportedMediaType, code)
+
+	resp, err = http.Post(ts.URL+"/v1/metrics", "application/x-protobuf", strings.NewReader("garbage"))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
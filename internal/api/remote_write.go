@@ -0,0 +1,153 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/horseinthesky/metricsagent/internal/pb"
+	"github.com/horseinthesky/metricsagent/internal/server"
+	"github.com/horseinthesky/metricsagent/internal/server/storage"
+)
+
+// nameLabel is a Prometheus series label carrying metric name.
+const nameLabel = "__name__"
+
+// counterSuffixes are metric name suffixes of cumulative
+// Prometheus series stored as counters unless typed explicitly.
+var counterSuffixes = []string{"_total", "_count", "_bucket"}
+
+// remoteWriteType returns a type remote write series is stored as.
+// Configured types take precedence over metric name suffixes.
+func (s *Server) remoteWriteType(name string) string {
+	if mtype, ok := s.Config.RemoteWriteTypes[name]; ok {
+		return mtype
+	}
+
+	for _, suffix := range counterSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return storage.Counter.String()
+		}
+	}
+
+	return storage.Gauge.String()
+}
+
+// handleRemoteWrite provides Prometheus remote write receiver.
+// Series are obtained from snappy compressed protobuf WriteRequest payload.
+// Series name label is a metric ID, host label is a metric host,
+// other labels are metric labels.
+// Counter samples are cumulative totals and stored as their increments,
+// non-finite ones are rejected.
+// Sample timestamps are not kept, samples are stored as received.
+func (s *Server) handleRemoteWrite() http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "failed to read body", http.StatusBadRequest)
+			return
+		}
+		defer r.Body.Close()
+
+		payload, err := snappy.Decode(nil, compressed)
+		if err != nil {
+			http.Error(w, "failed to decompress body", http.StatusBadRequest)
+			return
+		}
+
+		writeRequest := &pb.WriteRequest{}
+		if err := proto.Unmarshal(payload, writeRequest); err != nil {
+			http.Error(w, "failed to unmarshal write request", http.StatusBadRequest)
+			return
+		}
+
+		totals := s.BeginTotals(r.Context())
+		defer totals.Rollback()
+
+		metrics := []storage.Metric{}
+		for _, series := range writeRequest.Timeseries {
+			metric, ok := seriesMetric(series)
+			if !ok {
+				continue
+			}
+			metric.MType = s.remoteWriteType(metric.ID)
+
+			// Samples may come in any order
+			samples := series.Samples
+			sort.SliceStable(samples, func(i, j int) bool { return samples[i].Timestamp < samples[j].Timestamp })
+
+			for _, sample := range samples {
+				// Staleness markers and other NaNs have no value to store
+				if math.IsNaN(sample.Value) {
+					continue
+				}
+
+				metric := metric
+				switch metric.MType {
+				case storage.Counter.String():
+					delta, err := totals.Delta(metric.Key(), sample.Value)
+					if errors.Is(err, server.ErrTotalsLookup) {
+						log.Printf("failed to convert remote write metrics: %s", err)
+						http.Error(w, "failed to convert metrics", http.StatusInternalServerError)
+						return
+					}
+					if err != nil {
+						http.Error(w, "invalid metric", http.StatusBadRequest)
+						return
+					}
+					metric.Delta = &delta
+				default:
+					value := sample.Value
+					metric.Value = &value
+				}
+
+				if err := metric.Validate(); err != nil {
+					http.Error(w, "invalid metric", http.StatusBadRequest)
+					return
+				}
+
+				metrics = append(metrics, metric)
+			}
+		}
+
+		if len(metrics) != 0 {
+			if err := s.SaveMetricsBulk(metrics); err != nil {
+				log.Printf("failed to save remote write metrics: %s", err)
+				http.Error(w, "failed to save metrics", http.StatusInternalServerError)
+				return
+			}
+		}
+		totals.Commit()
+
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+// seriesMetric returns a metric remote write series is stored as.
+// Series without name are skipped.
+func seriesMetric(series *pb.TimeSeries) (storage.Metric, bool) {
+	metric := storage.Metric{}
+
+	for _, label := range series.Labels {
+		switch label.Name {
+		case nameLabel:
+			metric.ID = label.Value
+		case hostLabel:
+			metric.Host = label.Value
+		default:
+			if metric.Labels == nil {
+				metric.Labels = map[string]string{}
+			}
+			metric.Labels[label.Name] = label.Value
+		}
+	}
+
+	return metric, metric.ID != ""
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/horseinthesky/metricsagent/internal/pb"
+	"github.com/horseinthesky/metricsagent/internal/server"
+	"github.com/horseinthesky/metricsagent/internal/server/storage"
+)
+
+func remoteWrite(t *testing.T, ts *httptest.Server, series ...*pb.TimeSeries) int {
+	payload, err := proto.Marshal(&pb.WriteRequest{Timeseries: series})
+	require.NoError(t, err)
+
+	resp, err := http.Post(ts.URL+"/api/v1/write", "application/x-protobuf", bytes.NewReader(snappy.Encode(nil, payload)))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	return resp.StatusCode
+}
+
+func TestRemoteWriteHandler(t *testing.T) {
+	remoteWriteServer, err := NewServer(server.Config{
+		RemoteWriteTypes: map[string]string{"queue_total": "gauge"},
+	})
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(remoteWriteServer)
+	defer ts.Close()
+
+	series := func(name string, values ...float64) *pb.TimeSeries {
+		timeSeries := &pb.TimeSeries{Labels: []*pb.Label{
+			{Name: "__name__", Value: name},
+			{Name: "host", Value: "node1"},
+			{Name: "job", Value: "node"},
+		}}
+		for i, value := range values {
+			timeSeries.Samples = append(timeSeries.Samples, &pb.Sample{Value: value, Timestamp: int64(i)})
+		}
+
+		return timeSeries
+	}
+
+	code := remoteWrite(t, ts, series("requests_total", 5), series("temperature", 20.5), series("queue_total", 3))
+	require.Equal(t, http.StatusNoContent, code)
+
+	// Counter totals are stored as increments
+	code = remoteWrite(t, ts, series("requests_total", 7, 8), series("temperature", 21))
+	require.Equal(t, http.StatusNoContent, code)
+
+	labels := map[string]string{"job": "node"}
+	ctx := context.Background()
+
+	counter, err := remoteWriteServer.DB.Get(ctx, storage.Metric{ID: "requests_total", Host: "node1", Labels: labels}.Key())
+	require.NoError(t, err)
+	require.Equal(t, "counter", counter.MType)
+	require.Equal(t, int64(8), *counter.Delta)
+
+	gauge, err := remoteWriteServer.DB.Get(ctx, storage.Metric{ID: "temperature", Host: "node1", Labels: labels}.Key())
+	require.NoError(t, err)
+	require.Equal(t, "gauge", gauge.MType)
+	require.Equal(t, 21.0, *gauge.Value)
+
+	typed, err := remoteWriteServer.DB.Get(ctx, storage.Metric{ID: "queue_total", Host: "node1", Labels: labels}.Key())
+	require.NoError(t, err)
+	require.Equal(t, "gauge", typed.MType)
+
+	// Rejected write doesn't advance totals
+	invalid := series("errors_total", 1)
+	invalid.Labels = append(invalid.Labels, &pb.Label{Name: "1job", Value: "node"})
+
+	code = remoteWrite(t, ts, series("requests_total", 10), invalid)
+	require.Equal(t, http.StatusBadRequest, code)
+
+	code = remoteWrite(t, ts, series("requests_total", 10))
+	require.Equal(t, http.StatusNoContent, code)
+
+	counter, err = remoteWriteServer.DB.Get(ctx, storage.Metric{ID: "requests_total", Host: "node1", Labels: labels}.Key())
+	require.NoError(t, err)
+	require.Equal(t, int64(10), *counter.Delta)
+
+	// Non-finite counter totals are rejected
+	code = remoteWrite(t, ts, series("requests_total", math.Inf(1)))
+	require.Equal(t, http.StatusBadRequest, code)
+
+	resp, err := http.Post(ts.URL+"/api/v1/write", "application/x-protobuf", bytes.NewReader([]byte("not snappy")))
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
@@ -7,6 +7,11 @@
 //   - secure.go - server metrics hash protection
 //   - middleware.go - server middleware
 //   - handlers.go - server HTTP router endpoints buciness logic
+//   - list.go - server metrics listing API
+//   - exposition.go - server Prometheus metrics exposition
+//   - remote_write.go - server Prometheus remote write receiver
+//   - otlp.go - server OpenTelemetry OTLP/HTTP metrics receiver
+//   - influx.go - server InfluxDB line protocol receiver
 package api
 
 import (
@@ -45,35 +50,62 @@ func NewServer(cfg server.Config) (*Server, error) {
 
 // setupRouter builds Server's HTTP router.
 // Assembles middleware and handlers.
+// Agent routes are checked against X-Real-IP and decrypted,
+// receivers of third party clients are checked against the peer address.
 func (s *Server) setupRouter() {
-	s.Use(s.trustedSubnet)
 	s.Use(handleGzip)
-	// s.Use(logRequest)
-	s.Use(s.handleDecrypt)
-	s.Use(middleware.RequestID)
-	s.Use(middleware.RealIP)
-	s.Use(middleware.Logger)
-	s.Use(middleware.Recoverer)
-
-	s.Route("/update", func(r chi.Router) {
-		r.Route("/{metricType}", func(r chi.Router) {
-			r.Use(dropUnsupportedTextType)
-			r.Post("/{metricName}/{value}", s.handleSaveTextMetric())
+
+	common := chi.Middlewares{
+		middleware.RequestID,
+		middleware.RealIP,
+		middleware.Logger,
+		middleware.Recoverer,
+	}
+
+	s.Group(func(r chi.Router) {
+		r.Use(s.trustedSubnet)
+		// r.Use(logRequest)
+		r.Use(s.handleDecrypt)
+		r.Use(common...)
+
+		r.Route("/update", func(r chi.Router) {
+			r.Route("/{metricType}", func(r chi.Router) {
+				r.Use(dropUnsupportedTextType)
+				r.Post("/{metricName}/{value}", s.handleSaveTextMetric())
+			})
+			r.Post("/", s.handleSaveJSONMetric())
 		})
-		r.Post("/", s.handleSaveJSONMetric())
+		r.Post("/updates/", s.handleSaveJSONMetrics())
+
+		r.Route("/value", func(r chi.Router) {
+			r.Route("/{metricType}", func(r chi.Router) {
+				r.Use(dropUnsupportedTextType)
+				r.Get("/{metricName}", s.handleLoadTextMetric())
+			})
+			r.Post("/", s.handleLoadJSONMetric())
+		})
+
+		r.Get("/range/{metricName}", s.handleLoadRange())
+		r.Get("/api/v1/query", s.handleQuery())
+		r.Get("/api/v1/metrics", s.handleListMetrics())
+
+		r.Get("/", s.handleDashboard())
+		r.Get("/ping", s.handlePingDB())
 	})
-	s.Post("/updates/", s.handleSaveJSONMetrics())
 
-	s.Route("/value", func(r chi.Router) {
-		r.Route("/{metricType}", func(r chi.Router) {
-			r.Use(dropUnsupportedTextType)
-			r.Get("/{metricName}", s.handleLoadTextMetric())
+	s.Group(func(r chi.Router) {
+		r.Use(s.trustedPeer)
+		r.Use(common...)
+
+		r.Post("/api/v1/write", s.handleRemoteWrite())
+		r.Get("/metrics", s.handleExposition())
+
+		r.Group(func(r chi.Router) {
+			r.Use(handleGunzip)
+			r.Post("/v1/metrics", s.handleOTLPMetrics())
+			r.Post("/write", s.handleInfluxWrite())
 		})
-		r.Post("/", s.handleLoadJSONMetric())
 	})
-
-	s.Get("/", s.handleDashboard())
-	s.Get("/ping", s.handlePingDB())
 }
 
 // Run is a Server entry point.
@@ -3,23 +3,50 @@ package gapi
 import (
 	"github.com/horseinthesky/metricsagent/internal/pb"
 	"github.com/horseinthesky/metricsagent/internal/server/storage"
+	"github.com/horseinthesky/metricsagent/internal/sketch"
 )
 
 func MetricFromPB(pbMetric *pb.Metric) storage.Metric {
-	return storage.Metric{
-		ID:    pbMetric.Id,
-		MType: pbMetric.Mtype,
-		Delta: &pbMetric.Delta,
-		Value: &pbMetric.Value,
-		Hash:  pbMetric.Hash,
+	metric := storage.Metric{
+		ID:     pbMetric.Id,
+		Host:   pbMetric.Host,
+		Labels: pbMetric.Labels,
+		MType:  pbMetric.Mtype,
+		Delta:  &pbMetric.Delta,
+		Value:  &pbMetric.Value,
+		Hash:   pbMetric.Hash,
 	}
+
+	if pbMetric.Histogram != nil {
+		metric.Histogram = &storage.HistogramData{
+			Bounds: pbMetric.Histogram.Bounds,
+			Counts: pbMetric.Histogram.Counts,
+			Sum:    pbMetric.Histogram.Sum,
+			Count:  pbMetric.Histogram.Count,
+		}
+	}
+
+	if pbMetric.Summary != nil {
+		metric.Summary = &sketch.Sketch{
+			RelativeAccuracy: pbMetric.Summary.RelativeAccuracy,
+			Bins:             pbMetric.Summary.Bins,
+			Zeros:            pbMetric.Summary.Zeros,
+			Count:            pbMetric.Summary.Count,
+			Sum:              pbMetric.Summary.Sum,
+		}
+	}
+
+	return metric
 }
 
 func MetricToPB(metric storage.Metric) *pb.Metric {
 	pbMetric := &pb.Metric{
-		Id:    metric.ID,
-		Mtype: metric.MType,
-		Hash:  metric.Hash,
+		Id:        metric.ID,
+		Host:      metric.Host,
+		Labels:    metric.Labels,
+		Mtype:     metric.MType,
+		Hash:      metric.Hash,
+		Quantiles: metric.Quantiles,
 	}
 
 	if metric.Delta != nil {
@@ -30,5 +57,24 @@ func MetricToPB(metric storage.Metric) *pb.Metric {
 		pbMetric.Value = *metric.Value
 	}
 
+	if metric.Histogram != nil {
+		pbMetric.Histogram = &pb.Histogram{
+			Bounds: metric.Histogram.Bounds,
+			Counts: metric.Histogram.Counts,
+			Sum:    metric.Histogram.Sum,
+			Count:  metric.Histogram.Count,
+		}
+	}
+
+	if metric.Summary != nil {
+		pbMetric.Summary = &pb.Sketch{
+			RelativeAccuracy: metric.Summary.RelativeAccuracy,
+			Bins:             metric.Summary.Bins,
+			Zeros:            metric.Summary.Zeros,
+			Count:            metric.Summary.Count,
+			Sum:              metric.Summary.Sum,
+		}
+	}
+
 	return pbMetric
 }
@@ -0,0 +1,35 @@
+package gapi
+
+import (
+	"context"
+	"errors"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/horseinthesky/metricsagent/internal/server"
+)
+
+// Export stores metrics of OTLP export request.
+func (s *GRPCServer) Export(ctx context.Context, req *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	totals := s.BeginTotals(ctx)
+	defer totals.Rollback()
+
+	metrics, err := s.OTLPMetrics(totals, req)
+	if errors.Is(err, server.ErrTotalsLookup) {
+		return nil, status.Error(codes.Internal, "failed to convert metrics")
+	}
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if len(metrics) != 0 {
+		if err := s.SaveMetricsBulk(metrics); err != nil {
+			return nil, status.Error(codes.Internal, "failed to store metrics")
+		}
+	}
+	totals.Commit()
+
+	return &colmetricspb.ExportMetricsServiceResponse{}, nil
+}
@@ -0,0 +1,73 @@
+package gapi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/horseinthesky/metricsagent/internal/server/storage"
+)
+
+func exportRequest(histogram *metricspb.HistogramDataPoint) *colmetricspb.ExportMetricsServiceRequest {
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				{Key: "host.name", Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "node1"}}},
+			}},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{
+				{
+					Name: "process.threads",
+					Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{
+						{Value: &metricspb.NumberDataPoint_AsInt{AsInt: 12}},
+					}}},
+				},
+				{
+					Name: "rpc.duration",
+					Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+						AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+						DataPoints:             []*metricspb.HistogramDataPoint{histogram},
+					}},
+				},
+			}}},
+		}},
+	}
+}
+
+func TestExport(t *testing.T) {
+	ctx := context.Background()
+
+	client, testServer, closer := runOTLPTestServer(ctx)
+	defer closer()
+
+	sum := 3.0
+	_, err := client.Export(ctx, exportRequest(&metricspb.HistogramDataPoint{
+		ExplicitBounds: []float64{1},
+		BucketCounts:   []uint64{1, 1},
+		Sum:            &sum,
+		Count:          2,
+	}))
+	require.NoError(t, err)
+
+	gauge, err := testServer.DB.Get(ctx, storage.Key{Host: "node1", ID: "process.threads"})
+	require.NoError(t, err)
+	require.Equal(t, 12.0, *gauge.Value)
+
+	histogram, err := testServer.DB.Get(ctx, storage.Key{Host: "node1", ID: "rpc.duration"})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), histogram.Histogram.Count)
+
+	// Bucket counts must add up to count
+	_, err = client.Export(ctx, exportRequest(&metricspb.HistogramDataPoint{
+		ExplicitBounds: []float64{1},
+		BucketCounts:   []uint64{1, 1},
+		Count:          3,
+	}))
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -22,9 +22,7 @@ func (s *GRPCServer) protectInterceptor(ctx context.Context, req interface{}, in
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	_, trustedNet, _ := net.ParseCIDR(s.Config.TrustedSubnet)
-
-	if !trustedNet.Contains(requestIP) {
+	if !s.Trusts(requestIP) {
 		return nil, status.Errorf(codes.PermissionDenied, "request from %s is forbidden", requestIP.String())
 	}
 
@@ -11,17 +11,21 @@ import (
 	"google.golang.org/grpc/status"
 )
 
+// LoadMetric returns a stored metric.
+// Summary is returned with its p50, p90 and p99 quantiles.
 func (s *GRPCServer) LoadMetric(ctx context.Context, req *pb.LoadMetricRequest) (*pb.Metric, error) {
 	metricRequest := storage.Metric{
-		ID:    req.Id,
-		MType: req.Mtype,
+		ID:     req.Id,
+		Host:   req.Host,
+		Labels: req.Labels,
+		MType:  req.Mtype,
 	}
 
 	if storage.UnsupportedType(metricRequest.MType) {
 		return nil, status.Error(codes.Unimplemented, "unsupported metric type")
 	}
 
-	metric, err := s.DB.Get(ctx, metricRequest.ID)
+	metric, err := s.DB.Get(ctx, metricRequest.Key())
 	if err != nil {
 		return nil, status.Error(codes.NotFound, "unknown metric id")
 	}
@@ -30,5 +34,7 @@ func (s *GRPCServer) LoadMetric(ctx context.Context, req *pb.LoadMetricRequest)
 		metric.Hash = hex.EncodeToString(server.GenerateHash(metric, s.Config.Key))
 	}
 
+	metric.SetQuantiles()
+
 	return MetricToPB(metric), nil
 }
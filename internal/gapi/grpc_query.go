@@ -0,0 +1,45 @@
+package gapi
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/horseinthesky/metricsagent/internal/pb"
+	"github.com/horseinthesky/metricsagent/internal/query"
+)
+
+// Query evaluates a query against stored metrics.
+// Query time is given in unix seconds, zero time is now.
+func (s *GRPCServer) Query(ctx context.Context, req *pb.QueryRequest) (*pb.QueryResponse, error) {
+	expr, err := query.Parse(req.Query)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	now := time.Now()
+	if req.Time != 0 {
+		now = time.Unix(req.Time, 0)
+	}
+
+	samples, err := query.Eval(ctx, s.DB, s.Config.Retention(), expr, now)
+	if err != nil {
+		log.Printf("failed to evaluate query: %s", err)
+		return nil, status.Error(codes.Internal, "failed to evaluate query")
+	}
+
+	res := &pb.QueryResponse{Query: expr.String()}
+	for _, sample := range samples {
+		res.Samples = append(res.Samples, &pb.QuerySample{
+			Id:     sample.ID,
+			Host:   sample.Host,
+			Labels: sample.Labels,
+			Value:  sample.Value,
+		})
+	}
+
+	return res, nil
+}
@@ -0,0 +1,40 @@
+package gapi
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/horseinthesky/metricsagent/internal/pb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuery(t *testing.T) {
+	ctx := context.Background()
+
+	client, closer := runTestServer(ctx, "")
+	defer closer()
+
+	metrics := []*pb.Metric{
+		{Id: "queryGauge", Mtype: "gauge", Value: 1, Host: "node1"},
+		{Id: "queryGauge", Mtype: "gauge", Value: 3, Host: "node2"},
+	}
+	_, err := client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: metrics})
+	require.NoError(t, err)
+
+	res, err := client.Query(ctx, &pb.QueryRequest{Query: "sum( query* )"})
+	require.NoError(t, err)
+	require.Equal(t, "sum(query*)", res.Query)
+	require.Len(t, res.Samples, 1)
+	require.Equal(t, float64(4), res.Samples[0].Value)
+
+	res, err = client.Query(ctx, &pb.QueryRequest{Query: `queryGauge{host="node2"}`})
+	require.NoError(t, err)
+	require.Len(t, res.Samples, 1)
+	require.Equal(t, "node2", res.Samples[0].Host)
+
+	_, err = client.Query(ctx, &pb.QueryRequest{Query: "sum(queryGauge"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
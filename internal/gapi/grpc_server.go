@@ -6,6 +6,7 @@ import (
 	"log"
 	"net"
 
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/reflection"
 
@@ -16,6 +17,7 @@ import (
 type GRPCServer struct {
 	*server.GenericServer
 	pb.UnimplementedMetricsAgentServer
+	colmetricspb.UnimplementedMetricsServiceServer
 }
 
 func NewGRPCServer(cfg server.Config) (*GRPCServer, error) {
@@ -27,6 +29,7 @@ func NewGRPCServer(cfg server.Config) (*GRPCServer, error) {
 	server := &GRPCServer{
 		genericServer,
 		pb.UnimplementedMetricsAgentServer{},
+		colmetricspb.UnimplementedMetricsServiceServer{},
 	}
 
 	return server, nil
@@ -42,6 +45,7 @@ func (s *GRPCServer) Run(ctx context.Context) {
 
 	grpcServer := grpc.NewServer(grpc.ChainUnaryInterceptor(s.protectInterceptor))
 	pb.RegisterMetricsAgentServer(grpcServer, s)
+	colmetricspb.RegisterMetricsServiceServer(grpcServer, s)
 	reflection.Register(grpcServer)
 
 	s.WorkGroup.Add(1)
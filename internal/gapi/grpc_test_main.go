@@ -7,19 +7,35 @@ import (
 
 	"github.com/horseinthesky/metricsagent/internal/pb"
 	"github.com/horseinthesky/metricsagent/internal/server"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/test/bufconn"
 )
 
 func runTestServer(ctx context.Context, hashKey string) (pb.MetricsAgentClient, func()) {
+	testServer, _ := NewGRPCServer(server.Config{Key: hashKey})
+
+	conn, closer := serveTestServer(ctx, testServer)
+
+	return pb.NewMetricsAgentClient(conn), closer
+}
+
+func runOTLPTestServer(ctx context.Context) (colmetricspb.MetricsServiceClient, *GRPCServer, func()) {
+	testServer, _ := NewGRPCServer(server.Config{})
+
+	conn, closer := serveTestServer(ctx, testServer)
+
+	return colmetricspb.NewMetricsServiceClient(conn), testServer, closer
+}
+
+func serveTestServer(ctx context.Context, testServer *GRPCServer) (*grpc.ClientConn, func()) {
 	buffer := 101024 * 1024
 	lis := bufconn.Listen(buffer)
 
-	testServer, _ := NewGRPCServer(server.Config{Key: hashKey})
-
 	grpcServer := grpc.NewServer()
 	pb.RegisterMetricsAgentServer(grpcServer, testServer)
+	colmetricspb.RegisterMetricsServiceServer(grpcServer, testServer)
 	go func() {
 		if err := grpcServer.Serve(lis); err != nil {
 			log.Printf("error serving server: %v", err)
@@ -45,7 +61,5 @@ func runTestServer(ctx context.Context, hashKey string) (pb.MetricsAgentClient,
 		grpcServer.Stop()
 	}
 
-	client := pb.NewMetricsAgentClient(conn)
-
-	return client, closer
+	return conn, closer
 }
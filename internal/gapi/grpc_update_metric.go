@@ -21,6 +21,10 @@ func (s *GRPCServer) UpdateMetric(ctx context.Context, req *pb.Metric) (*emptypb
 		return nil, status.Error(codes.Unimplemented, "unsupported metric type")
 	}
 
+	if err := metric.Validate(); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
 	if s.Config.Key != "" {
 		localHash := server.GenerateHash(metric, s.Config.Key)
 		remoteHash, err := hex.DecodeString(metric.Hash)
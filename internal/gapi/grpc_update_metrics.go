@@ -4,8 +4,11 @@ import (
 	"context"
 	"crypto/hmac"
 	"encoding/hex"
+	"errors"
+	"log"
 
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/types/known/emptypb"
 
@@ -14,6 +17,36 @@ import (
 	"github.com/horseinthesky/metricsagent/internal/server/storage"
 )
 
+// batchIDKey is a metadata key carrying an ID agents assign to every metrics batch.
+const batchIDKey = "x-batch-id"
+
+// getBatchID returns batch ID from request metadata if any.
+func getBatchID(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get(batchIDKey)
+	if len(values) == 0 {
+		return ""
+	}
+
+	return values[0]
+}
+
+// getClientSource returns client address to scope batch IDs by.
+func getClientSource(ctx context.Context) string {
+	ip, _ := getClientIP(ctx)
+	if ip == nil {
+		return ""
+	}
+
+	return ip.String()
+}
+
+// UpdateMetrics stores a batch of metrics.
+// Batch with x-batch-id metadata is applied only once per source.
 func (s *GRPCServer) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest) (*emptypb.Empty, error) {
 	metrics := []storage.Metric{}
 
@@ -24,6 +57,10 @@ func (s *GRPCServer) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsReq
 			return nil, status.Error(codes.Unimplemented, "unsupported metric type")
 		}
 
+		if err := metric.Validate(); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+
 		if s.Config.Key != "" {
 			localHash := server.GenerateHash(metric, s.Config.Key)
 			remoteHash, err := hex.DecodeString(metric.Hash)
@@ -39,7 +76,13 @@ func (s *GRPCServer) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsReq
 		metrics = append(metrics, metric)
 	}
 
-	err := s.SaveMetricsBulk(metrics)
+	batchID := getBatchID(ctx)
+
+	err := s.SaveMetricsBatch(getClientSource(ctx), batchID, metrics)
+	if errors.Is(err, storage.ErrDuplicateBatch) {
+		log.Printf("batch %s is already applied, skipped", batchID)
+		return &emptypb.Empty{}, nil
+	}
 	if err != nil {
 		return nil, status.Error(codes.Internal, "failed to store metric")
 	}
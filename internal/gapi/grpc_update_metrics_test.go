@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	"github.com/horseinthesky/metricsagent/internal/pb"
@@ -138,3 +139,81 @@ func TestUpdateMetrics(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateMetricsBatch(t *testing.T) {
+	ctx := context.Background()
+
+	client, closer := runTestServer(ctx, "")
+	defer closer()
+
+	delta := int64(5)
+	payload := &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{MetricToPB(storage.Metric{
+		ID:    "testBatchCounter",
+		MType: "counter",
+		Delta: &delta,
+	})}}
+
+	batchCtx := metadata.AppendToOutgoingContext(ctx, batchIDKey, "testBatch")
+
+	for i := 0; i < 2; i++ {
+		_, err := client.UpdateMetrics(batchCtx, payload)
+		require.NoError(t, err)
+	}
+
+	// Retried batch is applied once
+	metric, err := client.LoadMetric(ctx, &pb.LoadMetricRequest{Id: "testBatchCounter", Mtype: "counter"})
+	require.NoError(t, err)
+	require.Equal(t, delta, metric.Delta)
+}
+
+func TestUpdateMetricsHistogram(t *testing.T) {
+	ctx := context.Background()
+
+	client, closer := runTestServer(ctx, "")
+	defer closer()
+
+	metric := &pb.Metric{
+		Id:        "testHistogram",
+		Mtype:     "histogram",
+		Histogram: &pb.Histogram{Bounds: []float64{1}, Counts: []uint64{0, 1}, Sum: 2, Count: 1},
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{metric}})
+		require.NoError(t, err)
+	}
+
+	loaded, err := client.LoadMetric(ctx, &pb.LoadMetricRequest{Id: "testHistogram", Mtype: "histogram"})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{0, 2}, loaded.Histogram.Counts)
+	require.Equal(t, uint64(2), loaded.Histogram.Count)
+	require.Equal(t, float64(4), loaded.Histogram.Sum)
+
+	// Histogram value is required
+	_, err = client.UpdateMetric(ctx, &pb.Metric{Id: "testHistogram", Mtype: "histogram"})
+	require.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestUpdateMetricsSummary(t *testing.T) {
+	ctx := context.Background()
+
+	client, closer := runTestServer(ctx, "")
+	defer closer()
+
+	metric := &pb.Metric{
+		Id:      "testSummary",
+		Mtype:   "summary",
+		Summary: &pb.Sketch{RelativeAccuracy: 0.01, Bins: map[int32]uint64{0: 1}, Count: 1, Sum: 1},
+	}
+
+	for i := 0; i < 2; i++ {
+		_, err := client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{metric}})
+		require.NoError(t, err)
+	}
+
+	loaded, err := client.LoadMetric(ctx, &pb.LoadMetricRequest{Id: "testSummary", Mtype: "summary"})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), loaded.Summary.Count)
+	require.Len(t, loaded.Quantiles, 3)
+	require.InEpsilon(t, 1, loaded.Quantiles["0.99"], 0.01)
+}
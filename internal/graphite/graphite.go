@@ -0,0 +1,213 @@
+// Package graphite provides Graphite plaintext protocol parsing.
+//
+// A line describes a metric value:
+//
+//	servers.node1.cpu.load 0.75 1465839830
+//
+// Dot separated metric path is mapped to a metric ID, host and labels
+// with templates. Template is an optional filter followed by a pattern:
+//
+//	servers.* .host.id*
+//
+// Filter parts are globs matching path parts from the start.
+// Pattern parts name the path parts: host is a metric host,
+// id parts are joined into a metric ID, id* takes the rest of the path,
+// empty parts are skipped and any other name is a label.
+// Template without filter matches any path.
+package graphite
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/horseinthesky/metricsagent/internal/labels"
+)
+
+// Line is a parsed plaintext protocol line.
+type Line struct {
+	Path      string
+	Value     float64
+	Timestamp *float64 // nil if not provided or -1
+}
+
+// Parse parses a single line.
+func Parse(s string) (Line, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 && len(fields) != 3 {
+		return Line{}, fmt.Errorf("invalid line %q: want \"path value [timestamp]\"", s)
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return Line{}, fmt.Errorf("invalid value %q", fields[1])
+	}
+
+	line := Line{Path: fields[0], Value: value}
+
+	if len(fields) == 3 {
+		timestamp, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return Line{}, fmt.Errorf("invalid timestamp %q", fields[2])
+		}
+
+		if timestamp != -1 {
+			line.Timestamp = &timestamp
+		}
+	}
+
+	return line, nil
+}
+
+// Template token names with special meaning.
+const (
+	hostToken   = "host"
+	idToken     = "id"
+	idRestToken = "id*"
+)
+
+// Template maps matching metric paths to metric series.
+type Template struct {
+	filter  []string
+	pattern []string
+}
+
+// ParseTemplate parses "[filter ]pattern" template.
+func ParseTemplate(s string) (Template, error) {
+	fields := strings.Fields(s)
+
+	var template Template
+	switch len(fields) {
+	case 1:
+		template.pattern = strings.Split(fields[0], ".")
+	case 2:
+		template.filter = strings.Split(fields[0], ".")
+		template.pattern = strings.Split(fields[1], ".")
+	default:
+		return Template{}, fmt.Errorf("invalid template %q: want \"[filter ]pattern\"", s)
+	}
+
+	for _, part := range template.filter {
+		if _, err := path.Match(part, ""); err != nil {
+			return Template{}, fmt.Errorf("invalid template %q filter: %w", s, err)
+		}
+	}
+
+	hasID := false
+	for i, token := range template.pattern {
+		switch token {
+		case "", hostToken:
+		case idToken:
+			hasID = true
+		case idRestToken:
+			if i != len(template.pattern)-1 {
+				return Template{}, fmt.Errorf("invalid template %q: %s must be the last part", s, idRestToken)
+			}
+			hasID = true
+		default:
+			if labels.Sanitize(token) != token {
+				return Template{}, fmt.Errorf("invalid template %q: bad label name %q", s, token)
+			}
+		}
+	}
+
+	if !hasID {
+		return Template{}, errors.New("invalid template: no id parts")
+	}
+
+	return template, nil
+}
+
+// matches checks if template filter matches path parts.
+func (t Template) matches(parts []string) bool {
+	if len(parts) < len(t.filter) {
+		return false
+	}
+
+	for i, glob := range t.filter {
+		if ok, _ := path.Match(glob, parts[i]); !ok {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Series is a metric series a path is mapped to.
+type Series struct {
+	ID     string
+	Host   string
+	Labels map[string]string
+}
+
+// apply maps path parts to series.
+func (t Template) apply(parts []string) Series {
+	series := Series{}
+	id := []string{}
+
+	for i, part := range parts {
+		if i >= len(t.pattern) {
+			break
+		}
+
+		switch token := t.pattern[i]; token {
+		case "":
+		case hostToken:
+			series.Host = part
+		case idToken:
+			id = append(id, part)
+		case idRestToken:
+			id = append(id, parts[i:]...)
+		default:
+			if series.Labels == nil {
+				series.Labels = map[string]string{}
+			}
+			series.Labels[token] = part
+		}
+	}
+
+	series.ID = strings.Join(id, ".")
+
+	return series
+}
+
+// Templates map metric paths to series with the first matching template.
+type Templates []Template
+
+// ParseTemplates parses templates.
+func ParseTemplates(specs []string) (Templates, error) {
+	templates := make(Templates, 0, len(specs))
+	for _, spec := range specs {
+		template, err := ParseTemplate(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		templates = append(templates, template)
+	}
+
+	return templates, nil
+}
+
+// Series maps a metric path to series.
+// Path no template matches or maps to an empty ID is the series ID itself.
+func (ts Templates) Series(metricPath string) Series {
+	parts := strings.Split(metricPath, ".")
+
+	for _, template := range ts {
+		if !template.matches(parts) {
+			continue
+		}
+
+		if series := template.apply(parts); series.ID != "" {
+			return series
+		}
+
+		break
+	}
+
+	return Series{ID: metricPath}
+}
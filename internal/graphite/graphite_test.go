@@ -0,0 +1,62 @@
+package graphite
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	timestamp := 1465839830.0
+
+	line, err := Parse("servers.node1.cpu.load 0.75 1465839830\n")
+	require.NoError(t, err)
+	require.Equal(t, Line{Path: "servers.node1.cpu.load", Value: 0.75, Timestamp: &timestamp}, line)
+
+	line, err = Parse("jobs.backup.duration 12 -1")
+	require.NoError(t, err)
+	require.Equal(t, Line{Path: "jobs.backup.duration", Value: 12}, line)
+
+	for _, invalid := range []string{
+		"",
+		"jobs.backup.duration",
+		"jobs.backup.duration twelve",
+		"jobs.backup.duration NaN",
+		"jobs.backup.duration 12 now",
+		"jobs.backup.duration 12 1465839830 extra",
+	} {
+		_, err := Parse(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	templates, err := ParseTemplates([]string{
+		"servers.* .host.id*",
+		"jobs.*.* .job.id",
+		"id.dc",
+	})
+	require.NoError(t, err)
+
+	require.Equal(t, Series{ID: "cpu.load", Host: "node1"}, templates.Series("servers.node1.cpu.load"))
+	require.Equal(t, Series{ID: "duration", Labels: map[string]string{"job": "backup"}}, templates.Series("jobs.backup.duration"))
+	require.Equal(t, Series{ID: "latency", Labels: map[string]string{"dc": "eu"}}, templates.Series("latency.eu"))
+
+	// Path maps to no ID
+	require.Equal(t, Series{ID: "servers.node1"}, templates.Series("servers.node1"))
+
+	// No templates
+	require.Equal(t, Series{ID: "servers.node1.cpu.load"}, Templates(nil).Series("servers.node1.cpu.load"))
+
+	for _, invalid := range []string{
+		"",
+		"servers.* .host.id* extra",
+		"servers.[ .host.id*",
+		".host",
+		"id*.host",
+		"id.bad-label",
+	} {
+		_, err := ParseTemplate(invalid)
+		require.Error(t, err, invalid)
+	}
+}
@@ -0,0 +1,196 @@
+// Package influx provides InfluxDB line protocol parsing.
+//
+// A line describes a point:
+//
+//	measurement,tag=value field=1.0,count=3i 1465839830100400200
+//
+// Measurement is followed by optional comma separated tags,
+// at least one field and an optional timestamp. Commas, spaces
+// and equal signs in names and tag values are escaped with a backslash.
+// Field values are floats, integers with i suffix, unsigned integers
+// with u suffix, booleans or double quoted strings.
+package influx
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Field is a point field.
+// Value is float64, int64, uint64, bool or string.
+type Field struct {
+	Key   string
+	Value any
+}
+
+// Point is a parsed line protocol line.
+type Point struct {
+	Measurement string
+	Tags        map[string]string
+	Fields      []Field
+	Timestamp   *int64 // nil if not provided
+}
+
+// Skip checks if a line has no point: it is empty or a comment.
+func Skip(line string) bool {
+	line = strings.TrimSpace(line)
+
+	return line == "" || strings.HasPrefix(line, "#")
+}
+
+// Parse parses a single line.
+func Parse(line string) (Point, error) {
+	sections := split(strings.TrimSpace(line), ' ', true)
+	if len(sections) < 2 {
+		return Point{}, errors.New("missing fields")
+	}
+	if len(sections) > 3 {
+		return Point{}, errors.New("unexpected data after timestamp")
+	}
+
+	point := Point{}
+
+	series := split(sections[0], ',', false)
+	point.Measurement = unescape(series[0])
+	if point.Measurement == "" {
+		return Point{}, errors.New("missing measurement")
+	}
+
+	for _, tag := range series[1:] {
+		key, value, err := pair(tag)
+		if err != nil {
+			return Point{}, fmt.Errorf("invalid tag: %w", err)
+		}
+
+		if point.Tags == nil {
+			point.Tags = map[string]string{}
+		}
+		point.Tags[key] = unescape(value)
+	}
+
+	for _, field := range split(sections[1], ',', true) {
+		key, rawValue, err := pair(field)
+		if err != nil {
+			return Point{}, fmt.Errorf("invalid field: %w", err)
+		}
+
+		value, err := parseValue(rawValue)
+		if err != nil {
+			return Point{}, fmt.Errorf("invalid field %s value: %w", key, err)
+		}
+
+		point.Fields = append(point.Fields, Field{Key: key, Value: value})
+	}
+
+	if len(sections) == 3 {
+		timestamp, err := strconv.ParseInt(sections[2], 10, 64)
+		if err != nil {
+			return Point{}, errors.New("invalid timestamp")
+		}
+
+		point.Timestamp = &timestamp
+	}
+
+	return point, nil
+}
+
+// split splits s by unescaped separators.
+// Separators in double quoted strings are skipped if quotes is true.
+func split(s string, sep byte, quotes bool) []string {
+	parts := []string{}
+
+	start, quoted := 0, false
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\':
+			i++
+		case c == '"' && quotes:
+			quoted = !quoted
+		case c == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// pair splits "key=value" by the first unescaped equal sign.
+// Key is unescaped, value is returned as is.
+func pair(s string) (string, string, error) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '=':
+			key := unescape(s[:i])
+			if key == "" {
+				return "", "", errors.New("missing key")
+			}
+
+			return key, s[i+1:], nil
+		}
+	}
+
+	return "", "", fmt.Errorf("missing value of %q", s)
+}
+
+// unescape removes backslashes escaping commas, spaces and equal signs.
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && strings.IndexByte(", =", s[i+1]) != -1 {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
+
+// parseValue parses field value.
+func parseValue(s string) (any, error) {
+	if s == "" {
+		return nil, errors.New("missing value")
+	}
+
+	if s[0] == '"' {
+		if len(s) < 2 || s[len(s)-1] != '"' {
+			return nil, errors.New("unterminated string")
+		}
+
+		return strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(s[1 : len(s)-1]), nil
+	}
+
+	switch s {
+	case "t", "T", "true", "True", "TRUE":
+		return true, nil
+	case "f", "F", "false", "False", "FALSE":
+		return false, nil
+	}
+
+	switch s[len(s)-1] {
+	case 'i':
+		return strconv.ParseInt(s[:len(s)-1], 10, 64)
+	case 'u':
+		return strconv.ParseUint(s[:len(s)-1], 10, 64)
+	}
+
+	value, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, errors.New("value must be finite")
+	}
+
+	return value, nil
+}
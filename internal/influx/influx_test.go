@@ -0,0 +1,59 @@
+package influx
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	timestamp := int64(1465839830100400200)
+
+	point, err := Parse(`cpu,host=node1,cpu=cpu0 usage_idle=98.5,processes=3i,total=7u,up=true 1465839830100400200`)
+	require.NoError(t, err)
+	require.Equal(t, Point{
+		Measurement: "cpu",
+		Tags:        map[string]string{"host": "node1", "cpu": "cpu0"},
+		Fields: []Field{
+			{Key: "usage_idle", Value: 98.5},
+			{Key: "processes", Value: int64(3)},
+			{Key: "total", Value: uint64(7)},
+			{Key: "up", Value: true},
+		},
+		Timestamp: &timestamp,
+	}, point)
+
+	point, err = Parse(`disk\ io,path=C:\\,dev\=x=sd\,a msg="a \"b\", c",value=1`)
+	require.NoError(t, err)
+	require.Equal(t, Point{
+		Measurement: "disk io",
+		Tags:        map[string]string{"path": `C:\\`, "dev=x": "sd,a"},
+		Fields: []Field{
+			{Key: "msg", Value: `a "b", c`},
+			{Key: "value", Value: 1.0},
+		},
+	}, point)
+
+	for _, invalid := range []string{
+		`cpu`,
+		`,host=node1 value=1`,
+		`cpu,host value=1`,
+		`cpu value=`,
+		`cpu value=1x`,
+		`cpu value=NaN`,
+		`cpu value=1.5i`,
+		`cpu msg="unterminated`,
+		`cpu value=1 now`,
+		`cpu value=1 1 1`,
+	} {
+		_, err := Parse(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	require.True(t, Skip(""))
+	require.True(t, Skip("  "))
+	require.True(t, Skip("# comment"))
+	require.False(t, Skip("cpu value=1"))
+}
@@ -0,0 +1,118 @@
+// Package labels provides canonical metric label set encoding.
+//
+// Label set is encoded as name="value" pairs sorted by name
+// and joined with commas, e.g.:
+//
+//	dc="eu",env="prod"
+//
+// Values are Go-quoted. Canonical form identifies metric series
+// and is a part of metric hash data.
+package labels
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// namePattern is a valid label name.
+var namePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Sanitize returns a valid label name
+// with invalid characters replaced with underscores.
+func Sanitize(name string) string {
+	if namePattern.MatchString(name) {
+		return name
+	}
+
+	b := []byte(name)
+	for i, c := range b {
+		switch {
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i != 0:
+		default:
+			b[i] = '_'
+		}
+	}
+
+	if len(b) == 0 {
+		return "_"
+	}
+
+	return string(b)
+}
+
+// Format returns canonical label set form.
+// Empty label set is an empty string.
+func Format(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for i, name := range names {
+		if i != 0 {
+			b.WriteByte(',')
+		}
+
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[name]))
+	}
+
+	return b.String()
+}
+
+// Parse parses canonical label set form.
+// Empty string is an empty label set.
+func Parse(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	labels := map[string]string{}
+
+	for rest := s; rest != ""; {
+		name, tail, ok := strings.Cut(rest, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid labels %q: no value for %q", s, name)
+		}
+
+		quoted, err := strconv.QuotedPrefix(tail)
+		if err != nil {
+			return nil, fmt.Errorf("invalid labels %q: bad value of %q", s, name)
+		}
+
+		value, _ := strconv.Unquote(quoted)
+		labels[name] = value
+
+		rest = tail[len(quoted):]
+		if rest != "" {
+			if rest[0] != ',' {
+				return nil, fmt.Errorf("invalid labels %q: unexpected %q", s, rest)
+			}
+			rest = rest[1:]
+		}
+	}
+
+	return labels, Validate(labels)
+}
+
+// Validate checks label names.
+func Validate(labels map[string]string) error {
+	for name := range labels {
+		if !namePattern.MatchString(name) {
+			return fmt.Errorf("invalid label name %q", name)
+		}
+	}
+
+	return nil
+}
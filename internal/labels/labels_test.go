@@ -0,0 +1,42 @@
+package labels
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormat(t *testing.T) {
+	require.Equal(t, "", Format(nil))
+	require.Equal(t, `cpu="3",env="prod"`, Format(map[string]string{"env": "prod", "cpu": "3"}))
+	require.Equal(t, `cmd="a,b=\"c\""`, Format(map[string]string{"cmd": `a,b="c"`}))
+}
+
+func TestParse(t *testing.T) {
+	for _, labels := range []map[string]string{
+		nil,
+		{"env": "prod", "cpu": "3"},
+		{"cmd": `a,b="c"`, "empty": ""},
+	} {
+		parsed, err := Parse(Format(labels))
+		require.NoError(t, err)
+		require.Equal(t, labels, parsed)
+	}
+
+	for _, invalid := range []string{
+		`env`,
+		`env=prod`,
+		`env="prod"cpu="3"`,
+		`1env="prod"`,
+	} {
+		_, err := Parse(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestSanitize(t *testing.T) {
+	require.Equal(t, "env", Sanitize("env"))
+	require.Equal(t, "service_name", Sanitize("service.name"))
+	require.Equal(t, "_xx_cpu", Sanitize("1xx-cpu"))
+	require.Equal(t, "_", Sanitize(""))
+}
@@ -27,6 +27,7 @@ type MetricsAgentClient interface {
 	UpdateMetric(ctx context.Context, in *Metric, opts ...grpc.CallOption) (*empty.Empty, error)
 	UpdateMetrics(ctx context.Context, in *UpdateMetricsRequest, opts ...grpc.CallOption) (*empty.Empty, error)
 	LoadMetric(ctx context.Context, in *LoadMetricRequest, opts ...grpc.CallOption) (*Metric, error)
+	Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error)
 }
 
 type metricsAgentClient struct {
@@ -73,6 +74,15 @@ func (c *metricsAgentClient) LoadMetric(ctx context.Context, in *LoadMetricReque
 	return out, nil
 }
 
+func (c *metricsAgentClient) Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error) {
+	out := new(QueryResponse)
+	err := c.cc.Invoke(ctx, "/metricagent.MetricsAgent/Query", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MetricsAgentServer is the server API for MetricsAgent service.
 // All implementations must embed UnimplementedMetricsAgentServer
 // for forward compatibility
@@ -81,6 +91,7 @@ type MetricsAgentServer interface {
 	UpdateMetric(context.Context, *Metric) (*empty.Empty, error)
 	UpdateMetrics(context.Context, *UpdateMetricsRequest) (*empty.Empty, error)
 	LoadMetric(context.Context, *LoadMetricRequest) (*Metric, error)
+	Query(context.Context, *QueryRequest) (*QueryResponse, error)
 	mustEmbedUnimplementedMetricsAgentServer()
 }
 
@@ -100,6 +111,9 @@ func (UnimplementedMetricsAgentServer) UpdateMetrics(context.Context, *UpdateMet
 func (UnimplementedMetricsAgentServer) LoadMetric(context.Context, *LoadMetricRequest) (*Metric, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method LoadMetric not implemented")
 }
+func (UnimplementedMetricsAgentServer) Query(context.Context, *QueryRequest) (*QueryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Query not implemented")
+}
 func (UnimplementedMetricsAgentServer) mustEmbedUnimplementedMetricsAgentServer() {}
 
 // UnsafeMetricsAgentServer may be embedded to opt out of forward compatibility for this service.
@@ -185,6 +199,24 @@ func _MetricsAgent_LoadMetric_Handler(srv interface{}, ctx context.Context, dec
 	return interceptor(ctx, in, info, handler)
 }
 
+func _MetricsAgent_Query_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsAgentServer).Query(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/metricagent.MetricsAgent/Query",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsAgentServer).Query(ctx, req.(*QueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // MetricsAgent_ServiceDesc is the grpc.ServiceDesc for MetricsAgent service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -208,6 +240,10 @@ var MetricsAgent_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "LoadMetric",
 			Handler:    _MetricsAgent_LoadMetric_Handler,
 		},
+		{
+			MethodName: "Query",
+			Handler:    _MetricsAgent_Query_Handler,
+		},
 	},
 	Streams:  []grpc.StreamDesc{},
 	Metadata: "service.proto",
@@ -0,0 +1,292 @@
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parser is a recursive descent query parser.
+type parser struct {
+	input string
+	pos   int
+}
+
+// Parse parses a query expression.
+func Parse(input string) (Expr, error) {
+	p := &parser{input: input}
+
+	expr, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpaces()
+	if p.pos != len(p.input) {
+		return nil, p.errorf("unexpected %q", p.input[p.pos:])
+	}
+
+	return expr, nil
+}
+
+// errorf returns a syntax error at current position.
+func (p *parser) errorf(format string, args ...any) error {
+	return fmt.Errorf("query syntax error at %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
+
+// skipSpaces skips whitespace.
+func (p *parser) skipSpaces() {
+	for p.pos < len(p.input) && strings.IndexByte(" \t\n", p.input[p.pos]) != -1 {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space character or 0 at the end of input.
+func (p *parser) peek() byte {
+	p.skipSpaces()
+	if p.pos == len(p.input) {
+		return 0
+	}
+
+	return p.input[p.pos]
+}
+
+// expect consumes the next non-space character if it is c.
+func (p *parser) expect(c byte) error {
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+
+	return nil
+}
+
+// consume consumes s if input continues with it.
+func (p *parser) consume(s string) bool {
+	p.skipSpaces()
+	if !strings.HasPrefix(p.input[p.pos:], s) {
+		return false
+	}
+	p.pos += len(s)
+
+	return true
+}
+
+// isIDChar checks if c may be a part of metric ID glob.
+func isIDChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		strings.IndexByte("_.:-*?", c) != -1
+}
+
+// word reads metric ID glob, function or label name.
+func (p *parser) word() string {
+	p.skipSpaces()
+
+	start := p.pos
+	for p.pos < len(p.input) && isIDChar(p.input[p.pos]) {
+		p.pos++
+	}
+
+	return p.input[start:p.pos]
+}
+
+// expr parses a function call or a selector.
+func (p *parser) expr() (Expr, error) {
+	start := p.pos
+	name := p.word()
+
+	if p.peek() != '(' || !isFunction(name) {
+		p.pos = start
+		return p.selector()
+	}
+	p.pos++
+
+	var expr Expr
+	switch name {
+	case "rate":
+		selector, err := p.selector()
+		if err != nil {
+			return nil, err
+		}
+
+		window, err := p.window()
+		if err != nil {
+			return nil, err
+		}
+
+		expr = &rate{selector: selector, window: window}
+	case "topk":
+		k, err := p.number()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expect(','); err != nil {
+			return nil, err
+		}
+
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+
+		expr = &topk{k: k, arg: arg}
+	default:
+		arg, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+
+		expr = &aggregation{name: name, arg: arg}
+	}
+
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+
+	return expr, nil
+}
+
+// number parses a positive integer.
+func (p *parser) number() (int, error) {
+	p.skipSpaces()
+
+	start := p.pos
+	for p.pos < len(p.input) && p.input[p.pos] >= '0' && p.input[p.pos] <= '9' {
+		p.pos++
+	}
+
+	n, err := strconv.Atoi(p.input[start:p.pos])
+	if err != nil || n <= 0 {
+		return 0, p.errorf("expected positive integer")
+	}
+
+	return n, nil
+}
+
+// window parses "[duration]" range window.
+func (p *parser) window() (time.Duration, error) {
+	if err := p.expect('['); err != nil {
+		return 0, err
+	}
+
+	end := strings.IndexByte(p.input[p.pos:], ']')
+	if end == -1 {
+		return 0, p.errorf("unterminated range window")
+	}
+
+	window, err := time.ParseDuration(strings.TrimSpace(p.input[p.pos : p.pos+end]))
+	if err != nil || window <= 0 {
+		return 0, p.errorf("bad range window")
+	}
+	p.pos += end + 1
+
+	return window, nil
+}
+
+// selector parses metric ID glob or /regex/
+// optionally followed by {label matchers}.
+func (p *parser) selector() (*selector, error) {
+	s := &selector{}
+
+	if p.peek() == '/' {
+		p.pos++
+
+		var b strings.Builder
+		for ; p.pos < len(p.input) && p.input[p.pos] != '/'; p.pos++ {
+			if p.input[p.pos] == '\\' && p.pos+1 < len(p.input) && p.input[p.pos+1] == '/' {
+				p.pos++
+			}
+			b.WriteByte(p.input[p.pos])
+		}
+
+		if err := p.expect('/'); err != nil {
+			return nil, p.errorf("unterminated ID regex")
+		}
+
+		re, err := regexp.Compile("^(?:" + b.String() + ")$")
+		if err != nil {
+			return nil, p.errorf("bad ID regex: %s", err)
+		}
+		s.id = re
+		s.text = "/" + b.String() + "/"
+	} else {
+		glob := p.word()
+		if glob == "" {
+			return nil, p.errorf("expected metric ID selector")
+		}
+
+		s.id = globRegexp(glob)
+		s.text = glob
+	}
+
+	if p.peek() != '{' {
+		return s, nil
+	}
+	p.pos++
+
+	for p.peek() != '}' {
+		if len(s.matchers) != 0 {
+			if err := p.expect(','); err != nil {
+				return nil, err
+			}
+		}
+
+		m, err := p.matcher()
+		if err != nil {
+			return nil, err
+		}
+
+		s.matchers = append(s.matchers, m)
+	}
+	p.pos++
+
+	return s, nil
+}
+
+// matcher parses label="value" matcher.
+func (p *parser) matcher() (matcher, error) {
+	m := matcher{name: p.word()}
+	if m.name == "" {
+		return matcher{}, p.errorf("expected label name")
+	}
+
+	for _, op := range []string{"=~", "!~", "!=", "="} {
+		if p.consume(op) {
+			m.op = op
+			break
+		}
+	}
+	if m.op == "" {
+		return matcher{}, p.errorf("expected label matcher operator")
+	}
+
+	p.skipSpaces()
+	quoted, err := strconv.QuotedPrefix(p.input[p.pos:])
+	if err != nil {
+		return matcher{}, p.errorf("expected quoted label value")
+	}
+	p.pos += len(quoted)
+	m.value, _ = strconv.Unquote(quoted)
+
+	if m.op == "=~" || m.op == "!~" {
+		m.re, err = regexp.Compile("^(?:" + m.value + ")$")
+		if err != nil {
+			return matcher{}, p.errorf("bad label regex: %s", err)
+		}
+	}
+
+	return m, nil
+}
+
+// globRegexp returns a regexp matching IDs by glob
+// where * is any number of characters and ? is a single one.
+func globRegexp(glob string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(glob)
+	quoted = strings.ReplaceAll(quoted, `\*`, ".*")
+	quoted = strings.ReplaceAll(quoted, `\?`, ".")
+
+	return regexp.MustCompile("^" + quoted + "$")
+}
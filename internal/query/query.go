@@ -0,0 +1,320 @@
+// Package query provides stored metrics query language.
+//
+// Selector matches gauge and counter series by ID glob, where * is
+// any number of characters and ? is a single one, or by /regex/.
+// Selector may be followed by label matchers, host is matched
+// as a label:
+//
+//	cpu.*{host="node1", core=~"[0-3]"}
+//
+// Matchers are = and != for values, =~ and !~ for regexes.
+// Selected series are aggregated with functions:
+//
+//	sum(expr), avg(expr), min(expr), max(expr), count(expr)
+//	topk(k, expr)
+//	rate(selector[window])
+//
+// Aggregations collapse series into one, topk keeps k series
+// with the largest values and rate returns per second increase
+// of counters over the window preceding query time.
+package query
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/horseinthesky/metricsagent/internal/server/storage"
+)
+
+// Sample is a query result series value.
+// Aggregated samples have no series identity.
+type Sample struct {
+	ID     string            `json:"id,omitempty"`
+	Host   string            `json:"host,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// Expr is a parsed query expression.
+type Expr interface {
+	eval(ctx context.Context, env env) ([]Sample, error)
+	String() string
+}
+
+// env is an expression evaluation environment.
+type env struct {
+	db        storage.Storage
+	retention storage.Retention
+	now       time.Time
+}
+
+// Eval evaluates query expression against stored metrics at given time.
+// History retention selects history resolution rate is evaluated at.
+func Eval(ctx context.Context, db storage.Storage, retention storage.Retention, expr Expr, now time.Time) ([]Sample, error) {
+	return expr.eval(ctx, env{db: db, retention: retention, now: now})
+}
+
+// isFunction checks if name is a query function.
+func isFunction(name string) bool {
+	switch name {
+	case "sum", "avg", "min", "max", "count", "topk", "rate":
+		return true
+	}
+
+	return false
+}
+
+// matcher matches a label value.
+type matcher struct {
+	name  string
+	op    string
+	value string
+	re    *regexp.Regexp
+}
+
+// matches checks if label value matches.
+func (m matcher) matches(value string) bool {
+	switch m.op {
+	case "=":
+		return value == m.value
+	case "!=":
+		return value != m.value
+	case "=~":
+		return m.re.MatchString(value)
+	default:
+		return !m.re.MatchString(value)
+	}
+}
+
+// String returns matcher query form.
+func (m matcher) String() string {
+	return fmt.Sprintf("%s%s%q", m.name, m.op, m.value)
+}
+
+// selector selects gauge and counter series.
+type selector struct {
+	text     string
+	id       *regexp.Regexp
+	matchers []matcher
+}
+
+// String returns selector query form.
+func (s *selector) String() string {
+	if len(s.matchers) == 0 {
+		return s.text
+	}
+
+	matchers := make([]string, 0, len(s.matchers))
+	for _, m := range s.matchers {
+		matchers = append(matchers, m.String())
+	}
+
+	return s.text + "{" + strings.Join(matchers, ", ") + "}"
+}
+
+// matches checks if metric is selected.
+func (s *selector) matches(metric storage.Metric) bool {
+	if !s.id.MatchString(metric.ID) {
+		return false
+	}
+
+	for _, m := range s.matchers {
+		value := metric.Labels[m.name]
+		if m.name == "host" {
+			value = metric.Host
+		}
+
+		if !m.matches(value) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// selected returns selected metrics sorted by series.
+func (s *selector) selected(ctx context.Context, env env) ([]storage.Metric, error) {
+	allMetrics, err := env.db.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []storage.Key{}
+	for key, metric := range allMetrics {
+		if s.matches(metric) {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].ID != keys[j].ID {
+			return keys[i].ID < keys[j].ID
+		}
+
+		if keys[i].Host != keys[j].Host {
+			return keys[i].Host < keys[j].Host
+		}
+
+		return keys[i].Labels < keys[j].Labels
+	})
+
+	metrics := make([]storage.Metric, 0, len(keys))
+	for _, key := range keys {
+		metrics = append(metrics, allMetrics[key])
+	}
+
+	return metrics, nil
+}
+
+// eval returns latest values of selected series.
+// Counters value is their total.
+func (s *selector) eval(ctx context.Context, env env) ([]Sample, error) {
+	metrics, err := s.selected(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := []Sample{}
+	for _, metric := range metrics {
+		var value float64
+		switch {
+		case metric.MType == storage.Gauge.String() && metric.Value != nil:
+			value = *metric.Value
+		case metric.MType == storage.Counter.String() && metric.Delta != nil:
+			value = float64(*metric.Delta)
+		default:
+			continue
+		}
+
+		samples = append(samples, Sample{ID: metric.ID, Host: metric.Host, Labels: metric.Labels, Value: value})
+	}
+
+	return samples, nil
+}
+
+// aggregation collapses series into one.
+type aggregation struct {
+	name string
+	arg  Expr
+}
+
+// String returns aggregation query form.
+func (a *aggregation) String() string {
+	return fmt.Sprintf("%s(%s)", a.name, a.arg)
+}
+
+// eval returns aggregated value of argument series.
+// Aggregation of no series has no value.
+func (a *aggregation) eval(ctx context.Context, env env) ([]Sample, error) {
+	samples, err := a.arg.eval(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(samples) == 0 {
+		return []Sample{}, nil
+	}
+
+	result := samples[0].Value
+	for _, sample := range samples[1:] {
+		switch a.name {
+		case "sum", "avg":
+			result += sample.Value
+		case "min":
+			result = math.Min(result, sample.Value)
+		case "max":
+			result = math.Max(result, sample.Value)
+		}
+	}
+
+	switch a.name {
+	case "avg":
+		result /= float64(len(samples))
+	case "count":
+		result = float64(len(samples))
+	}
+
+	return []Sample{{Value: result}}, nil
+}
+
+// topk keeps k series with the largest values.
+type topk struct {
+	k   int
+	arg Expr
+}
+
+// String returns topk query form.
+func (t *topk) String() string {
+	return fmt.Sprintf("topk(%d, %s)", t.k, t.arg)
+}
+
+// eval returns k argument series with the largest values in descending order.
+func (t *topk) eval(ctx context.Context, env env) ([]Sample, error) {
+	samples, err := t.arg.eval(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(samples, func(i, j int) bool { return samples[i].Value > samples[j].Value })
+
+	if len(samples) > t.k {
+		samples = samples[:t.k]
+	}
+
+	return samples, nil
+}
+
+// rate is a per second increase of counters.
+type rate struct {
+	selector *selector
+	window   time.Duration
+}
+
+// String returns rate query form.
+func (r *rate) String() string {
+	return fmt.Sprintf("rate(%s[%s])", r.selector, r.window)
+}
+
+// eval returns per second increase of selected counters over the window.
+// Other series are skipped.
+func (r *rate) eval(ctx context.Context, env env) ([]Sample, error) {
+	metrics, err := r.selector.selected(ctx, env)
+	if err != nil {
+		return nil, err
+	}
+
+	// History is expired relative to the current time, not the evaluation one
+	from := env.now.Add(-r.window)
+	resolution := storage.PickResolution(env.retention, time.Now(), from)
+
+	samples := []Sample{}
+	for _, metric := range metrics {
+		if metric.MType != storage.Counter.String() {
+			continue
+		}
+
+		rollups, err := storage.History(ctx, env.db, metric.Key(), resolution, from, env.now)
+		if err != nil {
+			return nil, err
+		}
+
+		var increase float64
+		for _, rollup := range rollups {
+			increase += rollup.Delta
+		}
+
+		samples = append(samples, Sample{
+			ID:     metric.ID,
+			Host:   metric.Host,
+			Labels: metric.Labels,
+			Value:  increase / r.window.Seconds(),
+		})
+	}
+
+	return samples, nil
+}
@@ -0,0 +1,113 @@
+package query
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/horseinthesky/metricsagent/internal/server/storage"
+)
+
+func TestParse(t *testing.T) {
+	for _, valid := range []struct {
+		query    string
+		expected string
+	}{
+		{`cpu.*`, `cpu.*`},
+		{` sum( cpu.load{host="node1",core=~"[0-3]"} ) `, `sum(cpu.load{host="node1", core=~"[0-3]"})`},
+		{`topk(2, /cpu\.(load|idle)/{host!~"test.*"})`, `topk(2, /cpu\.(load|idle)/{host!~"test.*"})`},
+		{`avg(rate(requests_total[5m]))`, `avg(rate(requests_total[5m0s]))`},
+		{`sum`, `sum`},
+	} {
+		expr, err := Parse(valid.query)
+		require.NoError(t, err, valid.query)
+		require.Equal(t, valid.expected, expr.String())
+	}
+
+	for _, invalid := range []string{
+		``,
+		`sum(`,
+		`sum(cpu) extra`,
+		`topk(0, cpu)`,
+		`topk(cpu)`,
+		`rate(requests_total)`,
+		`rate(requests_total[5x])`,
+		`rate(requests_total[5m)`,
+		`cpu{host}`,
+		`cpu{host="node1"`,
+		`cpu{host=node1}`,
+		`cpu{host=~"["}`,
+		`/[/`,
+		`/cpu`,
+	} {
+		_, err := Parse(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestEval(t *testing.T) {
+	db := storage.NewMemoryStorage()
+	ctx := context.Background()
+
+	gauge := func(id, host string, value float64) storage.Metric {
+		return storage.Metric{ID: id, Host: host, MType: "gauge", Value: &value}
+	}
+	counter := func(id, host string, delta int64) storage.Metric {
+		return storage.Metric{ID: id, Host: host, MType: "counter", Delta: &delta}
+	}
+
+	require.NoError(t, db.SetBulk([]storage.Metric{
+		gauge("cpu.load", "node1", 1),
+		gauge("cpu.load", "node2", 3),
+		gauge("cpu.idle", "node1", 8),
+		gauge("mem.used", "node1", 100),
+		counter("requests_total", "node1", 60),
+		counter("requests_total", "node2", 30),
+	}))
+
+	retention := storage.Retention{Raw: time.Hour, Minute: 24 * time.Hour, Hour: 30 * 24 * time.Hour}
+	now := time.Now()
+
+	eval := func(query string) []Sample {
+		expr, err := Parse(query)
+		require.NoError(t, err, query)
+
+		samples, err := Eval(ctx, db, retention, expr, now)
+		require.NoError(t, err, query)
+
+		return samples
+	}
+
+	require.Equal(t, []Sample{
+		{ID: "cpu.idle", Host: "node1", Value: 8},
+		{ID: "cpu.load", Host: "node1", Value: 1},
+		{ID: "cpu.load", Host: "node2", Value: 3},
+	}, eval(`cpu.*`))
+
+	require.Equal(t, []Sample{{ID: "cpu.load", Host: "node2", Value: 3}}, eval(`/cpu\.lo.d/{host!="node1"}`))
+
+	require.Equal(t, []Sample{{Value: 4}}, eval(`sum(cpu.load)`))
+	require.Equal(t, []Sample{{Value: 2}}, eval(`avg(cpu.load)`))
+	require.Equal(t, []Sample{{Value: 1}}, eval(`min(cpu.*)`))
+	require.Equal(t, []Sample{{Value: 8}}, eval(`max(cpu.*)`))
+	require.Equal(t, []Sample{{Value: 3}}, eval(`count(cpu.*)`))
+	require.Equal(t, []Sample{}, eval(`sum(disk.*)`))
+
+	require.Equal(t, []Sample{
+		{ID: "mem.used", Host: "node1", Value: 100},
+		{ID: "requests_total", Host: "node1", Value: 60},
+	}, eval(`topk(2, *)`))
+
+	require.Equal(t, []Sample{
+		{ID: "requests_total", Host: "node1", Value: 1},
+		{ID: "requests_total", Host: "node2", Value: 0.5},
+	}, eval(`rate(requests_total[1m])`))
+
+	require.Equal(t, []Sample{{Value: 1.5}}, eval(`sum(rate(*[1m]))`))
+
+	// Periods not rolled up yet are counted over rollups too
+	require.Equal(t, []Sample{{Value: 90.0 / 7200}}, eval(`sum(rate(*[2h]))`))
+	require.Equal(t, []Sample{{Value: 90.0 / (72 * 3600)}}, eval(`sum(rate(*[72h]))`))
+}
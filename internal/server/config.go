@@ -6,9 +6,14 @@ import (
 	"fmt"
 	"net"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/caarlos0/env/v6"
+
+	"github.com/horseinthesky/metricsagent/internal/graphite"
+	"github.com/horseinthesky/metricsagent/internal/server/storage"
 )
 
 // Server default cofig options.
@@ -18,6 +23,10 @@ const (
 	defaultStoreInterval  = 300 * time.Second
 	defaultStoreFile      = "/tmp/devops-metrics-db.json"
 	defaultDatabaseDriver = "pgx"
+
+	defaultRawRetention    = 6 * time.Hour
+	defaultMinuteRetention = 7 * 24 * time.Hour
+	defaultHourRetention   = 90 * 24 * time.Hour
 )
 
 // Duration is a custom type to help unmarshal time.Duration
@@ -50,28 +59,95 @@ func (d *Duration) UnmarshalJSON(b []byte) error {
 
 // ConfigFile is a container to store config file data
 type ConfigFile struct {
-	Address       string   `json:"address"`
-	Restore       bool     `json:"restore"`
-	TrustedSubnet string   `json:"trusted_subnet"`
-	StoreInterval Duration `json:"store_interval"`
-	StoreFile     string   `json:"store_file"`
-	CryptoKey     string   `json:"crypto_key"`
-	DatabaseDSN   string   `json:"database_dsn"`
+	Address           string            `json:"address"`
+	Restore           bool              `json:"restore"`
+	TrustedSubnet     string            `json:"trusted_subnet"`
+	StoreInterval     Duration          `json:"store_interval"`
+	StoreFile         string            `json:"store_file"`
+	CryptoKey         string            `json:"crypto_key"`
+	DatabaseDSN       string            `json:"database_dsn"`
+	HistogramBuckets  []float64         `json:"histogram_buckets"`
+	RawRetention      Duration          `json:"raw_retention"`
+	MinuteRetention   Duration          `json:"minute_retention"`
+	HourRetention     Duration          `json:"hour_retention"`
+	RemoteWriteTypes  map[string]string `json:"remote_write_types"`
+	InfluxTypes       map[string]string `json:"influx_types"`
+	GraphiteAddress   string            `json:"graphite_address"`
+	GraphiteTemplates []string          `json:"graphite_templates"`
 }
 
 // Server Agent Config description.
 type Config struct {
-	ConfigPath     string        `env:"CONFIG"`
-	Address        string        `env:"ADDRESS"`
-	Restore        bool          `env:"RESTORE"`
-	TrustedSubnet  string        `env:"TRUSTED_SUBNET"`
-	StoreInterval  time.Duration `env:"STORE_INTERVAL"`
-	StoreFile      string        `env:"STORE_FILE"`
-	Key            string        `env:"KEY"`
-	CryptoKey      string        `env:"CRYPTO_KEY"`
-	DatabaseDSN    string        `env:"DATABASE_DSN"`
-	DatabaseDriver string        `env:"DATABASE_DRIVER"`
-	GRPC           bool
+	ConfigPath        string            `env:"CONFIG"`
+	Address           string            `env:"ADDRESS"`
+	Restore           bool              `env:"RESTORE"`
+	TrustedSubnet     string            `env:"TRUSTED_SUBNET"`
+	StoreInterval     time.Duration     `env:"STORE_INTERVAL"`
+	StoreFile         string            `env:"STORE_FILE"`
+	Key               string            `env:"KEY"`
+	CryptoKey         string            `env:"CRYPTO_KEY"`
+	DatabaseDSN       string            `env:"DATABASE_DSN"`
+	DatabaseDriver    string            `env:"DATABASE_DRIVER"`
+	HistogramBuckets  []float64         `env:"HISTOGRAM_BUCKETS" envSeparator:","`
+	RawRetention      time.Duration     `env:"RAW_RETENTION"`
+	MinuteRetention   time.Duration     `env:"MINUTE_RETENTION"`
+	HourRetention     time.Duration     `env:"HOUR_RETENTION"`
+	RemoteWriteTypes  map[string]string `env:"REMOTE_WRITE_TYPES"`
+	InfluxTypes       map[string]string `env:"INFLUX_TYPES"`
+	GraphiteAddress   string            `env:"GRAPHITE_ADDRESS"`
+	GraphiteTemplates []string          `env:"GRAPHITE_TEMPLATES" envSeparator:","`
+	GRPC              bool
+}
+
+// Retention returns series history retention.
+func (c Config) Retention() storage.Retention {
+	return storage.Retention{
+		Raw:    c.RawRetention,
+		Minute: c.MinuteRetention,
+		Hour:   c.HourRetention,
+	}
+}
+
+// parseBuckets parses comma separated histogram bucket bounds.
+func parseBuckets(s string) ([]float64, error) {
+	bounds := []float64{}
+	for _, field := range strings.Split(s, ",") {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, err
+		}
+
+		bounds = append(bounds, bound)
+	}
+
+	return bounds, nil
+}
+
+// parseTypes parses comma separated "name:type" metric types.
+func parseTypes(s string) (map[string]string, error) {
+	types := map[string]string{}
+	for _, field := range strings.Split(s, ",") {
+		name, mtype, ok := strings.Cut(strings.TrimSpace(field), ":")
+		if !ok {
+			return nil, fmt.Errorf(`invalid metric type "%s", use "name:type"`, field)
+		}
+
+		types[name] = mtype
+	}
+
+	return types, nil
+}
+
+// validateTypes checks receiver metrics are mapped
+// to types receiver samples can be stored as.
+func validateTypes(receiver string, types map[string]string) error {
+	for name, mtype := range types {
+		if mtype != storage.Gauge.String() && mtype != storage.Counter.String() {
+			return fmt.Errorf(`unsupported %s type "%s" of "%s", use "gauge" or "counter"`, receiver, mtype, name)
+		}
+	}
+
+	return nil
 }
 
 // ParseConfig parses the configuration options.
@@ -90,6 +166,26 @@ func ParseConfig() (Config, error) {
 	flag.StringVar(&cfg.CryptoKey, "crypto-key", "", "Crypto private key path")
 	flag.StringVar(&cfg.DatabaseDSN, "d", "", "Database address")
 	flag.StringVar(&cfg.DatabaseDriver, "s", defaultDatabaseDriver, "Database driver (sqlite3/pgx)")
+	flag.Func("histogram-buckets", "Comma separated histogram bucket upper bounds", func(s string) (err error) {
+		cfg.HistogramBuckets, err = parseBuckets(s)
+		return err
+	})
+	flag.DurationVar(&cfg.RawRetention, "raw-retention", defaultRawRetention, "Raw samples retention")
+	flag.DurationVar(&cfg.MinuteRetention, "minute-retention", defaultMinuteRetention, "Minute rollups retention")
+	flag.DurationVar(&cfg.HourRetention, "hour-retention", defaultHourRetention, "Hour rollups retention")
+	flag.Func("remote-write-types", `Comma separated "name:type" remote write metric types`, func(s string) (err error) {
+		cfg.RemoteWriteTypes, err = parseTypes(s)
+		return err
+	})
+	flag.Func("influx-types", `Comma separated "name:type" line protocol integer field types`, func(s string) (err error) {
+		cfg.InfluxTypes, err = parseTypes(s)
+		return err
+	})
+	flag.StringVar(&cfg.GraphiteAddress, "graphite-address", "", "Socket to listen on for Graphite plaintext protocol over TCP and UDP")
+	flag.Func("graphite-template", `Graphite metric path template "[filter ]pattern", may be repeated`, func(s string) error {
+		cfg.GraphiteTemplates = append(cfg.GraphiteTemplates, s)
+		return nil
+	})
 	flag.BoolVar(&cfg.GRPC, "g", false, "Replace HTTP with gRPC")
 	flag.Parse()
 
@@ -113,6 +209,26 @@ func ParseConfig() (Config, error) {
 		return Config{}, fmt.Errorf(`unsupported database driver: "%s", use "sqlite3" or "pgx"`, cfg.DatabaseDriver)
 	}
 
+	if err := storage.ValidateBuckets(cfg.HistogramBuckets); err != nil {
+		return Config{}, err
+	}
+
+	if err := cfg.Retention().Validate(); err != nil {
+		return Config{}, err
+	}
+
+	if err := validateTypes("remote write", cfg.RemoteWriteTypes); err != nil {
+		return Config{}, err
+	}
+
+	if err := validateTypes("line protocol", cfg.InfluxTypes); err != nil {
+		return Config{}, err
+	}
+
+	if _, err := graphite.ParseTemplates(cfg.GraphiteTemplates); err != nil {
+		return Config{}, err
+	}
+
 	return cfg, nil
 }
 
@@ -160,5 +276,37 @@ func loadConfigFile(cfg *Config) error {
 		cfg.DatabaseDSN = cfgFromFile.DatabaseDSN
 	}
 
+	if len(cfg.HistogramBuckets) == 0 && len(cfgFromFile.HistogramBuckets) != 0 {
+		cfg.HistogramBuckets = cfgFromFile.HistogramBuckets
+	}
+
+	if cfg.RawRetention == defaultRawRetention && cfgFromFile.RawRetention.Duration != 0 {
+		cfg.RawRetention = cfgFromFile.RawRetention.Duration
+	}
+
+	if cfg.MinuteRetention == defaultMinuteRetention && cfgFromFile.MinuteRetention.Duration != 0 {
+		cfg.MinuteRetention = cfgFromFile.MinuteRetention.Duration
+	}
+
+	if cfg.HourRetention == defaultHourRetention && cfgFromFile.HourRetention.Duration != 0 {
+		cfg.HourRetention = cfgFromFile.HourRetention.Duration
+	}
+
+	if len(cfg.RemoteWriteTypes) == 0 && len(cfgFromFile.RemoteWriteTypes) != 0 {
+		cfg.RemoteWriteTypes = cfgFromFile.RemoteWriteTypes
+	}
+
+	if len(cfg.InfluxTypes) == 0 && len(cfgFromFile.InfluxTypes) != 0 {
+		cfg.InfluxTypes = cfgFromFile.InfluxTypes
+	}
+
+	if cfg.GraphiteAddress == "" && cfgFromFile.GraphiteAddress != "" {
+		cfg.GraphiteAddress = cfgFromFile.GraphiteAddress
+	}
+
+	if len(cfg.GraphiteTemplates) == 0 && len(cfgFromFile.GraphiteTemplates) != 0 {
+		cfg.GraphiteTemplates = cfgFromFile.GraphiteTemplates
+	}
+
 	return nil
 }
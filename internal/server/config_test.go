@@ -23,4 +23,10 @@ func TestParseConfig(t *testing.T) {
 	assert.Equal(t, testAddress, config.Address)
 	assert.Equal(t, 100*time.Second, config.StoreInterval)
 	assert.Equal(t, "", config.DatabaseDSN)
+	assert.Equal(t, []float64{0.1, 1, 10}, config.HistogramBuckets)
+	assert.Equal(t, time.Hour, config.RawRetention)
+	assert.Equal(t, defaultMinuteRetention, config.MinuteRetention)
+	assert.Equal(t, map[string]string{"http_requests_in_flight": "gauge"}, config.RemoteWriteTypes)
+	assert.Equal(t, map[string]string{"mem_total": "gauge"}, config.InfluxTypes)
+	assert.Equal(t, []string{"servers.* .host.id*"}, config.GraphiteTemplates)
 }
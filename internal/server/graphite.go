@@ -0,0 +1,240 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/horseinthesky/metricsagent/internal/graphite"
+	"github.com/horseinthesky/metricsagent/internal/server/storage"
+)
+
+// maxGraphiteDatagramSize is the largest Graphite UDP datagram accepted.
+const maxGraphiteDatagramSize = 64 * 1024
+
+// Graphite accept retry delays.
+// Delay doubles on every failed accept in a row, as net/http server does.
+const (
+	minGraphiteAcceptDelay = 5 * time.Millisecond
+	maxGraphiteAcceptDelay = time.Second
+)
+
+// startGraphiteListener handles Graphite plaintext protocol
+// over both TCP and UDP on Graphite address.
+// Metric values are stored as gauges, timestamps are not kept.
+// Clients outside of trusted subnet are ignored.
+func (s *GenericServer) startGraphiteListener(ctx context.Context) {
+	templates, err := graphite.ParseTemplates(s.Config.GraphiteTemplates)
+	if err != nil {
+		log.Printf("failed to parse graphite templates: %s", err)
+		return
+	}
+
+	listener, err := net.Listen("tcp", s.Config.GraphiteAddress)
+	if err != nil {
+		log.Fatalf("failed to listen for graphite over tcp: %s", err)
+	}
+
+	packetConn, err := net.ListenPacket("udp", s.Config.GraphiteAddress)
+	if err != nil {
+		log.Fatalf("failed to listen for graphite over udp: %s", err)
+	}
+
+	log.Printf("graphite listener started on %s", s.Config.GraphiteAddress)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+
+	go func() {
+		defer wg.Done()
+		s.serveGraphiteTCP(ctx, listener, templates)
+	}()
+
+	go func() {
+		defer wg.Done()
+		s.serveGraphiteUDP(ctx, packetConn, templates)
+	}()
+
+	wg.Wait()
+	log.Println("graphite listener stopped")
+}
+
+// serveGraphiteTCP accepts Graphite connections until ctx is done.
+func (s *GenericServer) serveGraphiteTCP(ctx context.Context, listener net.Listener, templates graphite.Templates) {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	var delay time.Duration
+
+	for {
+		conn, err := listener.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			// Errors like running out of file descriptors
+			// are retried after a delay not to spin
+			delay *= 2
+			if delay == 0 {
+				delay = minGraphiteAcceptDelay
+			}
+			if delay > maxGraphiteAcceptDelay {
+				delay = maxGraphiteAcceptDelay
+			}
+
+			log.Printf("failed to accept graphite connection: %s, retrying in %s", err, delay)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+
+			continue
+		}
+		delay = 0
+
+		if !s.Trusts(addrIP(conn.RemoteAddr())) {
+			log.Printf("graphite connection from %s is forbidden", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serveGraphiteConn(ctx, conn, templates)
+		}()
+	}
+}
+
+// serveGraphiteConn stores metrics sent over a Graphite connection.
+// Lines received at once are stored in bulk.
+func (s *GenericServer) serveGraphiteConn(ctx context.Context, conn net.Conn, templates graphite.Templates) {
+	defer conn.Close()
+
+	done := make(chan struct{})
+	defer close(done)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	reader := bufio.NewReader(conn)
+	metrics := []storage.Metric{}
+
+	for {
+		line, err := reader.ReadString('\n')
+		if metric, ok := graphiteMetric(line, templates); ok {
+			metrics = append(metrics, metric)
+		}
+
+		// Lines already received are stored before waiting for more
+		if err != nil || reader.Buffered() == 0 {
+			s.saveGraphiteMetrics(metrics)
+			metrics = []storage.Metric{}
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// serveGraphiteUDP stores metrics sent in Graphite datagrams until ctx is done.
+// Datagram may carry multiple lines.
+func (s *GenericServer) serveGraphiteUDP(ctx context.Context, conn net.PacketConn, templates graphite.Templates) {
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	buffer := make([]byte, maxGraphiteDatagramSize)
+
+	for {
+		n, addr, err := conn.ReadFrom(buffer)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Printf("failed to read graphite datagram: %s", err)
+			continue
+		}
+
+		if !s.Trusts(addrIP(addr)) {
+			log.Printf("graphite datagram from %s is forbidden", addr)
+			continue
+		}
+
+		metrics := []storage.Metric{}
+		for _, line := range strings.Split(string(buffer[:n]), "\n") {
+			if metric, ok := graphiteMetric(line, templates); ok {
+				metrics = append(metrics, metric)
+			}
+		}
+
+		s.saveGraphiteMetrics(metrics)
+	}
+}
+
+// graphiteMetric returns a gauge Graphite line is stored as.
+// Empty and invalid lines have none.
+func graphiteMetric(line string, templates graphite.Templates) (storage.Metric, bool) {
+	if strings.TrimSpace(line) == "" {
+		return storage.Metric{}, false
+	}
+
+	parsed, err := graphite.Parse(line)
+	if err != nil {
+		log.Printf("invalid graphite line: %s", err)
+		return storage.Metric{}, false
+	}
+
+	series := templates.Series(parsed.Path)
+	value := parsed.Value
+
+	return storage.Metric{
+		ID:     series.ID,
+		Host:   series.Host,
+		Labels: series.Labels,
+		MType:  storage.Gauge.String(),
+		Value:  &value,
+	}, true
+}
+
+// saveGraphiteMetrics stores Graphite metrics if any.
+func (s *GenericServer) saveGraphiteMetrics(metrics []storage.Metric) {
+	if len(metrics) == 0 {
+		return
+	}
+
+	if err := s.SaveMetricsBulk(metrics); err != nil {
+		log.Printf("failed to save graphite metrics: %s", err)
+	}
+}
+
+// addrIP returns network address IP.
+func addrIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	}
+
+	return nil
+}
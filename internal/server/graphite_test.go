@@ -0,0 +1,124 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/horseinthesky/metricsagent/internal/graphite"
+	"github.com/horseinthesky/metricsagent/internal/server/storage"
+)
+
+func TestGraphiteListener(t *testing.T) {
+	graphiteServer, err := NewGenericServer(Config{})
+	require.NoError(t, err)
+
+	templates, err := graphite.ParseTemplates([]string{"servers.* .host.id*"})
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	packetConn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		go graphiteServer.serveGraphiteUDP(ctx, packetConn, templates)
+		graphiteServer.serveGraphiteTCP(ctx, listener, templates)
+	}()
+
+	tcpConn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+
+	_, err = fmt.Fprint(tcpConn, "servers.node1.cpu.load 0.75 1465839830\ninvalid line\nbackup.duration 12 -1\n")
+	require.NoError(t, err)
+
+	udpConn, err := net.Dial("udp", packetConn.LocalAddr().String())
+	require.NoError(t, err)
+
+	_, err = fmt.Fprint(udpConn, "servers.node2.mem.used 42 1465839830\n")
+	require.NoError(t, err)
+
+	stored := func(key storage.Key, value float64) func() bool {
+		return func() bool {
+			metric, err := graphiteServer.DB.Get(ctx, key)
+			return err == nil && *metric.Value == value
+		}
+	}
+
+	require.Eventually(t, stored(storage.Key{Host: "node1", ID: "cpu.load"}, 0.75), time.Second, 10*time.Millisecond)
+	require.Eventually(t, stored(storage.Key{ID: "backup.duration"}, 12), time.Second, 10*time.Millisecond)
+	require.Eventually(t, stored(storage.Key{Host: "node2", ID: "mem.used"}, 42), time.Second, 10*time.Millisecond)
+
+	// Open connections are closed on shutdown
+	cancel()
+	<-done
+
+	_, err = tcpConn.Read(make([]byte, 1))
+	require.Error(t, err)
+
+	tcpConn.Close()
+	udpConn.Close()
+}
+
+// failingListener fails to accept a number of times and is closed then.
+type failingListener struct {
+	net.Listener
+	failures int
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	if l.failures == 0 {
+		return nil, net.ErrClosed
+	}
+	l.failures--
+
+	return nil, errors.New("too many open files")
+}
+
+func TestGraphiteAcceptBackoff(t *testing.T) {
+	graphiteServer, err := NewGenericServer(Config{})
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	// Failed accepts are retried after 5, 10, 20 and 40ms
+	start := time.Now()
+	graphiteServer.serveGraphiteTCP(context.Background(), &failingListener{Listener: listener, failures: 4}, nil)
+	require.GreaterOrEqual(t, time.Since(start), 75*time.Millisecond)
+}
+
+func TestGraphiteTrustedSubnet(t *testing.T) {
+	graphiteServer, err := NewGenericServer(Config{TrustedSubnet: "10.10.10.0/24"})
+	require.NoError(t, err)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go graphiteServer.serveGraphiteTCP(ctx, listener, nil)
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// Untrusted connection is closed right away
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	require.Error(t, err)
+	require.False(t, errors.Is(err, os.ErrDeadlineExceeded))
+}
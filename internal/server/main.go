@@ -17,6 +17,7 @@ type GenericServer struct {
 	CryptoKey *rsa.PrivateKey
 	DB        storage.Storage
 	backuper  *Backuper
+	totals    *Totals
 	WorkGroup sync.WaitGroup
 }
 
@@ -33,6 +34,22 @@ func NewGenericServer(cfg Config) (*GenericServer, error) {
 		}
 	}
 
+	if len(cfg.HistogramBuckets) == 0 {
+		cfg.HistogramBuckets = storage.DefaultBuckets
+	}
+
+	if cfg.RawRetention == 0 {
+		cfg.RawRetention = defaultRawRetention
+	}
+
+	if cfg.MinuteRetention == 0 {
+		cfg.MinuteRetention = defaultMinuteRetention
+	}
+
+	if cfg.HourRetention == 0 {
+		cfg.HourRetention = defaultHourRetention
+	}
+
 	var db storage.Storage
 	if cfg.DatabaseDSN != "" {
 		db = storage.NewDBStorage(cfg.DatabaseDriver, cfg.DatabaseDSN)
@@ -42,7 +59,7 @@ func NewGenericServer(cfg Config) (*GenericServer, error) {
 
 	backuper := NewBackuper(cfg.StoreFile)
 
-	server := &GenericServer{cfg, privKey, db, backuper, sync.WaitGroup{}}
+	server := &GenericServer{cfg, privKey, db, backuper, NewTotals(), sync.WaitGroup{}}
 
 	return server, nil
 }
@@ -68,6 +85,46 @@ func (s *GenericServer) Bootstrap(ctx context.Context) {
 			}()
 		}
 	}
+
+	// Roll up and expire series history periodically
+	s.WorkGroup.Add(1)
+	go func() {
+		defer s.WorkGroup.Done()
+		s.startPeriodicCompaction(ctx)
+	}()
+
+	// Receive Graphite plaintext protocol
+	if s.Config.GraphiteAddress != "" {
+		s.WorkGroup.Add(1)
+		go func() {
+			defer s.WorkGroup.Done()
+			s.startGraphiteListener(ctx)
+		}()
+	}
+}
+
+// compactionInterval is how often series history is compacted.
+const compactionInterval = time.Minute
+
+// startPeriodicCompaction handles Server periodic series history
+// rollups and retention.
+func (s *GenericServer) startPeriodicCompaction(ctx context.Context) {
+	log.Println("periodic history compaction started")
+
+	ticker := time.NewTicker(compactionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.DB.Compact(ctx, time.Now(), s.Config.Retention()); err != nil {
+				log.Printf("failed to compact history: %s", err)
+			}
+		case <-ctx.Done():
+			log.Println("history compaction canceled")
+			return
+		}
+	}
 }
 
 // startPeriodicMetricsDump handles Server periodic metrics backup to file.
@@ -120,3 +177,25 @@ func (s *GenericServer) SaveMetricsBulk(metrics []storage.Metric) error {
 
 	return err
 }
+
+// SaveMetricsBatch handles idempotent bulk metrics saving.
+// Batch is identified by its source and ID. A retried batch is not
+// applied twice and storage.ErrDuplicateBatch is returned instead.
+// Source is the client address request came from, not metrics host,
+// since metrics are not authenticated and batches may mix hosts.
+// Batches without ID are saved with SaveMetricsBulk.
+func (s *GenericServer) SaveMetricsBatch(source, batchID string, metrics []storage.Metric) error {
+	if batchID == "" {
+		return s.SaveMetricsBulk(metrics)
+	}
+
+	err := s.DB.SetBatch(source, batchID, metrics)
+
+	if s.Config.DatabaseDSN == "" {
+		if s.Config.StoreFile != "" && s.Config.StoreInterval == time.Duration(0) {
+			s.dump()
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"fmt"
+	"math"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+
+	"github.com/horseinthesky/metricsagent/internal/labels"
+	"github.com/horseinthesky/metricsagent/internal/server/storage"
+)
+
+// otlpHostAttribute is an OTLP resource attribute carrying metric host.
+const otlpHostAttribute = "host.name"
+
+// OTLPMetrics returns metrics OTLP export request data points are stored as.
+//
+// Resource host.name attribute is a metric host, other resource
+// and data point attributes are metric labels with sanitized names.
+// Gauges are stored as gauges, monotonic sums as counters,
+// non-monotonic cumulative sums as gauges and histograms as histograms.
+// Cumulative sums and histograms are stored as their increments
+// converted with totals update.
+// Other data is not supported and skipped.
+func (s *GenericServer) OTLPMetrics(totals *TotalsUpdate, req *colmetricspb.ExportMetricsServiceRequest) ([]storage.Metric, error) {
+	metrics := []storage.Metric{}
+
+	for _, resourceMetrics := range req.ResourceMetrics {
+		resource := otlpResource(resourceMetrics.Resource)
+
+		for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+			for _, otlpMetric := range scopeMetrics.Metrics {
+				converted, err := totals.otlpMetric(resource, otlpMetric)
+				if err != nil {
+					return nil, fmt.Errorf("invalid metric %s: %w", otlpMetric.Name, err)
+				}
+
+				metrics = append(metrics, converted...)
+			}
+		}
+	}
+
+	return metrics, nil
+}
+
+// otlpResource returns a metric identified by OTLP resource attributes.
+func otlpResource(resource *resourcepb.Resource) storage.Metric {
+	metric := storage.Metric{}
+
+	for _, attribute := range resource.GetAttributes() {
+		if attribute.Key == otlpHostAttribute {
+			metric.Host = otlpAttributeValue(attribute.Value)
+			continue
+		}
+
+		if metric.Labels == nil {
+			metric.Labels = map[string]string{}
+		}
+		metric.Labels[labels.Sanitize(attribute.Key)] = otlpAttributeValue(attribute.Value)
+	}
+
+	return metric
+}
+
+// otlpPoint returns a resource metric of OTLP data point.
+// Data point attributes override resource ones.
+func otlpPoint(resource storage.Metric, name, mtype string, attributes []*commonpb.KeyValue) storage.Metric {
+	metric := storage.Metric{ID: name, Host: resource.Host, MType: mtype}
+
+	if len(resource.Labels)+len(attributes) == 0 {
+		return metric
+	}
+
+	metric.Labels = make(map[string]string, len(resource.Labels)+len(attributes))
+	for name, value := range resource.Labels {
+		metric.Labels[name] = value
+	}
+
+	for _, attribute := range attributes {
+		metric.Labels[labels.Sanitize(attribute.Key)] = otlpAttributeValue(attribute.Value)
+	}
+
+	return metric
+}
+
+// otlpAttributeValue returns OTLP attribute value text form.
+func otlpAttributeValue(value *commonpb.AnyValue) string {
+	switch v := value.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return v.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return fmt.Sprint(v.BoolValue)
+	case *commonpb.AnyValue_IntValue:
+		return fmt.Sprint(v.IntValue)
+	case *commonpb.AnyValue_DoubleValue:
+		return fmt.Sprint(v.DoubleValue)
+	case *commonpb.AnyValue_BytesValue:
+		return fmt.Sprintf("%x", v.BytesValue)
+	}
+
+	return ""
+}
+
+// otlpNoValue checks if OTLP data point has no recorded value.
+func otlpNoValue(flags uint32) bool {
+	return flags&uint32(metricspb.DataPointFlags_FLAG_NO_RECORDED_VALUE) != 0
+}
+
+// otlpNumber returns OTLP number data point value.
+func otlpNumber(point *metricspb.NumberDataPoint) float64 {
+	if v, ok := point.Value.(*metricspb.NumberDataPoint_AsInt); ok {
+		return float64(v.AsInt)
+	}
+
+	return point.GetAsDouble()
+}
+
+// otlpMetric returns metrics OTLP metric data points are stored as.
+func (u *TotalsUpdate) otlpMetric(resource storage.Metric, otlpMetric *metricspb.Metric) ([]storage.Metric, error) {
+	metrics := []storage.Metric{}
+
+	switch data := otlpMetric.Data.(type) {
+	case *metricspb.Metric_Gauge:
+		for _, point := range data.Gauge.DataPoints {
+			if otlpNoValue(point.Flags) {
+				continue
+			}
+
+			metric := otlpPoint(resource, otlpMetric.Name, storage.Gauge.String(), point.Attributes)
+			value := otlpNumber(point)
+			metric.Value = &value
+
+			metrics = append(metrics, metric)
+		}
+	case *metricspb.Metric_Sum:
+		cumulative := data.Sum.AggregationTemporality != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+
+		for _, point := range data.Sum.DataPoints {
+			if otlpNoValue(point.Flags) {
+				continue
+			}
+
+			var metric storage.Metric
+			switch {
+			case data.Sum.IsMonotonic:
+				metric = otlpPoint(resource, otlpMetric.Name, storage.Counter.String(), point.Attributes)
+
+				delta := int64(math.Round(otlpNumber(point)))
+				if cumulative {
+					var err error
+
+					delta, err = u.Delta(metric.Key(), otlpNumber(point))
+					if err != nil {
+						return nil, err
+					}
+				}
+				metric.Delta = &delta
+			case cumulative:
+				metric = otlpPoint(resource, otlpMetric.Name, storage.Gauge.String(), point.Attributes)
+
+				value := otlpNumber(point)
+				metric.Value = &value
+			default:
+				// Non-monotonic delta sums have no stored counterpart
+				continue
+			}
+
+			metrics = append(metrics, metric)
+		}
+	case *metricspb.Metric_Histogram:
+		cumulative := data.Histogram.AggregationTemporality != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+
+		for _, point := range data.Histogram.DataPoints {
+			if otlpNoValue(point.Flags) {
+				continue
+			}
+
+			histogram := &storage.HistogramData{
+				Bounds: point.ExplicitBounds,
+				Counts: point.BucketCounts,
+				Sum:    point.GetSum(),
+				Count:  point.Count,
+			}
+
+			// Histogram without buckets has a single one
+			if len(histogram.Bounds) == 0 && len(histogram.Counts) == 0 {
+				histogram.Counts = []uint64{point.Count}
+			}
+
+			if err := histogram.Validate(); err != nil {
+				return nil, err
+			}
+
+			metric := otlpPoint(resource, otlpMetric.Name, storage.Histogram.String(), point.Attributes)
+			if cumulative {
+				var err error
+
+				histogram, err = u.HistogramDelta(metric.Key(), histogram)
+				if err != nil {
+					return nil, err
+				}
+			}
+			metric.Histogram = histogram
+
+			metrics = append(metrics, metric)
+		}
+	}
+
+	for _, metric := range metrics {
+		if err := metric.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return metrics, nil
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+
+	"github.com/horseinthesky/metricsagent/internal/server/storage"
+)
+
+func stringAttribute(key, value string) *commonpb.KeyValue {
+	return &commonpb.KeyValue{Key: key, Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: value}}}
+}
+
+func otlpRequest(metrics ...*metricspb.Metric) *colmetricspb.ExportMetricsServiceRequest {
+	return &colmetricspb.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{
+				stringAttribute("host.name", "node1"),
+				stringAttribute("service.name", "api"),
+			}},
+			ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: metrics}},
+		}},
+	}
+}
+
+func otlpSum(name string, temporality metricspb.AggregationTemporality, monotonic bool, value int64) *metricspb.Metric {
+	return &metricspb.Metric{
+		Name: name,
+		Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			AggregationTemporality: temporality,
+			IsMonotonic:            monotonic,
+			DataPoints: []*metricspb.NumberDataPoint{{
+				Attributes: []*commonpb.KeyValue{stringAttribute("http.method", "GET")},
+				Value:      &metricspb.NumberDataPoint_AsInt{AsInt: value},
+			}},
+		}},
+	}
+}
+
+func otlpHistogram(name string, counts []uint64, sum float64) *metricspb.Metric {
+	var count uint64
+	for _, c := range counts {
+		count += c
+	}
+
+	return &metricspb.Metric{
+		Name: name,
+		Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			DataPoints: []*metricspb.HistogramDataPoint{{
+				ExplicitBounds: []float64{1},
+				BucketCounts:   counts,
+				Sum:            &sum,
+				Count:          count,
+			}},
+		}},
+	}
+}
+
+func TestOTLPMetrics(t *testing.T) {
+	otlpServer, err := NewGenericServer(Config{})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+
+	// convert converts a request with a committed totals update
+	convert := func(req *colmetricspb.ExportMetricsServiceRequest) ([]storage.Metric, error) {
+		totals := otlpServer.BeginTotals(ctx)
+		defer totals.Commit()
+
+		return otlpServer.OTLPMetrics(totals, req)
+	}
+
+	cumulative := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE
+	delta := metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA
+
+	gauge := &metricspb.Metric{
+		Name: "system.memory.usage",
+		Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{
+			{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 1.5}},
+			{Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: 2}, Flags: uint32(metricspb.DataPointFlags_FLAG_NO_RECORDED_VALUE)},
+		}}},
+	}
+
+	metrics, err := convert(otlpRequest(
+		gauge,
+		otlpSum("http.requests", cumulative, true, 5),
+		otlpSum("http.errors", delta, true, 2),
+		otlpSum("queue.size", cumulative, false, 7),
+		otlpSum("queue.changes", delta, false, 1),
+		otlpHistogram("http.duration", []uint64{1, 1}, 2.5),
+	))
+	require.NoError(t, err)
+	require.Len(t, metrics, 5)
+
+	labels := map[string]string{"service_name": "api", "http_method": "GET"}
+
+	require.Equal(t, "system.memory.usage", metrics[0].ID)
+	require.Equal(t, "node1", metrics[0].Host)
+	require.Equal(t, map[string]string{"service_name": "api"}, metrics[0].Labels)
+	require.Equal(t, 1.5, *metrics[0].Value)
+
+	require.Equal(t, "counter", metrics[1].MType)
+	require.Equal(t, labels, metrics[1].Labels)
+	require.Equal(t, int64(5), *metrics[1].Delta)
+
+	require.Equal(t, "counter", metrics[2].MType)
+	require.Equal(t, int64(2), *metrics[2].Delta)
+
+	require.Equal(t, "gauge", metrics[3].MType)
+	require.Equal(t, 7.0, *metrics[3].Value)
+
+	require.Equal(t, "histogram", metrics[4].MType)
+	require.Equal(t, uint64(2), metrics[4].Histogram.Count)
+
+	require.NoError(t, otlpServer.SaveMetricsBulk(metrics))
+
+	// Cumulative data points are stored as increments
+	metrics, err = convert(otlpRequest(
+		otlpSum("http.requests", cumulative, true, 8),
+		otlpHistogram("http.duration", []uint64{1, 3}, 6.5),
+	))
+	require.NoError(t, err)
+	require.Equal(t, int64(3), *metrics[0].Delta)
+	require.Equal(t, &storage.HistogramData{Bounds: []float64{1}, Counts: []uint64{0, 2}, Sum: 4, Count: 2}, metrics[1].Histogram)
+
+	// Bucket counts must add up to count
+	invalid := otlpHistogram("http.duration", []uint64{1, 3}, 6.5)
+	invalid.GetHistogram().DataPoints[0].Count = 1
+
+	totals := otlpServer.BeginTotals(ctx)
+	_, err = otlpServer.OTLPMetrics(totals, otlpRequest(
+		otlpSum("http.requests", cumulative, true, 10),
+		invalid,
+	))
+	require.Error(t, err)
+	totals.Rollback()
+
+	// Rejected export is retried with the same increments
+	metrics, err = convert(otlpRequest(otlpSum("http.requests", cumulative, true, 10)))
+	require.NoError(t, err)
+	require.Equal(t, int64(2), *metrics[0].Delta)
+}
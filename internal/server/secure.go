@@ -4,12 +4,16 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"fmt"
+	"net"
+	"strings"
 
+	"github.com/horseinthesky/metricsagent/internal/labels"
 	"github.com/horseinthesky/metricsagent/internal/server/storage"
 )
 
 // generateHash adds hash to metric.
 // Only used if hash key is provided.
+// Host and canonical label set are appended to hashed data if set.
 func GenerateHash(metric storage.Metric, hashKey string) []byte {
 	hash := hmac.New(sha256.New, []byte(hashKey))
 
@@ -20,9 +24,52 @@ func GenerateHash(metric storage.Metric, hashKey string) []byte {
 		data = fmt.Sprintf("%s:gauge:%f", metric.ID, *metric.Value)
 	case storage.Counter.String():
 		data = fmt.Sprintf("%s:counter:%d", metric.ID, *metric.Delta)
+	case storage.Histogram.String():
+		data = histogramHashData(metric.ID, metric.Histogram)
+	case storage.Summary.String():
+		data = fmt.Sprintf("%s:summary:%s", metric.ID, metric.Summary)
+	}
+
+	if metric.Host != "" {
+		data += ":" + metric.Host
+	}
+
+	if len(metric.Labels) != 0 {
+		data += "{" + labels.Format(metric.Labels) + "}"
 	}
 
 	hash.Write([]byte(data))
 
 	return hash.Sum(nil)
 }
+
+// histogramHashData returns histogram data to hash.
+// Buckets are listed as "bound=count" pairs with the overflow bucket last.
+func histogramHashData(id string, histogram *storage.HistogramData) string {
+	buckets := make([]string, 0, len(histogram.Counts))
+	for i, count := range histogram.Counts {
+		bound := "+Inf"
+		if i < len(histogram.Bounds) {
+			bound = fmt.Sprintf("%f", histogram.Bounds[i])
+		}
+
+		buckets = append(buckets, fmt.Sprintf("%s=%d", bound, count))
+	}
+
+	return fmt.Sprintf("%s:histogram:%d:%f:%s", id, histogram.Count, histogram.Sum, strings.Join(buckets, ","))
+}
+
+// Trusts checks if a client IP belongs to the trusted subnet.
+// Any client is trusted if no trusted subnet is configured.
+func (s *GenericServer) Trusts(ip net.IP) bool {
+	if s.Config.TrustedSubnet == "" {
+		return true
+	}
+
+	_, trustedNet, err := net.ParseCIDR(s.Config.TrustedSubnet)
+	if err != nil {
+		return false
+	}
+
+	return trustedNet.Contains(ip)
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/hmac"
 	"encoding/hex"
 	"encoding/json"
+	"net"
 	"testing"
 
 	"github.com/horseinthesky/metricsagent/internal/server/storage"
@@ -44,4 +45,86 @@ func TestGeneratehash(t *testing.T) {
 	require.NoError(t, err)
 
 	require.True(t, hmac.Equal(localHash, remoteHash), "Local and remote hashes differ")
+
+	testHostCounter := `{
+		"id": "TestCounter",
+		"host": "testhost",
+		"type": "counter",
+		"delta": 15,
+		"hash": "bad5528d02f62b6baf778294d1ee1e3c5a5a56d03908e713197ece1c451541df"
+	}`
+
+	testHostCounterMetric := storage.Metric{}
+	err = json.Unmarshal([]byte(testHostCounter), &testHostCounterMetric)
+	require.NoError(t, err)
+
+	localHash = GenerateHash(testHostCounterMetric, "testkey")
+	remoteHash, err = hex.DecodeString(testHostCounterMetric.Hash)
+	require.NoError(t, err)
+
+	require.True(t, hmac.Equal(localHash, remoteHash), "Local and remote hashes differ")
+
+	testLabeledCounter := `{
+		"id": "TestCounter",
+		"host": "testhost",
+		"labels": {"env": "prod"},
+		"type": "counter",
+		"delta": 15,
+		"hash": "f6ac62916485106a33ca1b5300f06fd518c00ae2a964ef7b0a05800da474d1ab"
+	}`
+
+	testLabeledCounterMetric := storage.Metric{}
+	err = json.Unmarshal([]byte(testLabeledCounter), &testLabeledCounterMetric)
+	require.NoError(t, err)
+
+	localHash = GenerateHash(testLabeledCounterMetric, "testkey")
+	remoteHash, err = hex.DecodeString(testLabeledCounterMetric.Hash)
+	require.NoError(t, err)
+
+	require.True(t, hmac.Equal(localHash, remoteHash), "Local and remote hashes differ")
+
+	testHistogram := `{
+		"id": "TestHistogram",
+		"type": "histogram",
+		"histogram": {"bounds": [0.1, 1], "counts": [1, 1, 1], "sum": 1.6, "count": 3},
+		"hash": "f7d22b60e1d86686aa53d4a87b5a43c2d33b98b27717d2e462cbbd95eb1fc656"
+	}`
+
+	testHistogramMetric := storage.Metric{}
+	err = json.Unmarshal([]byte(testHistogram), &testHistogramMetric)
+	require.NoError(t, err)
+
+	localHash = GenerateHash(testHistogramMetric, "testkey")
+	remoteHash, err = hex.DecodeString(testHistogramMetric.Hash)
+	require.NoError(t, err)
+
+	require.True(t, hmac.Equal(localHash, remoteHash), "Local and remote hashes differ")
+
+	testSummary := `{
+		"id": "TestSummary",
+		"type": "summary",
+		"summary": {"relative_accuracy": 0.01, "bins": {"0": 1, "111": 1}, "zeros": 0, "count": 2, "sum": 4},
+		"hash": "d8daac0b28520061c5d71a6ffad8de0ffe2fecdb813aff89dd6dac362ab89f00"
+	}`
+
+	testSummaryMetric := storage.Metric{}
+	err = json.Unmarshal([]byte(testSummary), &testSummaryMetric)
+	require.NoError(t, err)
+
+	localHash = GenerateHash(testSummaryMetric, "testkey")
+	remoteHash, err = hex.DecodeString(testSummaryMetric.Hash)
+	require.NoError(t, err)
+
+	require.True(t, hmac.Equal(localHash, remoteHash), "Local and remote hashes differ")
+}
+
+func TestTrusts(t *testing.T) {
+	trustedServer, err := NewGenericServer(Config{TrustedSubnet: "10.10.10.0/24"})
+	require.NoError(t, err)
+
+	require.True(t, trustedServer.Trusts(net.ParseIP("10.10.10.10")))
+	require.False(t, trustedServer.Trusts(net.ParseIP("10.10.20.10")))
+	require.False(t, trustedServer.Trusts(nil))
+
+	require.True(t, testServer.Trusts(net.ParseIP("10.10.20.10")))
 }
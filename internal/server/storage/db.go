@@ -3,19 +3,73 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
+	"github.com/horseinthesky/metricsagent/internal/labels"
+	"github.com/horseinthesky/metricsagent/internal/sketch"
 	_ "github.com/jackc/pgx/v4/stdlib"
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
+// sqliteDriver is a sqlite driver with REGEXP operator
+// matching Go regex, as sqlite has no regex support of its own.
+const sqliteDriver = "sqlite3_regexp"
+
+func init() {
+	sql.Register(sqliteDriver, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			return conn.RegisterFunc("regexp", sqliteRegexp(), true)
+		},
+	})
+}
+
+// sqliteRegexp returns sqlite REGEXP function.
+// The last pattern is kept compiled as a query matches every row against the same one.
+func sqliteRegexp() func(pattern, s string) (bool, error) {
+	var (
+		mu       sync.Mutex
+		compiled *regexp.Regexp
+	)
+
+	return func(pattern, s string) (bool, error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if compiled == nil || compiled.String() != pattern {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				return false, err
+			}
+			compiled = re
+		}
+
+		return compiled.MatchString(s), nil
+	}
+}
+
 type DB struct {
 	db     *sql.DB
 	driver string
+	// writes are shared by storing transactions and exclusive to compaction
+	// so no sample is committed behind the rolled up periods watermark.
+	writes sync.RWMutex
 }
 
 func NewDBStorage(databaseDriver, databaseDSN string) *DB {
-	db, err := sql.Open(databaseDriver, databaseDSN)
+	driverName := databaseDriver
+	if databaseDriver == "sqlite3" {
+		driverName = sqliteDriver
+	}
+
+	db, err := sql.Open(driverName, databaseDSN)
 	if err != nil {
 		log.Printf("failed to prepare DB: %s", err)
 		return nil
@@ -27,18 +81,84 @@ func NewDBStorage(databaseDriver, databaseDSN string) *DB {
 	}
 }
 
+// metricsTable is a metrics table schema.
+// Series are identified by host, metric ID and canonical label set.
+const metricsTable = `
+	CREATE TABLE IF NOT EXISTS metrics (
+		host text NOT NULL DEFAULT '',
+		id text NOT NULL,
+		labels text NOT NULL DEFAULT '',
+		mtype text NOT NULL,
+		delta bigint,
+		value double precision,
+		histogram text,
+		summary text,
+		PRIMARY KEY (host, id, labels)
+	)
+`
+
+// metricsColumns are metrics table columns read by scanMetric.
+const metricsColumns = `host, id, labels, mtype, delta, value, histogram, summary`
+
+// addedColumns are columns added to tables by later versions.
+var addedColumns = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"metrics", "histogram", "text"},
+	{"metrics", "summary", "text"},
+	{"samples", "delta", "double precision NOT NULL DEFAULT 0"},
+}
+
 func (d *DB) Init(ctx context.Context) error {
-	schema := `
-		CREATE TABLE IF NOT EXISTS metrics (
-			id text PRIMARY KEY,
-			mtype text NOT NULL,
-			delta bigint,
-			value double precision
+	schema := []string{metricsTable, `
+		CREATE TABLE IF NOT EXISTS batches (
+			source text NOT NULL,
+			id text NOT NULL,
+			applied bigint NOT NULL,
+			PRIMARY KEY (source, id)
 		)
-	`
+	`, `
+		CREATE TABLE IF NOT EXISTS samples (
+			host text NOT NULL,
+			id text NOT NULL,
+			labels text NOT NULL,
+			ts bigint NOT NULL,
+			value double precision NOT NULL,
+			delta double precision NOT NULL DEFAULT 0
+		)
+	`, `
+		CREATE INDEX IF NOT EXISTS samples_series_ts ON samples (host, id, labels, ts)
+	`, `
+		CREATE INDEX IF NOT EXISTS samples_ts ON samples (ts)
+	`, `
+		CREATE TABLE IF NOT EXISTS rollups (
+			host text NOT NULL,
+			id text NOT NULL,
+			labels text NOT NULL,
+			resolution bigint NOT NULL,
+			ts bigint NOT NULL,
+			min double precision NOT NULL,
+			max double precision NOT NULL,
+			sum double precision NOT NULL,
+			count bigint NOT NULL,
+			last double precision NOT NULL,
+			delta double precision NOT NULL,
+			PRIMARY KEY (host, id, labels, resolution, ts)
+		)
+	`, `
+		CREATE INDEX IF NOT EXISTS rollups_resolution_ts ON rollups (resolution, ts)
+	`}
 
-	if _, err := d.db.ExecContext(ctx, schema); err != nil {
-		return err
+	for _, statement := range schema {
+		if _, err := d.db.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	if err := d.migrate(ctx); err != nil {
+		return fmt.Errorf("failed to migrate tables: %w", err)
 	}
 
 	initMsg := "database initialized: "
@@ -52,83 +172,279 @@ func (d *DB) Init(ctx context.Context) error {
 	return nil
 }
 
-func (d *DB) Check(ctx context.Context) error {
-	return d.db.PingContext(ctx)
-}
+// migrate upgrades tables created by older versions.
+func (d *DB) migrate(ctx context.Context) error {
+	if err := d.migrateSeriesIdentity(ctx); err != nil {
+		return err
+	}
 
-func (d *DB) Set(metric Metric) error {
-	var err error
-
-	switch metric.MType {
-	case Counter.String():
-		_, err = d.db.Exec(`
-			INSERT INTO metrics(id, mtype, delta) VALUES($1,$2,$3)
-			 ON CONFLICT (id) DO UPDATE
-			 SET mtype = $2, delta = metrics.delta + $3
-		`, metric.ID, metric.MType, metric.Delta)
-		if err != nil {
-			return err
+	for _, added := range addedColumns {
+		if _, err := d.db.ExecContext(ctx, `SELECT `+added.column+` FROM `+added.table+` LIMIT 1`); err == nil {
+			continue
 		}
-	case Gauge.String():
-		_, err = d.db.Exec(`
-			INSERT INTO metrics(id, mtype, value) VALUES($1,$2,$3)
-			 ON CONFLICT (id) DO UPDATE
-			 SET mtype = $2, value = $3
-		`, metric.ID, metric.MType, metric.Value)
+
+		_, err := d.db.ExecContext(ctx, `ALTER TABLE `+added.table+` ADD COLUMN `+added.column+` `+added.definition)
 		if err != nil {
 			return err
 		}
+
+		log.Printf("%s table migrated: %s column added", added.table, added.column)
 	}
 
 	return nil
 }
 
+// seriesIdentityMigration returns statements rebuilding metrics table
+// with given columns kept.
+// Postgres keeps primary key index name of a renamed table,
+// so it is renamed as well for the new table to have its own.
+func seriesIdentityMigration(driver, columns string) []string {
+	migration := []string{}
+	if driver == "pgx" {
+		migration = append(migration, `ALTER INDEX IF EXISTS metrics_pkey RENAME TO metrics_old_pkey`)
+	}
+
+	return append(migration,
+		`ALTER TABLE metrics RENAME TO metrics_old`,
+		metricsTable,
+		fmt.Sprintf(`INSERT INTO metrics(%[1]s) SELECT %[1]s FROM metrics_old`, columns),
+		`DROP TABLE metrics_old`,
+	)
+}
+
+// migrateSeriesIdentity rebuilds metrics table created without
+// series identity columns since primary key can't be altered.
+// Stored metrics are kept with an empty host and no labels.
+func (d *DB) migrateSeriesIdentity(ctx context.Context) error {
+	if _, err := d.db.ExecContext(ctx, `SELECT labels FROM metrics LIMIT 1`); err == nil {
+		return nil
+	}
+
+	columns := "id, mtype, delta, value"
+	if _, err := d.db.ExecContext(ctx, `SELECT host FROM metrics LIMIT 1`); err == nil {
+		columns = "host, " + columns
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, statement := range seriesIdentityMigration(d.driver, columns) {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+
+	log.Println("metrics table migrated: series identity columns added")
+
+	return tx.Commit()
+}
+
+func (d *DB) Check(ctx context.Context) error {
+	return d.db.PingContext(ctx)
+}
+
+func (d *DB) Set(metric Metric) error {
+	return d.SetBulk([]Metric{metric})
+}
+
 func (d *DB) SetBulk(metrics []Metric) error {
+	d.writes.RLock()
+	defer d.writes.RUnlock()
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err = d.setBulk(tx, metrics); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SetBatch stores metrics of a batch unless it was already applied.
+// Batch ID is recorded in the same transaction as metrics
+// so a batch is either applied and remembered or neither.
+func (d *DB) SetBatch(source, id string, metrics []Metric) error {
+	d.writes.RLock()
+	defer d.writes.RUnlock()
+
 	tx, err := d.db.Begin()
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback()
 
-	var stmt *sql.Stmt
+	now := time.Now()
+
+	_, err = tx.Exec(`DELETE FROM batches WHERE applied < $1`, now.Add(-BatchRetention).Unix())
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.Exec(`
+		INSERT INTO batches(source, id, applied) VALUES($1,$2,$3)
+		ON CONFLICT (source, id) DO NOTHING
+	`, source, id, now.Unix())
+	if err != nil {
+		return err
+	}
+
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if inserted == 0 {
+		return ErrDuplicateBatch
+	}
+
+	if err = d.setBulk(tx, metrics); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// setBulk stores metrics and records their samples within a transaction.
+// Counter samples are running totals returned by the upsert.
+func (d *DB) setBulk(tx *sql.Tx, metrics []Metric) error {
+	counterStmt, err := tx.Prepare(`
+		INSERT INTO metrics(host, id, labels, mtype, delta) VALUES($1,$2,$3,$4,$5)
+		ON CONFLICT (host, id, labels) DO UPDATE
+		SET mtype = $4, delta = metrics.delta + $5
+		RETURNING delta
+	`)
+	if err != nil {
+		return err
+	}
+	defer counterStmt.Close()
+
+	gaugeStmt, err := tx.Prepare(`
+		INSERT INTO metrics(host, id, labels, mtype, value) VALUES($1,$2,$3,$4,$5)
+		ON CONFLICT (host, id, labels) DO UPDATE
+		SET mtype = $4, value = $5
+	`)
+	if err != nil {
+		return err
+	}
+	defer gaugeStmt.Close()
+
+	sampleStmt, err := tx.Prepare(`INSERT INTO samples(host, id, labels, ts, value, delta) VALUES($1,$2,$3,$4,$5,$6)`)
+	if err != nil {
+		return err
+	}
+	defer sampleStmt.Close()
+
+	now := time.Now().UnixNano()
 
 	for _, metric := range metrics {
+		canonicalLabels := labels.Format(metric.Labels)
+
 		switch metric.MType {
 		case Counter.String():
-			stmt, err = tx.Prepare(`
-				INSERT INTO metrics(id, mtype, delta) VALUES($1,$2,$3)
-				ON CONFLICT (id) DO UPDATE
-				SET mtype = $2, delta = metrics.delta + $3
-			`)
-			if err != nil {
+			var total int64
+			if err = counterStmt.QueryRow(metric.Host, metric.ID, canonicalLabels, metric.MType, metric.Delta).Scan(&total); err != nil {
 				return err
 			}
-			if _, err = stmt.Exec(metric.ID, metric.MType, metric.Delta); err != nil {
+
+			if _, err = sampleStmt.Exec(metric.Host, metric.ID, canonicalLabels, now, float64(total), float64(*metric.Delta)); err != nil {
 				return err
 			}
 		case Gauge.String():
-			stmt, err = tx.Prepare(`
-				INSERT INTO metrics(id, mtype, value) VALUES($1,$2,$3)
-				ON CONFLICT (id) DO UPDATE
-				SET mtype = $2, value = $3
-			`)
+			if _, err = gaugeStmt.Exec(metric.Host, metric.ID, canonicalLabels, metric.MType, metric.Value); err != nil {
+				return err
+			}
+
+			if _, err = sampleStmt.Exec(metric.Host, metric.ID, canonicalLabels, now, *metric.Value, 0); err != nil {
+				return err
+			}
+		case Histogram.String():
+			err = d.setMerged(tx, metric, "histogram", metric.Histogram, func(stored []byte) (any, error) {
+				merged := &HistogramData{}
+				if err := json.Unmarshal(stored, merged); err != nil {
+					return nil, err
+				}
+
+				merged.Merge(metric.Histogram)
+
+				return merged, nil
+			})
 			if err != nil {
 				return err
 			}
-			if _, err = stmt.Exec(metric.ID, metric.MType, metric.Value); err != nil {
+		case Summary.String():
+			err = d.setMerged(tx, metric, "summary", metric.Summary, func(stored []byte) (any, error) {
+				merged := &sketch.Sketch{}
+				if err := json.Unmarshal(stored, merged); err != nil {
+					return nil, err
+				}
+
+				merged.Merge(metric.Summary)
+
+				return merged, nil
+			})
+			if err != nil {
 				return err
 			}
 		}
 	}
-	defer stmt.Close()
 
-	return tx.Commit()
+	return nil
 }
-func (d *DB) Get(ctx context.Context, name string) (Metric, error) {
-	query := `SELECT id, mtype, delta, value FROM metrics WHERE id=$1`
 
-	metric := Metric{}
-	if err := d.db.QueryRowContext(ctx, query, name).Scan(&metric.ID, &metric.MType, &metric.Delta, &metric.Value); err != nil {
+// setMerged stores a JSON encoded metric value in column
+// merging it with the stored one within a transaction.
+// Stored row is locked with postgres so concurrent merges don't lose observations.
+func (d *DB) setMerged(tx *sql.Tx, metric Metric, column string, value any, merge func(stored []byte) (any, error)) error {
+	query := `SELECT ` + column + ` FROM metrics WHERE host=$1 AND id=$2 AND labels=$3`
+	if d.driver == "pgx" {
+		query += ` FOR UPDATE`
+	}
+
+	canonicalLabels := labels.Format(metric.Labels)
+
+	var stored sql.NullString
+
+	err := tx.QueryRow(query, metric.Host, metric.ID, canonicalLabels).Scan(&stored)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	case stored.Valid:
+		value, err = merge([]byte(stored.String))
+		if err != nil {
+			return fmt.Errorf("failed to decode stored %s: %w", column, err)
+		}
+	}
+
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO metrics(host, id, labels, mtype, `+column+`) VALUES($1,$2,$3,$4,$5)
+		ON CONFLICT (host, id, labels) DO UPDATE
+		SET mtype = $4, `+column+` = $5
+	`, metric.Host, metric.ID, canonicalLabels, metric.MType, string(encoded))
+
+	return err
+}
+
+func (d *DB) Get(ctx context.Context, key Key) (Metric, error) {
+	query := `SELECT ` + metricsColumns + ` FROM metrics WHERE host=$1 AND id=$2 AND labels=$3`
+
+	metric, err := scanMetric(d.db.QueryRowContext(ctx, query, key.Host, key.ID, key.Labels))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Metric{}, ErrNotFound
+	}
+	if err != nil {
 		log.Printf("failed to query db: %s", err)
 		return Metric{}, err
 	}
@@ -136,8 +452,45 @@ func (d *DB) Get(ctx context.Context, name string) (Metric, error) {
 	return metric, nil
 }
 
-func (d *DB) GetAll(ctx context.Context) (map[string]Metric, error) {
-	query := `SELECT * FROM metrics`
+// scanMetric reads a metric from a metrics table row.
+// Row must have metricsColumns.
+func scanMetric(row interface{ Scan(...any) error }) (Metric, error) {
+	var (
+		metric          Metric
+		canonicalLabels string
+		histogram       sql.NullString
+		summary         sql.NullString
+	)
+
+	err := row.Scan(&metric.Host, &metric.ID, &canonicalLabels, &metric.MType, &metric.Delta, &metric.Value, &histogram, &summary)
+	if err != nil {
+		return Metric{}, err
+	}
+
+	metric.Labels, err = labels.Parse(canonicalLabels)
+	if err != nil {
+		return Metric{}, err
+	}
+
+	if histogram.Valid {
+		metric.Histogram = &HistogramData{}
+		if err := json.Unmarshal([]byte(histogram.String), metric.Histogram); err != nil {
+			return Metric{}, fmt.Errorf("failed to decode histogram: %w", err)
+		}
+	}
+
+	if summary.Valid {
+		metric.Summary = &sketch.Sketch{}
+		if err := json.Unmarshal([]byte(summary.String), metric.Summary); err != nil {
+			return Metric{}, fmt.Errorf("failed to decode summary: %w", err)
+		}
+	}
+
+	return metric, nil
+}
+
+func (d *DB) GetAll(ctx context.Context) (map[Key]Metric, error) {
+	query := `SELECT ` + metricsColumns + ` FROM metrics`
 
 	rows, err := d.db.QueryContext(ctx, query)
 	if err != nil {
@@ -148,9 +501,7 @@ func (d *DB) GetAll(ctx context.Context) (map[string]Metric, error) {
 	recs := make([]Metric, 0)
 
 	for rows.Next() {
-		var rec Metric
-
-		err = rows.Scan(&rec.ID, &rec.MType, &rec.Delta, &rec.Value)
+		rec, err := scanMetric(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -163,14 +514,316 @@ func (d *DB) GetAll(ctx context.Context) (map[string]Metric, error) {
 		}
 	}
 
-	newDB := map[string]Metric{}
+	newDB := map[Key]Metric{}
 	for _, i := range recs {
-		newDB[i.ID] = i
+		newDB[i.Key()] = i
 	}
 
 	return newDB, nil
 }
 
+// List returns a page of stored metrics matching filters.
+// Filters and pagination are applied by the database.
+// ID regex must be valid Go regex, it is matched with Go regexp on sqlite
+// and with POSIX one on Postgres which share common syntax.
+func (d *DB) List(ctx context.Context, opts ListOptions) (Page, error) {
+	pattern, err := opts.pattern()
+	if err != nil {
+		return Page{}, err
+	}
+
+	conditions := []string{}
+	args := []any{}
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	where := func() string {
+		if len(conditions) == 0 {
+			return ""
+		}
+
+		return ` WHERE ` + strings.Join(conditions, ` AND `)
+	}
+
+	if opts.Type != "" {
+		conditions = append(conditions, `mtype=`+arg(opts.Type))
+	}
+
+	if opts.Prefix != "" {
+		conditions = append(conditions, fmt.Sprintf(`substr(id, 1, %s)=%s`, arg(utf8.RuneCountInString(opts.Prefix)), arg(opts.Prefix)))
+	}
+
+	if pattern != nil {
+		operator := ` REGEXP `
+		if d.driver == "pgx" {
+			operator = ` ~ `
+		}
+
+		conditions = append(conditions, `id`+operator+arg(opts.Match))
+	}
+
+	page := Page{}
+
+	err = d.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM metrics`+where(), args...).Scan(&page.Total)
+	if err != nil {
+		return Page{}, err
+	}
+
+	order, after, noLimit := "", ">", "-1"
+	if opts.Desc {
+		order, after = " DESC", "<"
+	}
+	if d.driver == "pgx" {
+		noLimit = "ALL"
+	}
+
+	if opts.After != nil {
+		conditions = append(conditions, fmt.Sprintf(`(id, host, labels) %s (%s, %s, %s)`,
+			after, arg(opts.After.ID), arg(opts.After.Host), arg(opts.After.Labels),
+		))
+	}
+
+	query := `SELECT ` + metricsColumns + ` FROM metrics` + where() +
+		` ORDER BY id` + order + `, host` + order + `, labels` + order
+
+	// One more metric is loaded to tell if there is a next page
+	switch {
+	case opts.Limit > 0:
+		query += ` LIMIT ` + arg(opts.Limit+1)
+	case opts.Offset > 0:
+		query += ` LIMIT ` + noLimit
+	}
+
+	if opts.Offset > 0 {
+		query += ` OFFSET ` + arg(opts.Offset)
+	}
+
+	page.Metrics, err = d.queryMetrics(ctx, query, args...)
+	if err != nil {
+		return Page{}, err
+	}
+
+	if opts.Limit > 0 && len(page.Metrics) > opts.Limit {
+		page.Metrics = page.Metrics[:opts.Limit]
+
+		next := page.Metrics[opts.Limit-1].Key()
+		page.Next = &next
+	}
+
+	return page, nil
+}
+
+// queryMetrics returns metrics query selects.
+// Query must select metricsColumns.
+func (d *DB) queryMetrics(ctx context.Context, query string, args ...any) ([]Metric, error) {
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	metrics := []Metric{}
+
+	for rows.Next() {
+		metric, err := scanMetric(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		metrics = append(metrics, metric)
+	}
+
+	return metrics, rows.Err()
+}
+
+// Range returns series history at given resolution
+// between from and to in time order.
+func (d *DB) Range(ctx context.Context, key Key, resolution time.Duration, from, to time.Time) ([]Rollup, error) {
+	query := `
+		SELECT ts, min, max, sum, count, last, delta FROM rollups
+		WHERE host=$1 AND id=$2 AND labels=$3 AND ts BETWEEN $4 AND $5 AND resolution=$6
+		ORDER BY ts
+	`
+	args := []any{key.Host, key.ID, key.Labels, from.UnixNano(), to.UnixNano(), int64(resolution)}
+
+	// Raw samples are single sample rollups
+	if resolution == RawResolution {
+		query = `
+			SELECT ts, value, value, value, 1, value, delta FROM samples
+			WHERE host=$1 AND id=$2 AND labels=$3 AND ts BETWEEN $4 AND $5
+			ORDER BY ts
+		`
+		args = args[:5]
+	}
+
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rollups := []Rollup{}
+
+	for rows.Next() {
+		var (
+			rollup Rollup
+			ts     int64
+		)
+
+		err := rows.Scan(&ts, &rollup.Min, &rollup.Max, &rollup.Sum, &rollup.Count, &rollup.Last, &rollup.Delta)
+		if err != nil {
+			return nil, err
+		}
+
+		rollup.Time = time.Unix(0, ts)
+		rollups = append(rollups, rollup)
+	}
+
+	return rollups, rows.Err()
+}
+
+// Compact rolls up series history of finished periods
+// and removes history older than retention.
+// Periods are rolled up once, the latest rolled up period is a watermark.
+// Metrics are not stored while compacting, so samples timestamped
+// before compaction are committed before the watermark moves past them.
+func (d *DB) Compact(ctx context.Context, now time.Time, retention Retention) error {
+	d.writes.Lock()
+	defer d.writes.Unlock()
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = d.rollUp(ctx, tx, RawResolution, MinuteResolution, now.Truncate(MinuteResolution))
+	if err != nil {
+		return fmt.Errorf("failed to roll up samples: %w", err)
+	}
+
+	err = d.rollUp(ctx, tx, MinuteResolution, HourResolution, now.Truncate(HourResolution))
+	if err != nil {
+		return fmt.Errorf("failed to roll up minute rollups: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM samples WHERE ts < $1`, now.Add(-retention.Raw).UnixNano())
+	if err != nil {
+		return err
+	}
+
+	for resolution, keep := range map[time.Duration]time.Duration{
+		MinuteResolution: retention.Minute,
+		HourResolution:   retention.Hour,
+	} {
+		_, err = tx.ExecContext(ctx,
+			`DELETE FROM rollups WHERE resolution=$1 AND ts < $2`,
+			int64(resolution), now.Add(-keep).UnixNano(),
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// rollUp aggregates history at source resolution into rollups
+// of periods finished before end which are not rolled up yet.
+func (d *DB) rollUp(ctx context.Context, tx *sql.Tx, source, resolution time.Duration, end time.Time) error {
+	var watermark sql.NullInt64
+
+	err := tx.QueryRowContext(ctx,
+		`SELECT MAX(ts) FROM rollups WHERE resolution=$1`, int64(resolution),
+	).Scan(&watermark)
+	if err != nil {
+		return err
+	}
+
+	var start int64
+	if watermark.Valid {
+		start = time.Unix(0, watermark.Int64).Add(resolution).UnixNano()
+	}
+
+	query := `
+		SELECT host, id, labels, ts, min, max, sum, count, last, delta FROM rollups
+		WHERE ts >= $1 AND ts < $2 AND resolution=$3
+		ORDER BY host, id, labels, ts
+	`
+	args := []any{start, end.UnixNano(), int64(source)}
+
+	if source == RawResolution {
+		query = `
+			SELECT host, id, labels, ts, value, value, value, 1, value, delta FROM samples
+			WHERE ts >= $1 AND ts < $2
+			ORDER BY host, id, labels, ts
+		`
+		args = args[:2]
+	}
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	history := map[Key][]Rollup{}
+	keys := []Key{}
+
+	for rows.Next() {
+		var (
+			key    Key
+			rollup Rollup
+			ts     int64
+		)
+
+		err := rows.Scan(
+			&key.Host, &key.ID, &key.Labels, &ts,
+			&rollup.Min, &rollup.Max, &rollup.Sum, &rollup.Count, &rollup.Last, &rollup.Delta,
+		)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+
+		rollup.Time = time.Unix(0, ts)
+
+		if _, ok := history[key]; !ok {
+			keys = append(keys, key)
+		}
+		history[key] = append(history[key], rollup)
+	}
+
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO rollups(host, id, labels, resolution, ts, min, max, sum, count, last, delta)
+		VALUES($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+		ON CONFLICT (host, id, labels, resolution, ts) DO NOTHING
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, key := range keys {
+		for _, rollup := range rollUp(history[key], resolution) {
+			_, err := stmt.ExecContext(ctx,
+				key.Host, key.ID, key.Labels, int64(resolution), rollup.Time.UnixNano(),
+				rollup.Min, rollup.Max, rollup.Sum, rollup.Count, rollup.Last, rollup.Delta,
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
 func (d *DB) Close() {
 	d.db.Close()
 }
@@ -2,9 +2,13 @@ package storage
 
 import (
 	"context"
+	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/horseinthesky/metricsagent/internal/sketch"
 )
 
 func TestDBDB(t *testing.T) {
@@ -70,17 +74,338 @@ func TestDBDB(t *testing.T) {
 	err = db.Set(gauge)
 	require.NoError(t, err)
 
-	dbCounter, err := db.Get(ctx, "testCounter")
+	dbCounter, err := db.Get(ctx, Key{ID: "testCounter"})
 	require.NoError(t, err)
 	require.Equal(t, int64(40), *dbCounter.Delta)
 
-	notExists, err := db.Get(ctx, "notExists")
-	require.Error(t, err)
+	notExists, err := db.Get(ctx, Key{ID: "notExists"})
+	require.ErrorIs(t, err, ErrNotFound)
 	require.Empty(t, notExists)
 
 	dbMetrics, err := db.GetAll(ctx)
 	require.NoError(t, err)
 
-	dbGauge := dbMetrics["testGauge"]
+	dbGauge := dbMetrics[Key{ID: "testGauge"}]
 	require.Equal(t, gaugeValue, *dbGauge.Value)
 }
+
+func TestDBBatch(t *testing.T) {
+	db := NewDBStorage("sqlite3", ":memory:")
+
+	ctx := context.Background()
+
+	err := db.Init(ctx)
+	require.NoError(t, err)
+
+	delta := int64(10)
+	batch := []Metric{{ID: "testCounter", MType: "counter", Delta: &delta}}
+
+	err = db.SetBatch("10.0.0.1", "batch1", batch)
+	require.NoError(t, err)
+
+	err = db.SetBatch("10.0.0.1", "batch1", batch)
+	require.ErrorIs(t, err, ErrDuplicateBatch)
+
+	// Batch IDs are scoped by source
+	err = db.SetBatch("10.0.0.2", "batch1", batch)
+	require.NoError(t, err)
+
+	dbCounter, err := db.Get(ctx, Key{ID: "testCounter"})
+	require.NoError(t, err)
+	require.Equal(t, int64(20), *dbCounter.Delta)
+}
+
+func TestDBHosts(t *testing.T) {
+	db := NewDBStorage("sqlite3", ":memory:")
+
+	ctx := context.Background()
+
+	err := db.Init(ctx)
+	require.NoError(t, err)
+
+	for _, host := range []string{"host1", "host2"} {
+		delta := int64(10)
+		err := db.Set(Metric{ID: "testCounter", Host: host, MType: "counter", Delta: &delta})
+		require.NoError(t, err)
+	}
+
+	dbCounter, err := db.Get(ctx, Key{Host: "host1", ID: "testCounter"})
+	require.NoError(t, err)
+	require.Equal(t, int64(10), *dbCounter.Delta)
+	require.Equal(t, "host1", dbCounter.Host)
+
+	dbMetrics, err := db.GetAll(ctx)
+	require.NoError(t, err)
+	require.Len(t, dbMetrics, 2)
+}
+
+func TestDBMigrate(t *testing.T) {
+	db := NewDBStorage("sqlite3", ":memory:")
+	db.db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+
+	// Metrics table of the previous version
+	_, err := db.db.Exec(`
+		CREATE TABLE metrics (
+			id text PRIMARY KEY,
+			mtype text NOT NULL,
+			delta bigint,
+			value double precision
+		)
+	`)
+	require.NoError(t, err)
+
+	_, err = db.db.Exec(`INSERT INTO metrics(id, mtype, delta) VALUES('testCounter', 'counter', 10)`)
+	require.NoError(t, err)
+
+	err = db.Init(ctx)
+	require.NoError(t, err)
+
+	dbCounter, err := db.Get(ctx, Key{ID: "testCounter"})
+	require.NoError(t, err)
+	require.Equal(t, int64(10), *dbCounter.Delta)
+
+	// Migration is done once
+	err = db.Init(ctx)
+	require.NoError(t, err)
+}
+
+func TestDBMigratePostgres(t *testing.T) {
+	migration := seriesIdentityMigration("pgx", "id, mtype, delta, value")
+	require.Equal(t, `ALTER INDEX IF EXISTS metrics_pkey RENAME TO metrics_old_pkey`, migration[0])
+	require.Len(t, seriesIdentityMigration("sqlite3", "id"), len(migration)-1)
+
+	// Baseline table migration needs a live database
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN is not set")
+	}
+
+	db := NewDBStorage("pgx", dsn)
+	ctx := context.Background()
+
+	for _, table := range []string{"metrics", "metrics_old", "batches", "samples", "rollups"} {
+		_, err := db.db.Exec(`DROP TABLE IF EXISTS ` + table)
+		require.NoError(t, err)
+	}
+
+	// Metrics table of the baseline version
+	_, err := db.db.Exec(`
+		CREATE TABLE metrics (
+			id text PRIMARY KEY,
+			mtype text NOT NULL,
+			delta bigint,
+			value double precision
+		)
+	`)
+	require.NoError(t, err)
+
+	_, err = db.db.Exec(`INSERT INTO metrics(id, mtype, delta) VALUES('testCounter', 'counter', 10)`)
+	require.NoError(t, err)
+
+	require.NoError(t, db.Init(ctx))
+
+	dbCounter, err := db.Get(ctx, Key{ID: "testCounter"})
+	require.NoError(t, err)
+	require.Equal(t, int64(10), *dbCounter.Delta)
+}
+
+func TestDBLabels(t *testing.T) {
+	db := NewDBStorage("sqlite3", ":memory:")
+
+	ctx := context.Background()
+
+	err := db.Init(ctx)
+	require.NoError(t, err)
+
+	for _, cpu := range []string{"0", "1"} {
+		value := float64(10)
+		err := db.SetBulk([]Metric{{ID: "testGauge", Labels: map[string]string{"cpu": cpu}, MType: "gauge", Value: &value}})
+		require.NoError(t, err)
+	}
+
+	dbGauge, err := db.Get(ctx, Metric{ID: "testGauge", Labels: map[string]string{"cpu": "1"}}.Key())
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"cpu": "1"}, dbGauge.Labels)
+
+	_, err = db.Get(ctx, Key{ID: "testGauge"})
+	require.Error(t, err)
+
+	dbMetrics, err := db.GetAll(ctx)
+	require.NoError(t, err)
+	require.Len(t, dbMetrics, 2)
+}
+
+func TestDBHistogram(t *testing.T) {
+	db := NewDBStorage("sqlite3", ":memory:")
+	db.db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+
+	err := db.Init(ctx)
+	require.NoError(t, err)
+
+	for _, v := range []float64{0.5, 5} {
+		histogram := NewHistogram([]float64{1})
+		histogram.Observe(v)
+
+		err := db.Set(Metric{ID: "testHistogram", MType: "histogram", Histogram: histogram})
+		require.NoError(t, err)
+	}
+
+	dbHistogram, err := db.Get(ctx, Key{ID: "testHistogram"})
+	require.NoError(t, err)
+	require.Equal(t, []float64{1}, dbHistogram.Histogram.Bounds)
+	require.Equal(t, []uint64{1, 1}, dbHistogram.Histogram.Counts)
+	require.Equal(t, uint64(2), dbHistogram.Histogram.Count)
+	require.Equal(t, 5.5, dbHistogram.Histogram.Sum)
+
+	dbMetrics, err := db.GetAll(ctx)
+	require.NoError(t, err)
+	require.Len(t, dbMetrics, 1)
+}
+
+func TestDBMigrateHistogram(t *testing.T) {
+	db := NewDBStorage("sqlite3", ":memory:")
+	db.db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+
+	// Metrics table of the previous version
+	_, err := db.db.Exec(`
+		CREATE TABLE metrics (
+			host text NOT NULL DEFAULT '',
+			id text NOT NULL,
+			labels text NOT NULL DEFAULT '',
+			mtype text NOT NULL,
+			delta bigint,
+			value double precision,
+			PRIMARY KEY (host, id, labels)
+		)
+	`)
+	require.NoError(t, err)
+
+	_, err = db.db.Exec(`INSERT INTO metrics(host, id, mtype, delta) VALUES('testhost', 'testCounter', 'counter', 10)`)
+	require.NoError(t, err)
+
+	err = db.Init(ctx)
+	require.NoError(t, err)
+
+	dbCounter, err := db.Get(ctx, Key{Host: "testhost", ID: "testCounter"})
+	require.NoError(t, err)
+	require.Equal(t, int64(10), *dbCounter.Delta)
+	require.Nil(t, dbCounter.Histogram)
+}
+
+func TestDBSummary(t *testing.T) {
+	db := NewDBStorage("sqlite3", ":memory:")
+	db.db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+
+	err := db.Init(ctx)
+	require.NoError(t, err)
+
+	for _, v := range []float64{10, 1000} {
+		summary := sketch.New(sketch.DefaultRelativeAccuracy)
+		summary.Add(v)
+
+		err := db.Set(Metric{ID: "testSummary", MType: "summary", Summary: summary})
+		require.NoError(t, err)
+	}
+
+	dbSummary, err := db.Get(ctx, Key{ID: "testSummary"})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), dbSummary.Summary.Count)
+	require.Equal(t, float64(1010), dbSummary.Summary.Sum)
+	require.InEpsilon(t, 1000, dbSummary.Summary.Quantile(1), sketch.DefaultRelativeAccuracy)
+}
+
+func TestDBRange(t *testing.T) {
+	db := NewDBStorage("sqlite3", ":memory:")
+	db.db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+
+	err := db.Init(ctx)
+	require.NoError(t, err)
+
+	start := time.Now()
+
+	for _, delta := range []int64{1, 2} {
+		delta := delta
+		err := db.Set(Metric{ID: "testCounter", MType: "counter", Delta: &delta})
+		require.NoError(t, err)
+	}
+
+	value := float64(7)
+	err = db.Set(Metric{ID: "testGauge", MType: "gauge", Value: &value})
+	require.NoError(t, err)
+
+	samples, err := db.Range(ctx, Key{ID: "testCounter"}, RawResolution, start, time.Now())
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+
+	// Counter samples are running totals
+	require.Equal(t, float64(1), samples[0].Last)
+	require.Equal(t, float64(3), samples[1].Last)
+	require.False(t, samples[1].Time.Before(samples[0].Time))
+
+	samples, err = db.Range(ctx, Key{ID: "testGauge"}, RawResolution, start, time.Now())
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	require.Equal(t, float64(7), samples[0].Last)
+
+	samples, err = db.Range(ctx, Key{ID: "testGauge"}, RawResolution, start.Add(-time.Hour), start.Add(-time.Minute))
+	require.NoError(t, err)
+	require.Empty(t, samples)
+}
+
+func TestDBCompact(t *testing.T) {
+	db := NewDBStorage("sqlite3", ":memory:")
+	db.db.SetMaxOpenConns(1)
+
+	ctx := context.Background()
+
+	err := db.Init(ctx)
+	require.NoError(t, err)
+
+	start := time.Now()
+	retention := Retention{Raw: time.Hour, Minute: 24 * time.Hour, Hour: 30 * 24 * time.Hour}
+
+	for _, delta := range []int64{1, 2} {
+		delta := delta
+		err := db.Set(Metric{ID: "testCounter", MType: "counter", Delta: &delta})
+		require.NoError(t, err)
+	}
+
+	// Compaction is idempotent
+	for i := 0; i < 2; i++ {
+		err := db.Compact(ctx, start.Add(2*time.Hour), retention)
+		require.NoError(t, err)
+	}
+
+	raw, err := db.Range(ctx, Key{ID: "testCounter"}, RawResolution, start.Add(-time.Hour), start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, raw, "raw samples must expire")
+
+	for _, resolution := range []time.Duration{MinuteResolution, HourResolution} {
+		rollups, err := db.Range(ctx, Key{ID: "testCounter"}, resolution, start.Add(-time.Hour), start.Add(time.Hour))
+		require.NoError(t, err)
+		require.NotEmpty(t, rollups)
+
+		total := rollUp(rollups, 24*time.Hour*365)[0]
+		require.Equal(t, uint64(2), total.Count)
+		require.Equal(t, float64(3), total.Delta)
+		require.Equal(t, float64(3), total.Last)
+	}
+
+	err = db.Compact(ctx, start.Add(60*24*time.Hour), retention)
+	require.NoError(t, err)
+
+	var rollups int
+	err = db.db.QueryRow(`SELECT COUNT(*) FROM rollups`).Scan(&rollups)
+	require.NoError(t, err)
+	require.Zero(t, rollups)
+}
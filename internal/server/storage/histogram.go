@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// DefaultBuckets are histogram bucket upper bounds used
+// when none are configured.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// HistogramData is a distribution of observed values.
+//
+// Counts hold per bucket (not cumulative) number of observations.
+// Bucket i counts values in (Bounds[i-1], Bounds[i]],
+// the last bucket counts values greater than the last bound.
+type HistogramData struct {
+	Bounds []float64 `json:"bounds"` // bucket upper bounds in ascending order
+	Counts []uint64  `json:"counts"` // observations per bucket, len(Bounds)+1
+	Sum    float64   `json:"sum"`    // sum of observed values
+	Count  uint64    `json:"count"`  // number of observations
+}
+
+// NewHistogram returns an empty histogram with given bucket bounds.
+func NewHistogram(bounds []float64) *HistogramData {
+	return &HistogramData{
+		Bounds: append([]float64{}, bounds...),
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// Observe adds a value to histogram.
+func (h *HistogramData) Observe(v float64) {
+	h.Counts[sort.SearchFloat64s(h.Bounds, v)]++
+	h.Sum += v
+	h.Count++
+}
+
+// Validate checks histogram is consistent.
+func (h *HistogramData) Validate() error {
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return fmt.Errorf("histogram must have %d bucket counts, got %d", len(h.Bounds)+1, len(h.Counts))
+	}
+
+	if err := ValidateBuckets(h.Bounds); err != nil {
+		return err
+	}
+
+	if math.IsNaN(h.Sum) || math.IsInf(h.Sum, 0) {
+		return errors.New("histogram sum must be finite")
+	}
+
+	var total uint64
+	for _, count := range h.Counts {
+		total += count
+	}
+
+	if total != h.Count {
+		return fmt.Errorf("histogram count %d differs from bucket counts total %d", h.Count, total)
+	}
+
+	return nil
+}
+
+// ValidateBuckets checks bucket bounds are finite and ascending.
+func ValidateBuckets(bounds []float64) error {
+	for i, bound := range bounds {
+		if math.IsNaN(bound) || math.IsInf(bound, 0) {
+			return errors.New("histogram bounds must be finite")
+		}
+
+		if i != 0 && bound <= bounds[i-1] {
+			return errors.New("histogram bounds must be in ascending order")
+		}
+	}
+
+	return nil
+}
+
+// Merge adds other histogram observations.
+// If bucket bounds differ, histogram is replaced with the other one
+// since bucket layout change makes previous counts incomparable.
+func (h *HistogramData) Merge(other *HistogramData) {
+	if !sameBounds(h.Bounds, other.Bounds) {
+		*h = *other.Clone()
+		return
+	}
+
+	for i, count := range other.Counts {
+		h.Counts[i] += count
+	}
+	h.Sum += other.Sum
+	h.Count += other.Count
+}
+
+// Increase returns observations added to a cumulative histogram
+// since its earlier state. False is returned if histogram
+// was reset or its bucket bounds changed since then.
+func (h *HistogramData) Increase(earlier *HistogramData) (*HistogramData, bool) {
+	if !sameBounds(h.Bounds, earlier.Bounds) || h.Count < earlier.Count {
+		return nil, false
+	}
+
+	increase := h.Clone()
+	for i, count := range earlier.Counts {
+		if increase.Counts[i] < count {
+			return nil, false
+		}
+		increase.Counts[i] -= count
+	}
+	increase.Sum -= earlier.Sum
+	increase.Count -= earlier.Count
+
+	return increase, true
+}
+
+// Clone returns a deep copy of histogram.
+func (h *HistogramData) Clone() *HistogramData {
+	return &HistogramData{
+		Bounds: append([]float64{}, h.Bounds...),
+		Counts: append([]uint64{}, h.Counts...),
+		Sum:    h.Sum,
+		Count:  h.Count,
+	}
+}
+
+// sameBounds checks if bucket bounds are equal.
+func sameBounds(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram([]float64{1, 5})
+	for _, v := range []float64{0.5, 1, 3, 10} {
+		h.Observe(v)
+	}
+
+	require.Equal(t, []uint64{2, 1, 1}, h.Counts)
+	require.Equal(t, 14.5, h.Sum)
+	require.Equal(t, uint64(4), h.Count)
+	require.NoError(t, h.Validate())
+
+	other := NewHistogram([]float64{1, 5})
+	other.Observe(2)
+
+	h.Merge(other)
+	require.Equal(t, []uint64{2, 2, 1}, h.Counts)
+	require.Equal(t, uint64(5), h.Count)
+
+	// Bucket layout change replaces histogram
+	relayout := NewHistogram([]float64{10})
+	relayout.Observe(2)
+
+	h.Merge(relayout)
+	require.Equal(t, relayout, h)
+
+	relayout.Observe(3)
+	require.Equal(t, uint64(1), h.Count, "merged histogram must not share state")
+}
+
+func TestHistogramValidate(t *testing.T) {
+	require.Error(t, (&HistogramData{Bounds: []float64{1}, Counts: []uint64{1}}).Validate())
+	require.Error(t, (&HistogramData{Bounds: []float64{2, 1}, Counts: []uint64{0, 0, 0}}).Validate())
+	require.Error(t, (&HistogramData{Bounds: []float64{1}, Counts: []uint64{1, 1}, Count: 1}).Validate())
+	require.Error(t, (&HistogramData{Bounds: []float64{1}, Counts: []uint64{1, 1}, Count: 2, Sum: math.Inf(1)}).Validate())
+	require.NoError(t, (&HistogramData{Bounds: []float64{1}, Counts: []uint64{1, 1}, Count: 2}).Validate())
+}
+
+func TestHistogramIncrease(t *testing.T) {
+	earlier := &HistogramData{Bounds: []float64{1}, Counts: []uint64{1, 1}, Sum: 3, Count: 2}
+	later := &HistogramData{Bounds: []float64{1}, Counts: []uint64{2, 3}, Sum: 10, Count: 5}
+
+	increase, ok := later.Increase(earlier)
+	require.True(t, ok)
+	require.Equal(t, &HistogramData{Bounds: []float64{1}, Counts: []uint64{1, 2}, Sum: 7, Count: 3}, increase)
+
+	// Reset
+	_, ok = earlier.Increase(later)
+	require.False(t, ok)
+
+	// Bucket layout change
+	_, ok = (&HistogramData{Bounds: []float64{2}, Counts: []uint64{2, 3}, Count: 5}).Increase(earlier)
+	require.False(t, ok)
+}
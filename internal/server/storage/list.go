@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ListOptions select a page of stored metrics.
+// Metrics are listed in series order by ID, host and labels.
+type ListOptions struct {
+	Type   string // metric type, any if empty
+	Prefix string // metric ID prefix
+	Match  string // regex metric ID contains a match of, see DB.List
+	Desc   bool   // reversed series order
+	Limit  int    // page size, unlimited if zero
+	Offset int    // number of metrics skipped
+	After  *Key   // series listing continues after
+}
+
+// Page is a page of stored metrics.
+type Page struct {
+	Metrics []Metric
+	Total   int  // number of metrics matching filters regardless of page
+	Next    *Key // series the next page starts after, nil if it is the last one
+}
+
+// keyLess reports whether series a precedes series b.
+func keyLess(a, b Key) bool {
+	if a.ID != b.ID {
+		return a.ID < b.ID
+	}
+
+	if a.Host != b.Host {
+		return a.Host < b.Host
+	}
+
+	return a.Labels < b.Labels
+}
+
+// pattern returns compiled ID regex, nil if not set.
+func (o ListOptions) pattern() (*regexp.Regexp, error) {
+	if o.Match == "" {
+		return nil, nil
+	}
+
+	return regexp.Compile(o.Match)
+}
+
+// matches checks if metric passes type and ID filters.
+func (o ListOptions) matches(metric Metric, pattern *regexp.Regexp) bool {
+	if o.Type != "" && metric.MType != o.Type {
+		return false
+	}
+
+	if !strings.HasPrefix(metric.ID, o.Prefix) {
+		return false
+	}
+
+	return pattern == nil || pattern.MatchString(metric.ID)
+}
+
+// listPage returns a page of metrics matching filters.
+func listPage(metrics []Metric, opts ListOptions) Page {
+	sort.Slice(metrics, func(i, j int) bool {
+		if opts.Desc {
+			return keyLess(metrics[j].Key(), metrics[i].Key())
+		}
+
+		return keyLess(metrics[i].Key(), metrics[j].Key())
+	})
+
+	page := Page{Total: len(metrics)}
+
+	if opts.After != nil {
+		after := *opts.After
+		metrics = metrics[sort.Search(len(metrics), func(i int) bool {
+			if opts.Desc {
+				return keyLess(metrics[i].Key(), after)
+			}
+
+			return keyLess(after, metrics[i].Key())
+		}):]
+	}
+
+	if opts.Offset >= len(metrics) {
+		metrics = metrics[:0]
+	} else {
+		metrics = metrics[opts.Offset:]
+	}
+
+	if opts.Limit > 0 && len(metrics) > opts.Limit {
+		metrics = metrics[:opts.Limit]
+
+		next := metrics[len(metrics)-1].Key()
+		page.Next = &next
+	}
+
+	page.Metrics = metrics
+
+	return page
+}
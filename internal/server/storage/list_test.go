@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testList checks storage metrics listing.
+func testList(t *testing.T, db Storage) {
+	ctx := context.Background()
+
+	require.NoError(t, db.Init(ctx))
+
+	metrics := []Metric{}
+	for _, id := range []string{"cpu.user", "cpu.system", "mem.used", "cpu.idle"} {
+		value := float64(1)
+		metrics = append(metrics, Metric{ID: id, MType: "gauge", Value: &value})
+	}
+
+	delta := int64(1)
+	metrics = append(metrics,
+		Metric{ID: "cpu.user", Host: "node1", MType: "gauge", Value: metrics[0].Value},
+		Metric{ID: "cpu.count", MType: "counter", Delta: &delta},
+	)
+	require.NoError(t, db.SetBulk(metrics))
+
+	ids := func(page Page) []string {
+		listed := []string{}
+		for _, metric := range page.Metrics {
+			listed = append(listed, metric.Host+"/"+metric.ID)
+		}
+
+		return listed
+	}
+
+	page, err := db.List(ctx, ListOptions{Type: "gauge", Prefix: "cpu.", Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, 4, page.Total)
+	require.Equal(t, []string{"/cpu.idle", "/cpu.system"}, ids(page))
+	require.Equal(t, &Key{ID: "cpu.system"}, page.Next)
+
+	// Next page starts after the cursor
+	page, err = db.List(ctx, ListOptions{Type: "gauge", Prefix: "cpu.", Limit: 2, After: page.Next})
+	require.NoError(t, err)
+	require.Equal(t, 4, page.Total)
+	require.Equal(t, []string{"/cpu.user", "node1/cpu.user"}, ids(page))
+	require.Nil(t, page.Next)
+
+	page, err = db.List(ctx, ListOptions{Match: `\.(user|used)$`, Desc: true, Offset: 1})
+	require.NoError(t, err)
+	require.Equal(t, 3, page.Total)
+	require.Equal(t, []string{"node1/cpu.user", "/cpu.user"}, ids(page))
+	require.Nil(t, page.Next)
+
+	page, err = db.List(ctx, ListOptions{Match: `^cpu\.`, Limit: 2})
+	require.NoError(t, err)
+	require.Equal(t, 5, page.Total)
+	require.Equal(t, []string{"/cpu.count", "/cpu.idle"}, ids(page))
+	require.Equal(t, &Key{ID: "cpu.idle"}, page.Next)
+
+	page, err = db.List(ctx, ListOptions{Desc: true, Limit: 1, After: &Key{ID: "cpu.idle"}})
+	require.NoError(t, err)
+	require.Equal(t, 6, page.Total)
+	require.Equal(t, []string{"/cpu.count"}, ids(page))
+	require.Nil(t, page.Next)
+
+	page, err = db.List(ctx, ListOptions{Prefix: "disk"})
+	require.NoError(t, err)
+	require.Zero(t, page.Total)
+	require.Empty(t, page.Metrics)
+
+	_, err = db.List(ctx, ListOptions{Match: "("})
+	require.Error(t, err)
+}
+
+func TestMemoryList(t *testing.T) {
+	testList(t, NewMemoryStorage())
+}
+
+func TestDBList(t *testing.T) {
+	db := NewDBStorage("sqlite3", ":memory:")
+	db.db.SetMaxOpenConns(1)
+
+	testList(t, db)
+
+	// Filters without regex are applied by the database
+	page, err := db.List(context.Background(), ListOptions{Prefix: "mem", Offset: 1})
+	require.NoError(t, err)
+	require.Equal(t, 1, page.Total)
+	require.Empty(t, page.Metrics)
+}
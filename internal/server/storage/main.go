@@ -1,27 +1,100 @@
 package storage
 
-import "context"
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/horseinthesky/metricsagent/internal/labels"
+	"github.com/horseinthesky/metricsagent/internal/sketch"
+)
+
+// BatchRetention is how long applied batch IDs are remembered.
+// Retries of a batch within this window are not applied twice.
+const BatchRetention = 24 * time.Hour
+
+// batchPruneInterval is how often expired batch IDs are forgotten.
+const batchPruneInterval = time.Minute
+
+// ErrDuplicateBatch is returned when a batch was already applied.
+var ErrDuplicateBatch = errors.New("batch already applied")
+
+// ErrNotFound is returned when a metric is not stored.
+var ErrNotFound = errors.New("no value found")
 
 type MetricType int
 
 const (
 	Gauge MetricType = iota
 	Counter
+	Histogram
+	Summary
 )
 
 func (mt MetricType) String() string {
 	return [...]string{
 		"gauge",
 		"counter",
+		"histogram",
+		"summary",
 	}[mt]
 }
 
 type Metric struct {
-	ID    string   `json:"id"`              // имя метрики
-	MType string   `json:"type"`            // параметр, принимающий значение gauge или counter
-	Delta *int64   `json:"delta,omitempty"` // значение метрики в случае передачи counter
-	Value *float64 `json:"value,omitempty"` // значение метрики в случае передачи gauge
-	Hash  string   `json:"hash,omitempty"`  // значение хеш-функции
+	ID        string             `json:"id"`                  // имя метрики
+	Host      string             `json:"host,omitempty"`      // идентификатор агента
+	Labels    map[string]string  `json:"labels,omitempty"`    // метки метрики
+	MType     string             `json:"type"`                // параметр, принимающий значение gauge, counter, histogram или summary
+	Delta     *int64             `json:"delta,omitempty"`     // значение метрики в случае передачи counter
+	Value     *float64           `json:"value,omitempty"`     // значение метрики в случае передачи gauge
+	Histogram *HistogramData     `json:"histogram,omitempty"` // значение метрики в случае передачи histogram
+	Summary   *sketch.Sketch     `json:"summary,omitempty"`   // значение метрики в случае передачи summary
+	Quantiles map[string]float64 `json:"quantiles,omitempty"` // квантили summary, только в ответах сервера
+	Hash      string             `json:"hash,omitempty"`      // значение хеш-функции
+}
+
+// ReportedQuantiles are summary quantiles the server answers with.
+var ReportedQuantiles = []float64{0.5, 0.9, 0.99}
+
+// SetQuantiles fills summary metric quantiles from its sketch.
+func (m *Metric) SetQuantiles() {
+	if m.MType != Summary.String() || m.Summary == nil {
+		return
+	}
+
+	m.Quantiles = map[string]float64{}
+	for _, q := range ReportedQuantiles {
+		m.Quantiles[strconv.FormatFloat(q, 'f', -1, 64)] = m.Summary.Quantile(q)
+	}
+}
+
+// Key identifies a metric series.
+// Metrics with the same ID reported by different hosts
+// or with different labels are different series.
+type Key struct {
+	Host   string
+	ID     string
+	Labels string // canonical label set form
+}
+
+// String returns human readable series name.
+func (k Key) String() string {
+	name := k.ID
+	if k.Host != "" {
+		name = k.Host + "/" + name
+	}
+
+	if k.Labels != "" {
+		name += "{" + k.Labels + "}"
+	}
+
+	return name
+}
+
+// Key returns metric series key.
+func (m Metric) Key() Key {
+	return Key{Host: m.Host, ID: m.ID, Labels: labels.Format(m.Labels)}
 }
 
 type Storage interface {
@@ -29,15 +102,45 @@ type Storage interface {
 	Check(context.Context) error
 	Set(Metric) error
 	SetBulk([]Metric) error
-	Get(context.Context, string) (Metric, error)
-	GetAll(context.Context) (map[string]Metric, error)
+	SetBatch(source, id string, metrics []Metric) error
+	Get(context.Context, Key) (Metric, error)
+	GetAll(context.Context) (map[Key]Metric, error)
+	// List returns a page of stored metrics matching filters.
+	List(context.Context, ListOptions) (Page, error)
+	Range(ctx context.Context, key Key, resolution time.Duration, from, to time.Time) ([]Rollup, error)
+	Compact(ctx context.Context, now time.Time, retention Retention) error
 	Close()
 }
 
 func UnsupportedType(mtype string) bool {
-	if mtype != Gauge.String() && mtype != Counter.String() {
-		return true
+	switch mtype {
+	case Gauge.String(), Counter.String(), Histogram.String(), Summary.String():
+		return false
+	}
+
+	return true
+}
+
+// Validate checks metric labels and histogram or summary value.
+func (m Metric) Validate() error {
+	if err := labels.Validate(m.Labels); err != nil {
+		return err
+	}
+
+	switch m.MType {
+	case Histogram.String():
+		if m.Histogram == nil {
+			return errors.New("no histogram value")
+		}
+
+		return m.Histogram.Validate()
+	case Summary.String():
+		if m.Summary == nil {
+			return errors.New("no summary value")
+		}
+
+		return m.Summary.Validate()
 	}
 
-	return false
+	return nil
 }
@@ -5,15 +5,32 @@ import (
 	"fmt"
 	"log"
 	"sync"
+	"time"
 )
 
 type Memory struct {
 	sync.RWMutex
-	db map[string]Metric
+	db      map[Key]Metric
+	history map[Key]*series
+	rolled  map[time.Duration]time.Time // end of the last rolled up period per resolution
+	batches map[string]time.Time
+	pruned  time.Time
+}
+
+// series is a series history at every resolution.
+type series struct {
+	raw     *ring
+	minutes []Rollup
+	hours   []Rollup
 }
 
 func NewMemoryStorage() *Memory {
-	return &Memory{db: map[string]Metric{}}
+	return &Memory{
+		db:      map[Key]Metric{},
+		history: map[Key]*series{},
+		rolled:  map[time.Duration]time.Time{},
+		batches: map[string]time.Time{},
+	}
 }
 
 func (m *Memory) Init(ctx context.Context) error {
@@ -30,60 +47,129 @@ func (m *Memory) Set(metric Metric) error {
 	m.Lock()
 	defer m.Unlock()
 
-	switch metric.MType {
-	case Counter.String():
-		oldMetric, ok := m.db[metric.ID]
-		if ok {
-			*oldMetric.Delta += *metric.Delta
-			return nil
+	m.setBulk([]Metric{metric})
 
-		}
+	return nil
+}
 
-		m.db[metric.ID] = metric
-	case Gauge.String():
-		m.db[metric.ID] = metric
-	}
+func (m *Memory) SetBulk(metrics []Metric) error {
+	m.Lock()
+	defer m.Unlock()
+
+	m.setBulk(metrics)
 
 	return nil
 }
 
-func (m *Memory) SetBulk(metrics []Metric) error {
+// SetBatch stores metrics of a batch unless it was already applied.
+// Batch IDs are remembered per source for BatchRetention.
+func (m *Memory) SetBatch(source, id string, metrics []Metric) error {
 	m.Lock()
 	defer m.Unlock()
 
+	now := time.Now()
+	if now.Sub(m.pruned) > batchPruneInterval {
+		for key, applied := range m.batches {
+			if now.Sub(applied) > BatchRetention {
+				delete(m.batches, key)
+			}
+		}
+		m.pruned = now
+	}
+
+	key := source + "/" + id
+	if _, ok := m.batches[key]; ok {
+		return ErrDuplicateBatch
+	}
+
+	m.setBulk(metrics)
+	m.batches[key] = now
+
+	return nil
+}
+
+// setBulk stores metrics and records their samples.
+// Caller must hold the lock.
+func (m *Memory) setBulk(metrics []Metric) {
+	now := time.Now()
+
 	for _, metric := range metrics {
 		switch metric.MType {
 		case Counter.String():
-			oldMetric, ok := m.db[metric.ID]
+			oldMetric, ok := m.db[metric.Key()]
 			if ok {
 				*oldMetric.Delta += *metric.Delta
+				m.record(oldMetric, metric.Delta, now)
 				continue
 			}
-			m.db[metric.ID] = metric
+			m.db[metric.Key()] = metric
+			m.record(metric, metric.Delta, now)
 		case Gauge.String():
-			m.db[metric.ID] = metric
+			m.db[metric.Key()] = metric
+			m.record(metric, nil, now)
+		case Histogram.String():
+			// Merged histogram is a new value
+			// so metrics returned by GetAll earlier stay intact
+			histogram := metric.Histogram.Clone()
+
+			oldMetric, ok := m.db[metric.Key()]
+			if ok && oldMetric.Histogram != nil {
+				histogram = oldMetric.Histogram.Clone()
+				histogram.Merge(metric.Histogram)
+			}
+
+			metric.Histogram = histogram
+			m.db[metric.Key()] = metric
+		case Summary.String():
+			summary := metric.Summary.Clone()
+
+			oldMetric, ok := m.db[metric.Key()]
+			if ok && oldMetric.Summary != nil {
+				summary = oldMetric.Summary.Clone()
+				summary.Merge(metric.Summary)
+			}
+
+			metric.Summary = summary
+			m.db[metric.Key()] = metric
 		}
 	}
+}
 
-	return nil
+// record adds stored metric value to its series history.
+// Delta is a counter increment which produced stored total.
+// Caller must hold the lock.
+func (m *Memory) record(metric Metric, delta *int64, at time.Time) {
+	sample, ok := newSample(metric, delta, at)
+	if !ok {
+		return
+	}
+
+	history, ok := m.history[metric.Key()]
+	if !ok {
+		history = &series{raw: newRing(HistorySize)}
+		m.history[metric.Key()] = history
+	}
+
+	history.raw.add(sample)
 }
-func (m *Memory) Get(ctx context.Context, name string) (Metric, error) {
+
+func (m *Memory) Get(ctx context.Context, key Key) (Metric, error) {
 	m.RLock()
 	defer m.RUnlock()
 
-	metric, ok := m.db[name]
+	metric, ok := m.db[key]
 	if !ok {
-		return Metric{}, fmt.Errorf("no value found")
+		return Metric{}, ErrNotFound
 	}
 
 	return metric, nil
 }
 
-func (m *Memory) GetAll(ctx context.Context) (map[string]Metric, error) {
+func (m *Memory) GetAll(ctx context.Context) (map[Key]Metric, error) {
 	m.RLock()
 	defer m.RUnlock()
 
-	newDB := map[string]Metric{}
+	newDB := map[Key]Metric{}
 	for k, v := range m.db {
 		newDB[k] = v
 	}
@@ -91,5 +177,95 @@ func (m *Memory) GetAll(ctx context.Context) (map[string]Metric, error) {
 	return newDB, nil
 }
 
+// List returns a page of stored metrics matching filters.
+func (m *Memory) List(ctx context.Context, opts ListOptions) (Page, error) {
+	pattern, err := opts.pattern()
+	if err != nil {
+		return Page{}, err
+	}
+
+	m.RLock()
+	defer m.RUnlock()
+
+	metrics := []Metric{}
+	for _, metric := range m.db {
+		if opts.matches(metric, pattern) {
+			metrics = append(metrics, metric)
+		}
+	}
+
+	return listPage(metrics, opts), nil
+}
+
+// Range returns series history at given resolution
+// between from and to in time order.
+// Only HistorySize most recent raw samples are kept.
+func (m *Memory) Range(ctx context.Context, key Key, resolution time.Duration, from, to time.Time) ([]Rollup, error) {
+	m.RLock()
+	defer m.RUnlock()
+
+	history, ok := m.history[key]
+	if !ok {
+		return []Rollup{}, nil
+	}
+
+	switch resolution {
+	case RawResolution:
+		return sampleRollups(history.raw.between(from, to)), nil
+	case MinuteResolution:
+		return rollupsBetween(history.minutes, from, to), nil
+	case HourResolution:
+		return rollupsBetween(history.hours, from, to), nil
+	}
+
+	return nil, fmt.Errorf("unsupported resolution: %s", resolution)
+}
+
+// rollupsBetween returns time ordered rollups in [from, to].
+func rollupsBetween(rollups []Rollup, from, to time.Time) []Rollup {
+	result := []Rollup{}
+	for _, rollup := range rollups {
+		if rollup.Time.Before(from) || rollup.Time.After(to) {
+			continue
+		}
+
+		result = append(result, rollup)
+	}
+
+	return result
+}
+
+// Compact rolls up series history of finished periods
+// and removes history older than retention.
+func (m *Memory) Compact(ctx context.Context, now time.Time, retention Retention) error {
+	m.Lock()
+	defer m.Unlock()
+
+	minutesEnd := now.Truncate(MinuteResolution)
+	hoursEnd := now.Truncate(HourResolution)
+
+	for key, history := range m.history {
+		// Period ends are exclusive
+		samples := history.raw.between(m.rolled[MinuteResolution], minutesEnd.Add(-1))
+		history.minutes = append(history.minutes, rollUp(sampleRollups(samples), MinuteResolution)...)
+
+		minutes := rollupsBetween(history.minutes, m.rolled[HourResolution], hoursEnd.Add(-1))
+		history.hours = append(history.hours, rollUp(minutes, HourResolution)...)
+
+		history.raw.dropBefore(now.Add(-retention.Raw))
+		history.minutes = rollupsBetween(history.minutes, now.Add(-retention.Minute), now)
+		history.hours = rollupsBetween(history.hours, now.Add(-retention.Hour), now)
+
+		if history.raw.n == 0 && len(history.minutes) == 0 && len(history.hours) == 0 {
+			delete(m.history, key)
+		}
+	}
+
+	m.rolled[MinuteResolution] = minutesEnd
+	m.rolled[HourResolution] = hoursEnd
+
+	return nil
+}
+
 func (m *Memory) Close() {
 }
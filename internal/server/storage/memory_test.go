@@ -3,8 +3,11 @@ package storage
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/horseinthesky/metricsagent/internal/sketch"
 )
 
 func TestUnsupportedType(t *testing.T) {
@@ -75,17 +78,204 @@ func TestMemoryDB(t *testing.T) {
 	err = db.Set(gauge)
 	require.NoError(t, err)
 
-	dbCounter, err := db.Get(ctx, "testCounter")
+	dbCounter, err := db.Get(ctx, Key{ID: "testCounter"})
 	require.NoError(t, err)
 	require.Equal(t, int64(50), *dbCounter.Delta)
 
-	notExists, err := db.Get(ctx, "notExists")
-	require.Error(t, err)
+	notExists, err := db.Get(ctx, Key{ID: "notExists"})
+	require.ErrorIs(t, err, ErrNotFound)
 	require.Empty(t, notExists)
 
 	dbMetrics, err := db.GetAll(ctx)
 	require.NoError(t, err)
 
-	dbGauge := dbMetrics["testGauge"]
+	dbGauge := dbMetrics[Key{ID: "testGauge"}]
 	require.Equal(t, gaugeValue, *dbGauge.Value)
 }
+
+func TestMemoryBatch(t *testing.T) {
+	db := NewMemoryStorage()
+
+	ctx := context.Background()
+
+	newBatch := func() []Metric {
+		delta := int64(10)
+		return []Metric{{ID: "testCounter", MType: "counter", Delta: &delta}}
+	}
+
+	err := db.SetBatch("10.0.0.1", "batch1", newBatch())
+	require.NoError(t, err)
+
+	err = db.SetBatch("10.0.0.1", "batch1", newBatch())
+	require.ErrorIs(t, err, ErrDuplicateBatch)
+
+	// Batch IDs are scoped by source
+	err = db.SetBatch("10.0.0.2", "batch1", newBatch())
+	require.NoError(t, err)
+
+	dbCounter, err := db.Get(ctx, Key{ID: "testCounter"})
+	require.NoError(t, err)
+	require.Equal(t, int64(20), *dbCounter.Delta)
+}
+
+func TestMemoryHosts(t *testing.T) {
+	db := NewMemoryStorage()
+
+	ctx := context.Background()
+
+	for _, host := range []string{"host1", "host2"} {
+		delta := int64(10)
+		err := db.Set(Metric{ID: "testCounter", Host: host, MType: "counter", Delta: &delta})
+		require.NoError(t, err)
+	}
+
+	dbCounter, err := db.Get(ctx, Key{Host: "host1", ID: "testCounter"})
+	require.NoError(t, err)
+	require.Equal(t, int64(10), *dbCounter.Delta)
+
+	_, err = db.Get(ctx, Key{ID: "testCounter"})
+	require.Error(t, err)
+
+	dbMetrics, err := db.GetAll(ctx)
+	require.NoError(t, err)
+	require.Len(t, dbMetrics, 2)
+}
+
+func TestMemoryLabels(t *testing.T) {
+	db := NewMemoryStorage()
+
+	ctx := context.Background()
+
+	for _, cpu := range []string{"0", "1"} {
+		value := float64(10)
+		err := db.Set(Metric{ID: "testGauge", Labels: map[string]string{"cpu": cpu}, MType: "gauge", Value: &value})
+		require.NoError(t, err)
+	}
+
+	key := Metric{ID: "testGauge", Labels: map[string]string{"cpu": "1"}}.Key()
+	require.Equal(t, `testGauge{cpu="1"}`, key.String())
+
+	dbGauge, err := db.Get(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"cpu": "1"}, dbGauge.Labels)
+
+	dbMetrics, err := db.GetAll(ctx)
+	require.NoError(t, err)
+	require.Len(t, dbMetrics, 2)
+}
+
+func TestMemoryHistogram(t *testing.T) {
+	db := NewMemoryStorage()
+
+	ctx := context.Background()
+
+	for _, v := range []float64{0.5, 5} {
+		histogram := NewHistogram([]float64{1})
+		histogram.Observe(v)
+
+		err := db.Set(Metric{ID: "testHistogram", MType: "histogram", Histogram: histogram})
+		require.NoError(t, err)
+	}
+
+	dbHistogram, err := db.Get(ctx, Key{ID: "testHistogram"})
+	require.NoError(t, err)
+	require.Equal(t, []uint64{1, 1}, dbHistogram.Histogram.Counts)
+	require.Equal(t, uint64(2), dbHistogram.Histogram.Count)
+	require.Equal(t, 5.5, dbHistogram.Histogram.Sum)
+
+	// Stored histogram is not changed by later merges
+	histogram := NewHistogram([]float64{1})
+	histogram.Observe(0.5)
+
+	err = db.Set(Metric{ID: "testHistogram", MType: "histogram", Histogram: histogram})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), dbHistogram.Histogram.Count)
+}
+
+func TestMemorySummary(t *testing.T) {
+	db := NewMemoryStorage()
+
+	ctx := context.Background()
+
+	for _, v := range []float64{10, 1000} {
+		summary := sketch.New(sketch.DefaultRelativeAccuracy)
+		summary.Add(v)
+
+		err := db.Set(Metric{ID: "testSummary", MType: "summary", Summary: summary})
+		require.NoError(t, err)
+	}
+
+	dbSummary, err := db.Get(ctx, Key{ID: "testSummary"})
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), dbSummary.Summary.Count)
+	require.InEpsilon(t, 1000, dbSummary.Summary.Quantile(1), sketch.DefaultRelativeAccuracy)
+
+	dbSummary.SetQuantiles()
+	require.Len(t, dbSummary.Quantiles, len(ReportedQuantiles))
+	require.InEpsilon(t, 10, dbSummary.Quantiles["0.5"], sketch.DefaultRelativeAccuracy)
+}
+
+func TestMemoryRange(t *testing.T) {
+	db := NewMemoryStorage()
+
+	ctx := context.Background()
+	start := time.Now()
+
+	for _, delta := range []int64{1, 2} {
+		delta := delta
+		err := db.Set(Metric{ID: "testCounter", MType: "counter", Delta: &delta})
+		require.NoError(t, err)
+	}
+
+	samples, err := db.Range(ctx, Key{ID: "testCounter"}, RawResolution, start, time.Now())
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+
+	// Counter samples are running totals
+	require.Equal(t, float64(1), samples[0].Last)
+	require.Equal(t, float64(3), samples[1].Last)
+
+	samples, err = db.Range(ctx, Key{ID: "testCounter"}, RawResolution, start.Add(-time.Hour), start.Add(-time.Minute))
+	require.NoError(t, err)
+	require.Empty(t, samples)
+}
+
+func TestMemoryCompact(t *testing.T) {
+	db := NewMemoryStorage()
+
+	ctx := context.Background()
+	start := time.Now()
+	retention := Retention{Raw: time.Hour, Minute: 24 * time.Hour, Hour: 30 * 24 * time.Hour}
+
+	for _, delta := range []int64{1, 2} {
+		delta := delta
+		err := db.Set(Metric{ID: "testCounter", MType: "counter", Delta: &delta})
+		require.NoError(t, err)
+	}
+
+	// Compaction is idempotent
+	for i := 0; i < 2; i++ {
+		err := db.Compact(ctx, start.Add(2*time.Hour), retention)
+		require.NoError(t, err)
+	}
+
+	raw, err := db.Range(ctx, Key{ID: "testCounter"}, RawResolution, start.Add(-time.Hour), start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Empty(t, raw, "raw samples must expire")
+
+	for _, resolution := range []time.Duration{MinuteResolution, HourResolution} {
+		rollups, err := db.Range(ctx, Key{ID: "testCounter"}, resolution, start.Add(-time.Hour), start.Add(time.Hour))
+		require.NoError(t, err)
+		require.NotEmpty(t, rollups)
+
+		total := rollUp(rollups, 24*time.Hour*365)[0]
+		require.Equal(t, uint64(2), total.Count)
+		require.Equal(t, float64(3), total.Delta)
+		require.Equal(t, float64(3), total.Last)
+	}
+
+	// Series history expires completely
+	err = db.Compact(ctx, start.Add(60*24*time.Hour), retention)
+	require.NoError(t, err)
+	require.Empty(t, db.history)
+}
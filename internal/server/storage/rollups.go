@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Series history resolutions.
+// Raw samples are rolled up into minute rollups
+// which are rolled up into hour rollups.
+const (
+	RawResolution    time.Duration = 0
+	MinuteResolution               = time.Minute
+	HourResolution                 = time.Hour
+)
+
+// Retention is how long series history is kept at every resolution.
+type Retention struct {
+	Raw    time.Duration
+	Minute time.Duration
+	Hour   time.Duration
+}
+
+// Validate checks retention is positive and grows with resolution.
+func (r Retention) Validate() error {
+	if r.Raw < MinuteResolution {
+		return fmt.Errorf("raw samples retention must be at least %s", MinuteResolution)
+	}
+
+	if r.Minute < r.Raw || r.Hour < r.Minute {
+		return fmt.Errorf("rollups retention must not be shorter than the finer resolution one")
+	}
+
+	return nil
+}
+
+// PickResolution returns the finest resolution
+// history starting from given time is still kept at.
+func PickResolution(retention Retention, now, from time.Time) time.Duration {
+	switch {
+	case !from.Before(now.Add(-retention.Raw)):
+		return RawResolution
+	case !from.Before(now.Add(-retention.Minute)):
+		return MinuteResolution
+	default:
+		return HourResolution
+	}
+}
+
+// finerResolution returns resolution rollups of given one are rolled up from.
+func finerResolution(resolution time.Duration) time.Duration {
+	if resolution == HourResolution {
+		return MinuteResolution
+	}
+
+	return RawResolution
+}
+
+// History returns series history at given resolution
+// between from and to in time order.
+// Rollups exist only for periods already compacted, so periods
+// after the latest rollup are rolled up from the finer resolution history.
+func History(ctx context.Context, db Storage, key Key, resolution time.Duration, from, to time.Time) ([]Rollup, error) {
+	rollups, err := db.Range(ctx, key, resolution, from, to)
+	if err != nil || resolution == RawResolution {
+		return rollups, err
+	}
+
+	// Tail periods start within range as rollups do
+	tailFrom := from.Truncate(resolution)
+	if tailFrom.Before(from) {
+		tailFrom = tailFrom.Add(resolution)
+	}
+	if len(rollups) != 0 {
+		tailFrom = rollups[len(rollups)-1].Time.Add(resolution)
+	}
+
+	if tailFrom.After(to) {
+		return rollups, nil
+	}
+
+	tail, err := History(ctx, db, key, finerResolution(resolution), tailFrom, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(rollups, rollUp(tail, resolution)...), nil
+}
+
+// Rollup aggregates series samples over a period starting at Time.
+// Raw samples are represented as single sample rollups.
+type Rollup struct {
+	Time  time.Time
+	Min   float64
+	Max   float64
+	Sum   float64 // sum of sample values
+	Count uint64  // number of samples
+	Last  float64 // the latest sample value
+	Delta float64 // sum of counter increments
+}
+
+// Aggregations are rollup values range queries may return.
+// Sum is a counter increase, it is zero for gauges.
+var Aggregations = []string{"last", "min", "max", "avg", "sum"}
+
+// Value returns rollup aggregated value.
+// Unknown aggregation is the latest sample value.
+func (r Rollup) Value(aggregation string) float64 {
+	switch aggregation {
+	case "min":
+		return r.Min
+	case "max":
+		return r.Max
+	case "avg":
+		return r.Sum / float64(r.Count)
+	case "sum":
+		return r.Delta
+	default:
+		return r.Last
+	}
+}
+
+// merge adds a later rollup to rollup.
+func (r *Rollup) merge(later Rollup) {
+	r.Min = math.Min(r.Min, later.Min)
+	r.Max = math.Max(r.Max, later.Max)
+	r.Sum += later.Sum
+	r.Count += later.Count
+	r.Last = later.Last
+	r.Delta += later.Delta
+}
+
+// sampleRollup returns a single sample rollup.
+func sampleRollup(sample Sample) Rollup {
+	return Rollup{
+		Time:  sample.Time,
+		Min:   sample.Value,
+		Max:   sample.Value,
+		Sum:   sample.Value,
+		Count: 1,
+		Last:  sample.Value,
+		Delta: sample.Delta,
+	}
+}
+
+// sampleRollups returns single sample rollups of raw samples.
+func sampleRollups(samples []Sample) []Rollup {
+	rollups := make([]Rollup, 0, len(samples))
+	for _, sample := range samples {
+		rollups = append(rollups, sampleRollup(sample))
+	}
+
+	return rollups
+}
+
+// rollUp aggregates time ordered rollups of a series into periods.
+// Rollup time is its period start.
+func rollUp(rollups []Rollup, period time.Duration) []Rollup {
+	result := []Rollup{}
+
+	for _, rollup := range rollups {
+		start := rollup.Time.Truncate(period)
+
+		if len(result) != 0 && result[len(result)-1].Time.Equal(start) {
+			result[len(result)-1].merge(rollup)
+			continue
+		}
+
+		rollup.Time = start
+		result = append(result, rollup)
+	}
+
+	return result
+}
+
+// Point is a range query result value at a point in time.
+type Point struct {
+	Time  time.Time `json:"time"`
+	Value float64   `json:"value"`
+}
+
+// Points returns rollups aggregated values.
+func Points(rollups []Rollup, aggregation string) []Point {
+	points := make([]Point, 0, len(rollups))
+	for _, rollup := range rollups {
+		points = append(points, Point{Time: rollup.Time, Value: rollup.Value(aggregation)})
+	}
+
+	return points
+}
+
+// Downsample returns a point per step between from and to.
+// Point at t aggregates rollups in (t-step, t],
+// steps with no rollups are skipped.
+// Rollups must be in time order.
+func Downsample(rollups []Rollup, from, to time.Time, step time.Duration, aggregation string) []Point {
+	points := []Point{}
+
+	i := 0
+	for t := from; !t.After(to); t = t.Add(step) {
+		var (
+			window Rollup
+			found  bool
+		)
+
+		for ; i < len(rollups) && !rollups[i].Time.After(t); i++ {
+			if !rollups[i].Time.After(t.Add(-step)) {
+				continue
+			}
+
+			if !found {
+				window = rollups[i]
+				found = true
+				continue
+			}
+
+			window.merge(rollups[i])
+		}
+
+		if found {
+			points = append(points, Point{Time: t, Value: window.Value(aggregation)})
+		}
+	}
+
+	return points
+}
@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRollUp(t *testing.T) {
+	start := time.Unix(3600, 0)
+
+	samples := []Sample{
+		{Time: start.Add(10 * time.Second), Value: 1, Delta: 1},
+		{Time: start.Add(20 * time.Second), Value: 3, Delta: 2},
+		{Time: start.Add(70 * time.Second), Value: 4, Delta: 1},
+	}
+
+	minutes := rollUp(sampleRollups(samples), MinuteResolution)
+	require.Equal(t, []Rollup{
+		{Time: start, Min: 1, Max: 3, Sum: 4, Count: 2, Last: 3, Delta: 3},
+		{Time: start.Add(time.Minute), Min: 4, Max: 4, Sum: 4, Count: 1, Last: 4, Delta: 1},
+	}, minutes)
+
+	hours := rollUp(minutes, HourResolution)
+	require.Equal(t, []Rollup{
+		{Time: start, Min: 1, Max: 4, Sum: 8, Count: 3, Last: 4, Delta: 4},
+	}, hours)
+
+	require.Equal(t, float64(1), hours[0].Value("min"))
+	require.Equal(t, float64(4), hours[0].Value("max"))
+	require.InDelta(t, 8.0/3, hours[0].Value("avg"), 1e-9)
+	require.Equal(t, float64(4), hours[0].Value("sum"))
+	require.Equal(t, float64(4), hours[0].Value("last"))
+}
+
+func TestDownsample(t *testing.T) {
+	start := time.Unix(1000, 0)
+
+	samples := []Sample{
+		{Time: start.Add(1 * time.Second), Value: 1},
+		{Time: start.Add(4 * time.Second), Value: 4},
+		{Time: start.Add(5 * time.Second), Value: 5},
+		{Time: start.Add(21 * time.Second), Value: 21},
+	}
+
+	require.Equal(t, []Point{
+		{Time: start.Add(5 * time.Second), Value: 5},
+		{Time: start.Add(25 * time.Second), Value: 21},
+	}, Downsample(sampleRollups(samples), start.Add(5*time.Second), start.Add(30*time.Second), 5*time.Second, "last"))
+
+	require.Equal(t, []Point{
+		{Time: start.Add(5 * time.Second), Value: 1},
+		{Time: start.Add(25 * time.Second), Value: 21},
+	}, Downsample(sampleRollups(samples), start.Add(5*time.Second), start.Add(30*time.Second), 5*time.Second, "min"))
+}
+
+func TestPickResolution(t *testing.T) {
+	now := time.Now()
+	retention := Retention{Raw: time.Hour, Minute: 24 * time.Hour, Hour: 30 * 24 * time.Hour}
+
+	require.NoError(t, retention.Validate())
+	require.Error(t, Retention{Raw: time.Hour, Minute: time.Minute, Hour: time.Hour}.Validate())
+
+	require.Equal(t, RawResolution, PickResolution(retention, now, now.Add(-time.Minute)))
+	require.Equal(t, MinuteResolution, PickResolution(retention, now, now.Add(-2*time.Hour)))
+	require.Equal(t, HourResolution, PickResolution(retention, now, now.Add(-48*time.Hour)))
+}
+
+func TestHistory(t *testing.T) {
+	db := NewMemoryStorage()
+
+	ctx := context.Background()
+	start := time.Now()
+	retention := Retention{Raw: time.Hour, Minute: 24 * time.Hour, Hour: 30 * 24 * time.Hour}
+	key := Key{ID: "testCounter"}
+
+	delta := int64(1)
+	require.NoError(t, db.Set(Metric{ID: "testCounter", MType: "counter", Delta: &delta}))
+	require.NoError(t, db.Compact(ctx, start.Add(2*time.Minute), retention))
+
+	// Sample of a period not rolled up yet
+	db.history[key].raw.add(Sample{Time: start.Add(3 * time.Minute), Value: 3, Delta: 2})
+
+	for _, resolution := range []time.Duration{MinuteResolution, HourResolution} {
+		rollups, err := History(ctx, db, key, resolution, start.Add(-time.Hour), start.Add(time.Hour))
+		require.NoError(t, err)
+		require.NotEmpty(t, rollups)
+
+		total := rollUp(rollups, 24*time.Hour*365)[0]
+		require.Equal(t, uint64(2), total.Count)
+		require.Equal(t, float64(3), total.Delta)
+		require.Equal(t, float64(3), total.Last)
+	}
+
+	rollups, err := History(ctx, db, key, MinuteResolution, start.Add(-time.Hour), start.Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, rollups, 2)
+	require.Equal(t, start.Add(3*time.Minute).Truncate(MinuteResolution), rollups[1].Time)
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"time"
+)
+
+// HistorySize is how many most recent raw samples of a series
+// memory storage keeps at most regardless of retention.
+const HistorySize = 10800
+
+// Sample is a series value at a point in time.
+// Counter samples are running totals, gauge samples are values.
+// Histograms and summaries have no samples.
+type Sample struct {
+	Time  time.Time
+	Value float64
+	Delta float64 // counter increment the sample is recorded for
+}
+
+// newSample returns a sample of a stored metric if it has one.
+// Delta is a counter increment which produced stored total.
+func newSample(metric Metric, delta *int64, at time.Time) (Sample, bool) {
+	switch {
+	case metric.MType == Counter.String() && metric.Delta != nil:
+		sample := Sample{Time: at, Value: float64(*metric.Delta)}
+		if delta != nil {
+			sample.Delta = float64(*delta)
+		}
+
+		return sample, true
+	case metric.MType == Gauge.String() && metric.Value != nil:
+		return Sample{Time: at, Value: *metric.Value}, true
+	}
+
+	return Sample{}, false
+}
+
+// ring is a fixed size buffer of the most recent series samples.
+// Buffer grows up to its size and then wraps replacing the oldest samples.
+type ring struct {
+	samples []Sample
+	size    int
+	head    int // index of the oldest sample
+	n       int // number of samples kept
+}
+
+// newRing is a ring constructor.
+func newRing(size int) *ring {
+	return &ring{size: size}
+}
+
+// at returns i-th oldest sample.
+func (r *ring) at(i int) Sample {
+	return r.samples[(r.head+i)%len(r.samples)]
+}
+
+// add appends a sample replacing the oldest one if buffer is full.
+func (r *ring) add(sample Sample) {
+	switch {
+	case r.n < len(r.samples):
+		r.samples[(r.head+r.n)%len(r.samples)] = sample
+		r.n++
+	case len(r.samples) < r.size:
+		// Buffer doubles up to its size and is linearized as it grows
+		grown := 2 * len(r.samples)
+		if grown == 0 {
+			grown = 1
+		}
+		if grown > r.size {
+			grown = r.size
+		}
+
+		samples := make([]Sample, grown)
+		for i := 0; i < r.n; i++ {
+			samples[i] = r.at(i)
+		}
+		samples[r.n] = sample
+
+		r.samples = samples
+		r.head = 0
+		r.n++
+	default:
+		r.samples[r.head] = sample
+		r.head = (r.head + 1) % len(r.samples)
+	}
+}
+
+// dropBefore removes samples older than t.
+func (r *ring) dropBefore(t time.Time) {
+	for r.n != 0 && r.samples[r.head].Time.Before(t) {
+		r.head = (r.head + 1) % len(r.samples)
+		r.n--
+	}
+}
+
+// between returns samples in [from, to] in time order.
+func (r *ring) between(from, to time.Time) []Sample {
+	samples := []Sample{}
+
+	for i := 0; i < r.n; i++ {
+		sample := r.at(i)
+		if sample.Time.Before(from) || sample.Time.After(to) {
+			continue
+		}
+
+		samples = append(samples, sample)
+	}
+
+	return samples
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRing(t *testing.T) {
+	start := time.Unix(1000, 0)
+	at := func(i int) time.Time { return start.Add(time.Duration(i) * time.Second) }
+
+	r := newRing(3)
+	for i := 0; i < 5; i++ {
+		r.add(Sample{Time: at(i), Value: float64(i)})
+	}
+
+	// Oldest samples are replaced
+	require.Equal(t, []Sample{
+		{Time: at(2), Value: 2},
+		{Time: at(3), Value: 3},
+		{Time: at(4), Value: 4},
+	}, r.between(start, start.Add(time.Minute)))
+
+	require.Equal(t, []Sample{{Time: at(3), Value: 3}}, r.between(at(3), at(3)))
+
+	r.dropBefore(at(4))
+	require.Equal(t, []Sample{{Time: at(4), Value: 4}}, r.between(start, start.Add(time.Minute)))
+
+	// Buffer grows after old samples are dropped
+	r = newRing(3)
+	r.add(Sample{Time: at(0)})
+	r.add(Sample{Time: at(1)})
+	r.dropBefore(at(1))
+	r.add(Sample{Time: at(2)})
+	r.add(Sample{Time: at(3)})
+
+	require.Equal(t, []Sample{{Time: at(1)}, {Time: at(2)}, {Time: at(3)}}, r.between(start, start.Add(time.Minute)))
+
+	// Buffer doubles as it grows
+	r = newRing(100)
+	for i := 0; i < 5; i++ {
+		r.add(Sample{Time: at(i), Value: float64(i)})
+	}
+
+	require.Len(t, r.samples, 8)
+	require.Len(t, r.between(start, start.Add(time.Minute)), 5)
+
+	for i := 5; i < 150; i++ {
+		r.add(Sample{Time: at(i), Value: float64(i)})
+	}
+
+	require.Len(t, r.samples, 100)
+	require.Equal(t, Sample{Time: at(50), Value: 50}, r.at(0))
+}
@@ -0,0 +1,267 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/horseinthesky/metricsagent/internal/server/storage"
+)
+
+// Totals options.
+const (
+	// totalsRetention is how long the last seen total of a series is kept.
+	// Series reported again after that get a baseline as after restart.
+	totalsRetention = time.Hour
+
+	// totalsPruneInterval is how often stale totals are forgotten.
+	totalsPruneInterval = time.Minute
+)
+
+// ErrTotalsLookup is returned when stored series lookup fails
+// while converting totals.
+var ErrTotalsLookup = errors.New("failed to look up stored series")
+
+// Totals converts cumulative counter totals and histograms reported by
+// external sources into increments the storage accumulates.
+// The last seen total of every series is kept in memory.
+//
+// Totals of a single write are converted with a TotalsUpdate.
+// Lock is held only to read and record a single total,
+// so writes don't wait for each other to be stored.
+// A total is recorded as seen as soon as it is converted,
+// so concurrent writes of the same series don't count an increment twice.
+type Totals struct {
+	sync.Mutex
+	entries map[storage.Key]totalsEntry
+	changes uint64
+	pruned  time.Time
+}
+
+// totalsEntry is the last seen total of a series.
+type totalsEntry struct {
+	counter   float64
+	counted   bool
+	histogram *storage.HistogramData
+	seen      time.Time
+	// change is a number of the last change of the entry.
+	change uint64
+}
+
+// NewTotals is a Totals constructor.
+func NewTotals() *Totals {
+	return &Totals{
+		entries: map[storage.Key]totalsEntry{},
+	}
+}
+
+// totalsUndo restores an entry changed by a rolled back update.
+type totalsUndo struct {
+	entry  totalsEntry
+	exists bool
+	change uint64
+}
+
+// TotalsUpdate converts totals of a single write.
+//
+// Totals are committed only once metrics are stored,
+// a rolled back update restores totals it has seen
+// so a retried write gets the same increments.
+// Totals seen by other writes since then are kept.
+type TotalsUpdate struct {
+	totals *Totals
+	lookup func(storage.Key) (bool, error)
+	undo   map[storage.Key]totalsUndo
+	done   bool
+}
+
+// Begin starts a totals update.
+// Lookup checks if a series is already stored,
+// it is called for series never seen before.
+func (t *Totals) Begin(lookup func(storage.Key) (bool, error)) *TotalsUpdate {
+	return &TotalsUpdate{
+		totals: t,
+		lookup: lookup,
+		undo:   map[storage.Key]totalsUndo{},
+	}
+}
+
+// entry returns the last seen total of a series and if it is stored.
+// Stored series are looked up without the lock held
+// for series with no total of that kind seen.
+// Lock is held on return.
+func (u *TotalsUpdate) entry(key storage.Key, seen func(totalsEntry) bool) (totalsEntry, bool, error) {
+	t := u.totals
+
+	t.Lock()
+	entry, ok := t.entries[key]
+	t.Unlock()
+
+	stored := false
+	if !ok || !seen(entry) {
+		var err error
+
+		stored, err = u.lookup(key)
+		if err != nil {
+			return totalsEntry{}, false, fmt.Errorf("%w %s: %s", ErrTotalsLookup, key.ID, err)
+		}
+	}
+
+	t.Lock()
+
+	entry, ok = t.entries[key]
+	if _, changed := u.undo[key]; !changed {
+		u.undo[key] = totalsUndo{entry: entry, exists: ok}
+	}
+
+	return entry, stored, nil
+}
+
+// record records a seen total and releases the lock.
+func (u *TotalsUpdate) record(key storage.Key, entry totalsEntry) {
+	t := u.totals
+	defer t.Unlock()
+
+	t.changes++
+	entry.change = t.changes
+	entry.seen = time.Now()
+	t.entries[key] = entry
+
+	undo := u.undo[key]
+	undo.change = entry.change
+	u.undo[key] = undo
+}
+
+// Delta returns counter increment since the last seen total.
+// The first total seen for a series is an increment only
+// if the series is not stored yet, otherwise it is a baseline,
+// so totals reported before server restart are not counted twice.
+// Total lower than the last one is a counter reset.
+// Fails if total is not finite or stored series lookup fails.
+func (u *TotalsUpdate) Delta(key storage.Key, total float64) (int64, error) {
+	if math.IsNaN(total) || math.IsInf(total, 0) {
+		return 0, fmt.Errorf("total of %s is not finite", key.ID)
+	}
+
+	entry, stored, err := u.entry(key, func(entry totalsEntry) bool { return entry.counted })
+	if err != nil {
+		return 0, err
+	}
+
+	last, seen := entry.counter, entry.counted
+	entry.counter, entry.counted = total, true
+	u.record(key, entry)
+
+	switch {
+	case !seen && stored:
+		return 0, nil
+	case !seen, total < last:
+		return int64(math.Round(total)), nil
+	default:
+		return int64(math.Round(total) - math.Round(last)), nil
+	}
+}
+
+// HistogramDelta returns histogram increment since the last seen one.
+// The first histogram seen for a series is treated as Delta does with totals,
+// histogram reset or bucket layout change makes it an increment as a whole.
+func (u *TotalsUpdate) HistogramDelta(key storage.Key, total *storage.HistogramData) (*storage.HistogramData, error) {
+	entry, stored, err := u.entry(key, func(entry totalsEntry) bool { return entry.histogram != nil })
+	if err != nil {
+		return nil, err
+	}
+
+	last := entry.histogram
+	entry.histogram = total.Clone()
+	u.record(key, entry)
+
+	if last == nil {
+		if stored {
+			return storage.NewHistogram(total.Bounds), nil
+		}
+
+		return total.Clone(), nil
+	}
+
+	increase, ok := total.Increase(last)
+	if !ok {
+		return total.Clone(), nil
+	}
+
+	return increase, nil
+}
+
+// Commit keeps totals seen by the update.
+// Totals of series not seen for totalsRetention are forgotten.
+func (u *TotalsUpdate) Commit() {
+	if u.done {
+		return
+	}
+	u.done = true
+
+	t := u.totals
+
+	t.Lock()
+	defer t.Unlock()
+
+	now := time.Now()
+	if now.Sub(t.pruned) < totalsPruneInterval {
+		return
+	}
+
+	for key, entry := range t.entries {
+		if now.Sub(entry.seen) > totalsRetention {
+			delete(t.entries, key)
+		}
+	}
+	t.pruned = now
+}
+
+// Rollback restores totals seen by the update
+// unless other updates have seen newer ones.
+// Rollback after Commit does nothing.
+func (u *TotalsUpdate) Rollback() {
+	if u.done {
+		return
+	}
+	u.done = true
+
+	t := u.totals
+
+	t.Lock()
+	defer t.Unlock()
+
+	for key, undo := range u.undo {
+		if t.entries[key].change != undo.change {
+			continue
+		}
+
+		if !undo.exists {
+			delete(t.entries, key)
+			continue
+		}
+
+		t.entries[key] = undo.entry
+	}
+}
+
+// BeginTotals starts converting cumulative totals of a single write
+// to stored increments. Update must be committed once the write is stored
+// and rolled back otherwise.
+// Series never seen before are looked up in the storage one by one.
+func (s *GenericServer) BeginTotals(ctx context.Context) *TotalsUpdate {
+	return s.totals.Begin(func(key storage.Key) (bool, error) {
+		_, err := s.DB.Get(ctx, key)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			return false, nil
+		case err != nil:
+			return false, err
+		}
+
+		return true, nil
+	})
+}
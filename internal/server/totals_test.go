@@ -0,0 +1,186 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/horseinthesky/metricsagent/internal/server/storage"
+)
+
+// storedKeys returns a lookup of stored series.
+func storedKeys(keys ...storage.Key) func(storage.Key) (bool, error) {
+	return func(key storage.Key) (bool, error) {
+		for _, stored := range keys {
+			if key == stored {
+				return true, nil
+			}
+		}
+
+		return false, nil
+	}
+}
+
+// delta converts a single total with a committed update.
+func delta(t *testing.T, totals *Totals, key storage.Key, total float64, stored bool) int64 {
+	lookup := storedKeys()
+	if stored {
+		lookup = storedKeys(key)
+	}
+
+	update := totals.Begin(lookup)
+	defer update.Commit()
+
+	delta, err := update.Delta(key, total)
+	require.NoError(t, err)
+
+	return delta
+}
+
+// updateDelta converts a total with an update.
+func updateDelta(t *testing.T, update *TotalsUpdate, key storage.Key, total float64) int64 {
+	delta, err := update.Delta(key, total)
+	require.NoError(t, err)
+
+	return delta
+}
+
+func TestTotals(t *testing.T) {
+	totals := NewTotals()
+
+	newKey := storage.Key{ID: "requests_total"}
+	require.Equal(t, int64(5), delta(t, totals, newKey, 5, false))
+	require.Equal(t, int64(3), delta(t, totals, newKey, 8, true))
+	require.Equal(t, int64(0), delta(t, totals, newKey, 8, true))
+
+	// Counter reset
+	require.Equal(t, int64(2), delta(t, totals, newKey, 2, true))
+
+	// Series stored before restart gets a baseline
+	storedKey := storage.Key{ID: "errors_total"}
+	require.Equal(t, int64(0), delta(t, totals, storedKey, 10, true))
+	require.Equal(t, int64(1), delta(t, totals, storedKey, 11, true))
+}
+
+func TestTotalsRollback(t *testing.T) {
+	totals := NewTotals()
+	key := storage.Key{ID: "requests_total"}
+
+	update := totals.Begin(storedKeys())
+	require.Equal(t, int64(5), updateDelta(t, update, key, 5))
+	require.Equal(t, int64(2), updateDelta(t, update, key, 7))
+	update.Rollback()
+	update.Commit()
+
+	// Write failed to store is retried with the same increments
+	update = totals.Begin(storedKeys())
+	require.Equal(t, int64(5), updateDelta(t, update, key, 5))
+	require.Equal(t, int64(2), updateDelta(t, update, key, 7))
+	update.Commit()
+	update.Rollback()
+
+	require.Equal(t, int64(1), delta(t, totals, key, 8, true))
+}
+
+func TestTotalsConcurrent(t *testing.T) {
+	totals := NewTotals()
+	key := storage.Key{ID: "requests_total"}
+
+	require.Equal(t, int64(5), delta(t, totals, key, 5, false))
+
+	// Updates in progress don't block each other
+	// and don't count the same increment twice
+	first := totals.Begin(storedKeys(key))
+	second := totals.Begin(storedKeys(key))
+	require.Equal(t, int64(2), updateDelta(t, first, key, 7))
+	require.Equal(t, int64(0), updateDelta(t, second, key, 7))
+	second.Commit()
+	first.Commit()
+
+	require.Equal(t, int64(1), delta(t, totals, key, 8, true))
+
+	// Rollback keeps totals seen by other updates since then
+	first = totals.Begin(storedKeys(key))
+	second = totals.Begin(storedKeys(key))
+	require.Equal(t, int64(1), updateDelta(t, first, key, 9))
+	require.Equal(t, int64(1), updateDelta(t, second, key, 10))
+	first.Rollback()
+	second.Commit()
+
+	require.Equal(t, int64(1), delta(t, totals, key, 11, true))
+}
+
+func TestTotalsErrors(t *testing.T) {
+	totals := NewTotals()
+	key := storage.Key{ID: "requests_total"}
+
+	update := totals.Begin(func(storage.Key) (bool, error) {
+		return false, errors.New("connection refused")
+	})
+	defer update.Rollback()
+
+	// Write fails instead of counting stored series total again
+	_, err := update.Delta(key, 5)
+	require.ErrorIs(t, err, ErrTotalsLookup)
+
+	_, err = update.HistogramDelta(key, storage.NewHistogram([]float64{1}))
+	require.ErrorIs(t, err, ErrTotalsLookup)
+
+	for _, total := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		_, err = update.Delta(key, total)
+		require.Error(t, err)
+		require.NotErrorIs(t, err, ErrTotalsLookup)
+	}
+
+	require.Empty(t, totals.entries)
+}
+
+func TestTotalsPrune(t *testing.T) {
+	totals := NewTotals()
+	key := storage.Key{ID: "requests_total"}
+
+	require.Equal(t, int64(5), delta(t, totals, key, 5, false))
+
+	entry := totals.entries[key]
+	entry.seen = time.Now().Add(-totalsRetention - time.Minute)
+	totals.entries[key] = entry
+	totals.pruned = time.Time{}
+
+	require.Equal(t, int64(1), delta(t, totals, storage.Key{ID: "errors_total"}, 1, false))
+	require.NotContains(t, totals.entries, key)
+}
+
+func TestTotalsHistogram(t *testing.T) {
+	totals := NewTotals()
+
+	histogramDelta := func(key storage.Key, total *storage.HistogramData, stored bool) *storage.HistogramData {
+		lookup := storedKeys()
+		if stored {
+			lookup = storedKeys(key)
+		}
+
+		update := totals.Begin(lookup)
+		defer update.Commit()
+
+		histogram, err := update.HistogramDelta(key, total)
+		require.NoError(t, err)
+
+		return histogram
+	}
+
+	key := storage.Key{ID: "latency"}
+	first := &storage.HistogramData{Bounds: []float64{1}, Counts: []uint64{1, 0}, Sum: 0.5, Count: 1}
+	require.Equal(t, first, histogramDelta(key, first, false))
+
+	second := &storage.HistogramData{Bounds: []float64{1}, Counts: []uint64{1, 1}, Sum: 2.5, Count: 2}
+	require.Equal(t, &storage.HistogramData{Bounds: []float64{1}, Counts: []uint64{0, 1}, Sum: 2, Count: 1}, histogramDelta(key, second, true))
+
+	// Histogram reset
+	require.Equal(t, first, histogramDelta(key, first, true))
+
+	// Series stored before restart gets a baseline
+	require.Equal(t, uint64(0), histogramDelta(storage.Key{ID: "size"}, second, true).Count)
+}
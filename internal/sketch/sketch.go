@@ -0,0 +1,278 @@
+// Package sketch provides a mergeable quantile sketch.
+//
+// Sketch follows DDSketch: values are counted in logarithmic bins
+// so that any quantile is estimated within a relative error
+// of the sketch accuracy. Sketches with the same accuracy are merged
+// by adding bin counts which makes them suitable to aggregate
+// repeated reports.
+//
+// Sketch tracks non-negative values such as durations and sizes.
+// Negative values are counted as zeros.
+// Like DDSketch bounded stores, sketch keeps at most maxBins bins
+// collapsing the lowest ones, and only indexes values
+// whose bin bounds are finite.
+package sketch
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultRelativeAccuracy is an accuracy of sketches built
+// when none is specified.
+const DefaultRelativeAccuracy = 0.01
+
+// Sketch bounds.
+const (
+	// maxBins is the most bins a sketch keeps.
+	maxBins = 2048
+
+	// minNormal is the smallest positive normal float64.
+	minNormal = 0x1p-1022
+)
+
+// Sketch is a quantile sketch of observed values.
+type Sketch struct {
+	RelativeAccuracy float64          `json:"relative_accuracy"`
+	Bins             map[int32]uint64 `json:"bins"`  // observations per logarithmic bin
+	Zeros            uint64           `json:"zeros"` // observations of zero
+	Count            uint64           `json:"count"` // number of observations
+	Sum              float64          `json:"sum"`   // sum of observed values
+}
+
+// New returns an empty sketch with given relative accuracy.
+func New(relativeAccuracy float64) *Sketch {
+	return &Sketch{
+		RelativeAccuracy: relativeAccuracy,
+		Bins:             map[int32]uint64{},
+	}
+}
+
+// gamma returns a ratio of adjacent bin bounds.
+func (s *Sketch) gamma() float64 {
+	return (1 + s.RelativeAccuracy) / (1 - s.RelativeAccuracy)
+}
+
+// indexRange returns the lowest and the highest bin index
+// whose bin bounds are finite normal floats fitting int32.
+func (s *Sketch) indexRange() (float64, float64) {
+	logGamma := math.Log(s.gamma())
+
+	lowest := math.Max(math.MinInt32, math.Ceil(math.Log(minNormal)/logGamma)+1)
+	highest := math.Min(math.MaxInt32, math.Floor(math.Log(math.MaxFloat64)/logGamma)-1)
+
+	return lowest, highest
+}
+
+// Add adds a value to sketch.
+// Values too small to be indexed are counted as zeros,
+// non-finite values and values too large to be indexed are rejected.
+func (s *Sketch) Add(v float64) error {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return errors.New("sketch values must be finite")
+	}
+
+	if v <= 0 {
+		s.Count++
+		s.Zeros++
+		return nil
+	}
+
+	lowest, highest := s.indexRange()
+
+	index := math.Ceil(math.Log(v) / math.Log(s.gamma()))
+	if index > highest {
+		return fmt.Errorf("sketch value %g is too large", v)
+	}
+
+	s.Count++
+
+	if index < lowest {
+		s.Zeros++
+		return nil
+	}
+
+	if s.Bins == nil {
+		s.Bins = map[int32]uint64{}
+	}
+
+	s.Bins[int32(index)]++
+	s.Sum += v
+	s.collapse()
+
+	return nil
+}
+
+// collapse merges the lowest bins into the next ones
+// so that sketch keeps at most maxBins bins.
+func (s *Sketch) collapse() {
+	if len(s.Bins) <= maxBins {
+		return
+	}
+
+	indexes := s.indexes()
+	excess := len(indexes) - maxBins
+
+	for _, i := range indexes[:excess] {
+		s.Bins[indexes[excess]] += s.Bins[i]
+		delete(s.Bins, i)
+	}
+}
+
+// Quantile returns an estimation of q-quantile of observed values.
+// Empty sketch quantiles are zeros.
+func (s *Sketch) Quantile(q float64) float64 {
+	if s.Count == 0 {
+		return 0
+	}
+
+	rank := q * float64(s.Count-1)
+	if rank < float64(s.Zeros) {
+		return 0
+	}
+
+	indexes := s.indexes()
+
+	gamma := s.gamma()
+	seen := s.Zeros
+
+	for _, i := range indexes {
+		seen += s.Bins[i]
+		if float64(seen) > rank {
+			// Bin i counts values in (gamma^(i-1), gamma^i]
+			return 2 * math.Pow(gamma, float64(i)) / (gamma + 1)
+		}
+	}
+
+	if len(indexes) == 0 {
+		return 0
+	}
+
+	return 2 * math.Pow(gamma, float64(indexes[len(indexes)-1])) / (gamma + 1)
+}
+
+// indexes returns bin indexes in ascending order.
+func (s *Sketch) indexes() []int32 {
+	indexes := make([]int32, 0, len(s.Bins))
+	for i := range s.Bins {
+		indexes = append(indexes, i)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+
+	return indexes
+}
+
+// Merge adds other sketch observations.
+// If accuracies differ, sketch is replaced with the other one
+// since bins of different accuracies are incomparable.
+func (s *Sketch) Merge(other *Sketch) {
+	if s.RelativeAccuracy != other.RelativeAccuracy {
+		*s = *other.Clone()
+		return
+	}
+
+	if s.Bins == nil {
+		s.Bins = map[int32]uint64{}
+	}
+
+	for i, count := range other.Bins {
+		s.Bins[i] += count
+	}
+	s.Zeros += other.Zeros
+	s.Count += other.Count
+	s.Sum += other.Sum
+
+	s.collapse()
+}
+
+// Subtract removes other sketch observations previously merged into sketch.
+func (s *Sketch) Subtract(other *Sketch) {
+	if s.RelativeAccuracy != other.RelativeAccuracy {
+		return
+	}
+
+	for i, count := range other.Bins {
+		if s.Bins[i] <= count {
+			delete(s.Bins, i)
+			continue
+		}
+		s.Bins[i] -= count
+	}
+	s.Zeros -= min(s.Zeros, other.Zeros)
+	s.Count -= min(s.Count, other.Count)
+	s.Sum -= other.Sum
+
+	if s.Count == 0 {
+		s.Sum = 0
+	}
+}
+
+// min returns the smallest of two counts.
+func min(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+// Clone returns a deep copy of sketch.
+func (s *Sketch) Clone() *Sketch {
+	clone := *s
+	clone.Bins = make(map[int32]uint64, len(s.Bins))
+	for i, count := range s.Bins {
+		clone.Bins[i] = count
+	}
+
+	return &clone
+}
+
+// Validate checks sketch is consistent.
+func (s *Sketch) Validate() error {
+	if s.RelativeAccuracy <= 0 || s.RelativeAccuracy >= 1 {
+		return errors.New("sketch relative accuracy must be between 0 and 1")
+	}
+
+	if math.IsNaN(s.Sum) || math.IsInf(s.Sum, 0) {
+		return errors.New("sketch sum must be finite")
+	}
+
+	if len(s.Bins) > maxBins {
+		return fmt.Errorf("sketch must have at most %d bins, got %d", maxBins, len(s.Bins))
+	}
+
+	lowest, highest := s.indexRange()
+
+	total := s.Zeros
+	for i, count := range s.Bins {
+		if float64(i) < lowest || float64(i) > highest {
+			return fmt.Errorf("sketch bin index %d is out of range", i)
+		}
+
+		total += count
+	}
+
+	if total != s.Count {
+		return fmt.Errorf("sketch count %d differs from bin counts total %d", s.Count, total)
+	}
+
+	return nil
+}
+
+// String returns canonical sketch form:
+// accuracy, count, sum, zeros and "index=count" bins sorted by index.
+// Canonical form is a part of metric hash data.
+func (s *Sketch) String() string {
+	indexes := s.indexes()
+
+	bins := make([]string, 0, len(indexes))
+	for _, i := range indexes {
+		bins = append(bins, strconv.Itoa(int(i))+"="+strconv.FormatUint(s.Bins[i], 10))
+	}
+
+	return fmt.Sprintf("%f:%d:%f:%d:%s", s.RelativeAccuracy, s.Count, s.Sum, s.Zeros, strings.Join(bins, ","))
+}
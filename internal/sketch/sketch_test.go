@@ -0,0 +1,115 @@
+package sketch
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSketchQuantile(t *testing.T) {
+	s := New(DefaultRelativeAccuracy)
+	for v := 1; v <= 1000; v++ {
+		s.Add(float64(v))
+	}
+
+	require.Equal(t, uint64(1000), s.Count)
+	require.Equal(t, float64(500500), s.Sum)
+	require.NoError(t, s.Validate())
+
+	for q, expected := range map[float64]float64{0.5: 500, 0.9: 900, 0.99: 990} {
+		require.InEpsilon(t, expected, s.Quantile(q), DefaultRelativeAccuracy*1.1)
+	}
+
+	require.Equal(t, float64(0), New(DefaultRelativeAccuracy).Quantile(0.5))
+}
+
+func TestSketchZeros(t *testing.T) {
+	s := New(DefaultRelativeAccuracy)
+	s.Add(0)
+	s.Add(-1)
+	s.Add(10)
+
+	require.Equal(t, uint64(2), s.Zeros)
+	require.Equal(t, float64(0), s.Quantile(0.5))
+	require.InEpsilon(t, 10, s.Quantile(1), DefaultRelativeAccuracy)
+}
+
+func TestSketchMerge(t *testing.T) {
+	a, b := New(DefaultRelativeAccuracy), New(DefaultRelativeAccuracy)
+	for v := 1; v <= 500; v++ {
+		a.Add(float64(v))
+		b.Add(float64(v + 500))
+	}
+
+	a.Merge(b)
+	require.Equal(t, uint64(1000), a.Count)
+	require.InEpsilon(t, 500, a.Quantile(0.5), DefaultRelativeAccuracy*1.1)
+
+	a.Subtract(b)
+	require.Equal(t, uint64(500), a.Count)
+	require.InEpsilon(t, 250, a.Quantile(0.5), DefaultRelativeAccuracy*1.1)
+	require.NoError(t, a.Validate())
+
+	// Accuracy change replaces sketch
+	c := New(0.05)
+	c.Add(1)
+
+	a.Merge(c)
+	require.Equal(t, c, a)
+
+	c.Add(2)
+	require.Equal(t, uint64(1), a.Count, "merged sketch must not share state")
+}
+
+func TestSketchJSON(t *testing.T) {
+	s := New(DefaultRelativeAccuracy)
+	s.Add(0.5)
+	s.Add(100)
+
+	encoded, err := json.Marshal(s)
+	require.NoError(t, err)
+
+	decoded := &Sketch{}
+	require.NoError(t, json.Unmarshal(encoded, decoded))
+	require.Equal(t, s, decoded)
+	require.Equal(t, s.String(), decoded.String())
+}
+
+func TestSketchValidate(t *testing.T) {
+	require.Error(t, New(0).Validate())
+	require.Error(t, (&Sketch{RelativeAccuracy: 0.01, Count: 1}).Validate())
+	require.NoError(t, (&Sketch{RelativeAccuracy: 0.01, Zeros: 1, Count: 1}).Validate())
+	require.Error(t, (&Sketch{RelativeAccuracy: 0.01, Zeros: 1, Count: 1, Sum: math.NaN()}).Validate())
+	require.Error(t, (&Sketch{RelativeAccuracy: 0.01, Bins: map[int32]uint64{math.MaxInt32: 1}, Count: 1}).Validate())
+
+	bins := map[int32]uint64{}
+	for i := 0; i <= maxBins; i++ {
+		bins[int32(i)] = 1
+	}
+	require.Error(t, (&Sketch{RelativeAccuracy: 0.01, Bins: bins, Count: maxBins + 1}).Validate())
+}
+
+func TestSketchBounds(t *testing.T) {
+	s := New(DefaultRelativeAccuracy)
+
+	for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1), math.MaxFloat64} {
+		require.Error(t, s.Add(v))
+	}
+	require.Zero(t, s.Count)
+
+	// Values too small to be indexed are zeros
+	require.NoError(t, s.Add(math.SmallestNonzeroFloat64))
+	require.Equal(t, uint64(1), s.Zeros)
+
+	// The lowest bins are collapsed
+	s = New(0.0001)
+	for v := 1.0; len(s.Bins) < maxBins; v *= 1.001 {
+		require.NoError(t, s.Add(v))
+	}
+	require.NoError(t, s.Add(1e6))
+	require.Len(t, s.Bins, maxBins)
+	require.NoError(t, s.Validate())
+	require.InEpsilon(t, 1e6, s.Quantile(1), 0.0001)
+}
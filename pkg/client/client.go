@@ -0,0 +1,371 @@
+// Package client provides a library to push application metrics
+// to metricsagent server.
+//
+// Metrics are batched in memory and flushed periodically
+// over HTTP /updates/ endpoint or gRPC UpdateMetrics call:
+//
+//	c, err := client.New(client.Config{Address: "localhost:8080"})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	defer c.Close()
+//
+//	go c.Run(ctx)
+//
+//	c.Counter("Requests").Add(1)
+//	c.Counter("Requests").With(map[string]string{"code": "500"}).Add(1)
+//	c.Gauge("QueueSize").Set(10)
+//
+// It consists of the following parts:
+//   - client.go - client struct, metric handles and batching
+//   - send.go - HTTP and gRPC senders
+//   - secure.go - metrics hash protection
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/horseinthesky/metricsagent/internal/crypto"
+	"github.com/horseinthesky/metricsagent/internal/labels"
+)
+
+// Client default config options.
+const (
+	defaultFlushInterval = 10 * time.Second
+	defaultTimeout       = 1 * time.Second
+)
+
+// Config is a Client configuration.
+type Config struct {
+	Address       string            // server address, e.g. localhost:8080
+	Host          string            // host metrics are reported by, hostname by default
+	Labels        map[string]string // labels added to every metric
+	GRPC          bool              // use gRPC instead of HTTP
+	Key           string            // HMAC key, same as server has
+	CryptoKey     string            // RSA public key path, HTTP only
+	FlushInterval time.Duration     // Run flush interval
+	Timeout       time.Duration     // single flush timeout
+}
+
+// Metric is an object to marshal metrics to.
+type Metric struct {
+	ID     string            `json:"id"`               // metric name
+	Host   string            `json:"host,omitempty"`   // host metric is reported by
+	Labels map[string]string `json:"labels,omitempty"` // metric labels
+	MType  string            `json:"type"`             // metric type, gauge/counter
+	Delta  *int64            `json:"delta,omitempty"`  // metric value if it has a type of counter
+	Value  *float64          `json:"value,omitempty"`  // metric value if it has a type of gauge
+	Hash   string            `json:"hash,omitempty"`   // hash value
+}
+
+// series identifies a batched metric by name and canonical label set.
+type series struct {
+	name   string
+	labels string
+}
+
+// batch is a set of metrics sent in one request.
+// Server applies a batch with the same ID only once.
+type batch struct {
+	id      string
+	metrics []Metric
+}
+
+// newBatchID generates a random batch ID.
+func newBatchID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
+// sender delivers a batch of metrics to server.
+// Batches server rejected are reported with rejectedError.
+type sender interface {
+	send(ctx context.Context, b batch) error
+	close() error
+}
+
+// Client batches metrics and sends them to server.
+type Client struct {
+	sync.Mutex
+	key           string
+	host          string
+	labels        map[string]string
+	flushInterval time.Duration
+	timeout       time.Duration
+	sender        sender
+	counters      map[series]int64
+	gauges        map[series]float64
+	flushLock     sync.Mutex
+	unacked       *batch
+}
+
+// New is a Client constructor.
+func New(cfg Config) (*Client, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("no server address provided")
+	}
+
+	if cfg.FlushInterval == 0 {
+		cfg.FlushInterval = defaultFlushInterval
+	}
+
+	if cfg.Timeout == 0 {
+		cfg.Timeout = defaultTimeout
+	}
+
+	if err := labels.Validate(cfg.Labels); err != nil {
+		return nil, err
+	}
+
+	if cfg.Host == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get hostname: %w", err)
+		}
+
+		cfg.Host = hostname
+	}
+
+	var (
+		s   sender
+		err error
+	)
+
+	if cfg.GRPC {
+		s, err = newGRPCSender(cfg.Address)
+	} else {
+		var pubKey *rsa.PublicKey
+		if cfg.CryptoKey != "" {
+			pubKey, err = crypto.ParsePubKey(cfg.CryptoKey)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		s, err = newHTTPSender(cfg.Address, cfg.Timeout, pubKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return newClient(cfg, s), nil
+}
+
+// newClient builds a Client around a sender.
+func newClient(cfg Config, s sender) *Client {
+	return &Client{
+		key:           cfg.Key,
+		host:          cfg.Host,
+		labels:        cfg.Labels,
+		flushInterval: cfg.FlushInterval,
+		timeout:       cfg.Timeout,
+		sender:        s,
+		counters:      map[series]int64{},
+		gauges:        map[series]float64{},
+	}
+}
+
+// withLabels returns series with labels added.
+// Labels override the ones series already has.
+func (s series) withLabels(extra map[string]string) series {
+	merged, _ := labels.Parse(s.labels)
+	if merged == nil {
+		merged = map[string]string{}
+	}
+
+	for name, value := range extra {
+		merged[name] = value
+	}
+
+	s.labels = labels.Format(merged)
+
+	return s
+}
+
+// Counter is a counter metric handle.
+type Counter struct {
+	series series
+	client *Client
+}
+
+// Counter returns a counter handle.
+func (c *Client) Counter(name string) *Counter {
+	return &Counter{series: series{name: name}, client: c}
+}
+
+// With returns a handle of the counter series with labels added.
+// Label names must be valid, see labels.Validate.
+func (c *Counter) With(labels map[string]string) *Counter {
+	return &Counter{series: c.series.withLabels(labels), client: c.client}
+}
+
+// Add increments counter by n.
+func (c *Counter) Add(n int64) {
+	c.client.Lock()
+	defer c.client.Unlock()
+
+	c.client.counters[c.series] += n
+}
+
+// Gauge is a gauge metric handle.
+type Gauge struct {
+	series series
+	client *Client
+}
+
+// Gauge returns a gauge handle.
+func (c *Client) Gauge(name string) *Gauge {
+	return &Gauge{series: series{name: name}, client: c}
+}
+
+// With returns a handle of the gauge series with labels added.
+// Label names must be valid, see labels.Validate.
+func (g *Gauge) With(labels map[string]string) *Gauge {
+	return &Gauge{series: g.series.withLabels(labels), client: g.client}
+}
+
+// Set sets gauge value.
+func (g *Gauge) Set(v float64) {
+	g.client.Lock()
+	defer g.client.Unlock()
+
+	g.client.gauges[g.series] = v
+}
+
+// Flush sends batched metrics to server.
+// Batch which failed to send is resent with the same ID
+// before a new one, so server skips it if it was applied
+// but the response was lost. Batches server rejected are dropped.
+func (c *Client) Flush(ctx context.Context) error {
+	c.flushLock.Lock()
+	defer c.flushLock.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	if c.unacked != nil {
+		if err := c.deliver(ctx, *c.unacked); err != nil {
+			return err
+		}
+	}
+
+	c.Lock()
+	counters, gauges := c.counters, c.gauges
+	c.counters, c.gauges = map[series]int64{}, map[series]float64{}
+	c.Unlock()
+
+	if len(counters) == 0 && len(gauges) == 0 {
+		return nil
+	}
+
+	metrics := make([]Metric, 0, len(counters)+len(gauges))
+	for s, delta := range counters {
+		delta := delta
+		metrics = append(metrics, c.prepare(s, Metric{MType: "counter", Delta: &delta}))
+	}
+	for s, value := range gauges {
+		value := value
+		metrics = append(metrics, c.prepare(s, Metric{MType: "gauge", Value: &value}))
+	}
+
+	return c.deliver(ctx, batch{id: newBatchID(), metrics: metrics})
+}
+
+// deliver sends a batch keeping it unacknowledged if sending fails.
+// Caller must hold the flush lock.
+func (c *Client) deliver(ctx context.Context, b batch) error {
+	c.unacked = nil
+
+	err := c.sender.send(ctx, b)
+	if err != nil && !isRejected(err) {
+		c.unacked = &b
+	}
+
+	return err
+}
+
+// prepare sets metric name, host and labels
+// and adds hash if hash key is provided.
+// Series labels override client labels.
+func (c *Client) prepare(s series, metric Metric) Metric {
+	metric.ID = s.name
+	metric.Host = c.host
+	metric.Labels = c.labels
+
+	if s.labels != "" {
+		metric.Labels = map[string]string{}
+		for name, value := range c.labels {
+			metric.Labels[name] = value
+		}
+
+		seriesLabels, _ := labels.Parse(s.labels)
+		for name, value := range seriesLabels {
+			metric.Labels[name] = value
+		}
+	}
+
+	if c.key == "" {
+		return metric
+	}
+
+	return addHash(metric, c.key)
+}
+
+// rejectedError is a send error resending the same batch won't fix.
+type rejectedError struct {
+	err error
+}
+
+func (e rejectedError) Error() string {
+	return e.err.Error()
+}
+
+func (e rejectedError) Unwrap() error {
+	return e.err
+}
+
+// isRejected checks if server rejected a batch.
+func isRejected(err error) bool {
+	var rejected rejectedError
+
+	return errors.As(err, &rejected)
+}
+
+// Run flushes metrics every flush interval until context is done.
+func (c *Client) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.Flush(ctx); err != nil {
+				log.Printf("failed to flush metrics: %s", err)
+			}
+		}
+	}
+}
+
+// Close flushes remaining metrics and releases client resources.
+func (c *Client) Close() error {
+	err := c.Flush(context.Background())
+
+	if closeErr := c.sender.close(); closeErr != nil && err == nil {
+		err = closeErr
+	}
+
+	return err
+}
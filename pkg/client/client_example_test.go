@@ -0,0 +1,24 @@
+package client
+
+import (
+	"context"
+	"log"
+)
+
+func Example() {
+	c, err := New(Config{Address: "localhost:8080"})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go c.Run(ctx)
+
+	requests := c.Counter("Requests")
+	requests.Add(1)
+
+	c.Gauge("QueueSize").Set(10)
+}
@@ -0,0 +1,160 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/horseinthesky/metricsagent/internal/api"
+	"github.com/horseinthesky/metricsagent/internal/gapi"
+	"github.com/horseinthesky/metricsagent/internal/pb"
+	"github.com/horseinthesky/metricsagent/internal/server"
+	"github.com/horseinthesky/metricsagent/internal/server/storage"
+)
+
+// testSender records sent batches and fails while err is set.
+type testSender struct {
+	sent []batch
+	err  error
+}
+
+func (s *testSender) send(ctx context.Context, b batch) error {
+	s.sent = append(s.sent, b)
+
+	return s.err
+}
+
+func (s *testSender) close() error {
+	return nil
+}
+
+func TestAddhash(t *testing.T) {
+	testCounter := int64(15)
+	counterMetric := addHash(Metric{ID: "TestCounter", MType: "counter", Delta: &testCounter}, "testkey")
+	require.Equal(t, "175b2a772fbf2ad97bb515e10f2c24bdaf75860e18f8999c6825be73acd3e6bc", counterMetric.Hash)
+
+	testGauge := float64(15)
+	gaugeMetric := addHash(Metric{ID: "TestGauge", MType: "gauge", Value: &testGauge}, "testkey")
+	require.Equal(t, "7300c53d565107966dd4486f13c76cdeda0e31d7f49a62494e5921f8a0faf417", gaugeMetric.Hash)
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Config{})
+	require.Error(t, err)
+
+	_, err = New(Config{Address: "localhost:8080", CryptoKey: "/not/exists.pem"})
+	require.Error(t, err)
+}
+
+func TestFlushFailed(t *testing.T) {
+	s := &testSender{err: errors.New("server is down")}
+	c := newClient(Config{Timeout: time.Second}, s)
+
+	c.Counter("TestCounter").Add(2)
+	c.Gauge("TestGauge").Set(1)
+
+	err := c.Flush(context.Background())
+	require.Error(t, err)
+
+	c.Counter("TestCounter").Add(3)
+	c.Gauge("TestGauge").Set(5)
+
+	require.Equal(t, int64(3), c.counters[series{name: "TestCounter"}])
+	require.Equal(t, float64(5), c.gauges[series{name: "TestGauge"}])
+
+	// Failed batch is resent with the same ID before a new one
+	s.err = nil
+	require.NoError(t, c.Flush(context.Background()))
+	require.Len(t, s.sent, 3)
+	require.Equal(t, s.sent[0].id, s.sent[1].id)
+	require.Equal(t, s.sent[0].metrics, s.sent[1].metrics)
+	require.NotEqual(t, s.sent[1].id, s.sent[2].id)
+	require.Nil(t, c.unacked)
+
+	// Rejected batch is dropped
+	s.err = rejectedError{errors.New("bad request")}
+	c.Counter("TestCounter").Add(1)
+	require.Error(t, c.Flush(context.Background()))
+	require.Nil(t, c.unacked)
+}
+
+func TestClientHTTP(t *testing.T) {
+	srv, err := api.NewServer(server.Config{Key: "testkey"})
+	require.NoError(t, err)
+
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	c, err := New(Config{
+		Address: strings.TrimPrefix(ts.URL, "http://"),
+		Host:    "testhost",
+		Labels:  map[string]string{"env": "test"},
+		Key:     "testkey",
+	})
+	require.NoError(t, err)
+
+	c.Counter("ClientCounter").Add(2)
+	c.Counter("ClientCounter").Add(3)
+	c.Counter("ClientCounter").With(map[string]string{"code": "500"}).Add(4)
+	c.Gauge("ClientGauge").Set(1.5)
+
+	require.NoError(t, c.Close())
+
+	labeled, err := srv.DB.Get(context.Background(), storage.Metric{
+		ID:     "ClientCounter",
+		Host:   "testhost",
+		Labels: map[string]string{"env": "test", "code": "500"},
+	}.Key())
+	require.NoError(t, err)
+	require.Equal(t, int64(4), *labeled.Delta)
+
+	counter, err := srv.DB.Get(context.Background(), storage.Metric{
+		ID:     "ClientCounter",
+		Host:   "testhost",
+		Labels: map[string]string{"env": "test"},
+	}.Key())
+	require.NoError(t, err)
+	require.Equal(t, int64(5), *counter.Delta)
+
+	gauge, err := srv.DB.Get(context.Background(), storage.Metric{
+		ID:     "ClientGauge",
+		Host:   "testhost",
+		Labels: map[string]string{"env": "test"},
+	}.Key())
+	require.NoError(t, err)
+	require.Equal(t, 1.5, *gauge.Value)
+}
+
+func TestClientGRPC(t *testing.T) {
+	lis := bufconn.Listen(1024 * 1024)
+
+	srv, err := gapi.NewGRPCServer(server.Config{Key: "testkey"})
+	require.NoError(t, err)
+
+	grpcServer := grpc.NewServer()
+	pb.RegisterMetricsAgentServer(grpcServer, srv)
+	go grpcServer.Serve(lis)
+	defer grpcServer.Stop()
+
+	s, err := newGRPCSender("bufnet", grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) {
+		return lis.Dial()
+	}))
+	require.NoError(t, err)
+
+	c := newClient(Config{Host: "testhost", Key: "testkey", Timeout: time.Second}, s)
+
+	c.Counter("ClientCounter").Add(7)
+	require.NoError(t, c.Close())
+
+	counter, err := srv.DB.Get(context.Background(), storage.Key{Host: "testhost", ID: "ClientCounter"})
+	require.NoError(t, err)
+	require.Equal(t, int64(7), *counter.Delta)
+}
@@ -0,0 +1,38 @@
+package client
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/horseinthesky/metricsagent/internal/labels"
+)
+
+// addHash adds hash to metric.
+// Hash is calculated the same way metricsagent does.
+func addHash(metric Metric, hashKey string) Metric {
+	var data string
+
+	h := hmac.New(sha256.New, []byte(hashKey))
+
+	switch metric.MType {
+	case "gauge":
+		data = fmt.Sprintf("%s:%s:%f", metric.ID, metric.MType, *metric.Value)
+	case "counter":
+		data = fmt.Sprintf("%s:%s:%d", metric.ID, metric.MType, *metric.Delta)
+	}
+
+	if metric.Host != "" {
+		data += ":" + metric.Host
+	}
+
+	if len(metric.Labels) != 0 {
+		data += "{" + labels.Format(metric.Labels) + "}"
+	}
+
+	h.Write([]byte(data))
+	metric.Hash = hex.EncodeToString(h.Sum(nil))
+
+	return metric
+}
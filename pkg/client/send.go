@@ -0,0 +1,176 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/horseinthesky/metricsagent/internal/crypto"
+	"github.com/horseinthesky/metricsagent/internal/pb"
+)
+
+// httpSender sends metrics to /updates/ endpoint.
+type httpSender struct {
+	endpoint  string
+	client    *http.Client
+	cryptoKey *rsa.PublicKey
+	localIP   string
+}
+
+// newHTTPSender is a httpSender constructor.
+func newHTTPSender(address string, timeout time.Duration, cryptoKey *rsa.PublicKey) (*httpSender, error) {
+	return &httpSender{
+		endpoint:  fmt.Sprintf("http://%s/updates/", address),
+		client:    &http.Client{Timeout: timeout},
+		cryptoKey: cryptoKey,
+		localIP:   getLocalAddress(address),
+	}, nil
+}
+
+// send sends a batch with its ID in X-Batch-ID header.
+// Client errors mean server rejected the batch.
+func (s *httpSender) send(ctx context.Context, b batch) error {
+	payloadBytes, err := json.Marshal(b.metrics)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metrics: %w", err)
+	}
+
+	if s.cryptoKey != nil {
+		payloadBytes, err = crypto.EncryptWithPublicKey(payloadBytes, s.cryptoKey)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt payload: %w", err)
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, s.endpoint, bytes.NewBuffer(payloadBytes))
+	if err != nil {
+		return fmt.Errorf("failed to build a request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-Batch-ID", b.id)
+
+	if s.localIP != "" {
+		request.Header.Set("X-Real-IP", s.localIP)
+	}
+
+	response, err := s.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to make a request: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		err := fmt.Errorf("unexpected response: %s: %s", response.Status, body)
+
+		if response.StatusCode >= http.StatusBadRequest && response.StatusCode < http.StatusInternalServerError {
+			return rejectedError{err}
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (s *httpSender) close() error {
+	s.client.CloseIdleConnections()
+
+	return nil
+}
+
+// grpcSender sends metrics with UpdateMetrics call.
+type grpcSender struct {
+	conn   *grpc.ClientConn
+	client pb.MetricsAgentClient
+}
+
+// newGRPCSender is a grpcSender constructor.
+func newGRPCSender(address string, opts ...grpc.DialOption) (*grpcSender, error) {
+	opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+
+	conn, err := grpc.Dial(address, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &grpcSender{
+		conn:   conn,
+		client: pb.NewMetricsAgentClient(conn),
+	}, nil
+}
+
+// send sends a batch with its ID in x-batch-id metadata.
+func (s *grpcSender) send(ctx context.Context, b batch) error {
+	pbMetrics := make([]*pb.Metric, 0, len(b.metrics))
+
+	for _, metric := range b.metrics {
+		pbMetric := &pb.Metric{
+			Id:     metric.ID,
+			Host:   metric.Host,
+			Labels: metric.Labels,
+			Mtype:  metric.MType,
+			Hash:   metric.Hash,
+		}
+
+		if metric.Delta != nil {
+			pbMetric.Delta = *metric.Delta
+		}
+
+		if metric.Value != nil {
+			pbMetric.Value = *metric.Value
+		}
+
+		pbMetrics = append(pbMetrics, pbMetric)
+	}
+
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-batch-id", b.id)
+
+	_, err := s.client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{Metrics: pbMetrics})
+	if err != nil {
+		code := status.Code(err)
+		err = fmt.Errorf("failed to send metrics: %w", err)
+
+		switch code {
+		case codes.InvalidArgument, codes.Unimplemented, codes.PermissionDenied, codes.Unauthenticated:
+			return rejectedError{err}
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (s *grpcSender) close() error {
+	return s.conn.Close()
+}
+
+// getLocalAddress gets a source IP address used to reach the server.
+// Server uses it to check the trusted subnet.
+func getLocalAddress(address string) string {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return ""
+	}
+	defer conn.Close()
+
+	localAddr, ok := conn.LocalAddr().(*net.UDPAddr)
+	if !ok {
+		return ""
+	}
+
+	return localAddr.IP.String()
+}